@@ -4,24 +4,40 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/tiakavousi/codeplayground/pkg/config"
 	"github.com/tiakavousi/codeplayground/pkg/container"
+	"github.com/tiakavousi/codeplayground/pkg/engine"
 	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+	"github.com/tiakavousi/codeplayground/pkg/store"
 )
 
 const (
-	defaultExecutionTimeout = 10 * time.Second
-	defaultContainerImage   = "tayebe/repl"
+	defaultContainerImage = "tayebe/repl"
+	defaultSnippetDBPath  = "snippets.db"
+	defaultSnippetTTL     = 30 * 24 * time.Hour
+	maxSnippetTTL         = 365 * 24 * time.Hour
+	maxSnippetCodeSize    = 64 * 1024
+	snippetSweepInterval  = 10 * time.Minute
+	shutdownGracePeriod   = 5 * time.Second
 )
 
+// maxRequestBodySize caps the bodies of /run and /save, leaving room for JSON
+// escaping of the code and for file paths
+const maxRequestBodySize = 2*maxSnippetCodeSize + executor.MaxFiles*(executor.MaxPathLength+64) + 4096
+
 var (
 	upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
@@ -30,20 +46,67 @@ var (
 		},
 	}
 
-	// Global variables for saved code functionality
-	savedCodes = make(map[string]SavedCode)
-	codesMutex sync.RWMutex
+	// Global snippet store for saved code functionality
+	snippets store.SnippetStore
 
 	// Global executor service
 	execService *executor.Service
+
+	// Runner behind execService, nil in tests: a DockerRunner, or a
+	// LocalRunner with RUNNER=local
+	runner codeRunner
+
+	// Languages accepted for execution and saving
+	languages = language.Default()
+
+	// Server settings, including the limits of every execution
+	settings = config.Default()
+
+	// Toolchain versions probed from the images, keyed by language id
+	versionsMu sync.RWMutex
+	versions   map[string]string
 )
 
+// codeRunner runs programs and reports the toolchain versions they run
+// with
+type codeRunner interface {
+	executor.CodeRunner
+	ProbeVersions(ctx context.Context) (map[string]string, error)
+}
+
+// LanguageInfo describes a language in the response of /languages. The
+// version is empty until it has been probed.
+type LanguageInfo struct {
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Aliases       []string         `json:"aliases"`
+	Version       string           `json:"version,omitempty"`
+	Extension     string           `json:"extension,omitempty"`
+	Template      string           `json:"template,omitempty"`
+	Limits        language.Limits  `json:"limits"`
+	CompileLimits *language.Limits `json:"compile_limits,omitempty"`
+}
+
+// SavedCode is the body of /save and the response of /share/:id.
+// Files and Entrypoint are stored as they are sent to /run. ParentID makes
+// the save the next revision of an existing snippet and ExpiresIn
+// overrides the default lifetime in seconds.
 type SavedCode struct {
-	Language string `json:"language"`
-	Code     string `json:"code"`
+	Language   string          `json:"language"`
+	Code       string          `json:"code"`
+	Files      []executor.File `json:"files,omitempty"`
+	Entrypoint string          `json:"entrypoint,omitempty"`
+	ParentID   string          `json:"parent_id,omitempty"`
+	ForkedFrom string          `json:"forked_from,omitempty"`
+	Revision   int             `json:"revision,omitempty"`
+	ExpiresIn  int64           `json:"expires_in,omitempty"`
+	ExpiresAt  *time.Time      `json:"expires_at,omitempty"`
 }
 
 func main() {
+	// The local runner starts this binary as the init of its sandboxes
+	container.RunSandboxInit()
+
 	// Setup logging
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
@@ -53,8 +116,80 @@ func main() {
 		dockerImage = defaultContainerImage
 	}
 
-	dockerRunner := container.NewDockerRunner(dockerImage)
-	execService = executor.NewService(dockerRunner)
+	if path := os.Getenv("LANGUAGES_FILE"); path != "" {
+		var err error
+		languages, err = language.LoadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to load languages: %v", err)
+		}
+	}
+
+	var err error
+	settings, err = config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := settings.CheckLanguages(languages); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	runnerOpts := []container.Option{
+		container.WithRegistry(languages),
+		container.WithLimits(settings.Limits),
+		container.WithPool(settings.Pool),
+	}
+	if window := os.Getenv("OUTPUT_FLUSH_WINDOW"); window != "" {
+		flushWindow, err := time.ParseDuration(window)
+		if err != nil {
+			log.Fatalf("Invalid OUTPUT_FLUSH_WINDOW: %v", err)
+		}
+		runnerOpts = append(runnerOpts, container.WithFlushWindow(flushWindow))
+	}
+	switch client := os.Getenv("DOCKER_CLIENT"); client {
+	case "", "cli":
+	case "api":
+		engineClient, err := engine.NewClient(os.Getenv("DOCKER_HOST"))
+		if err != nil {
+			log.Fatalf("Failed to create Docker Engine client: %v", err)
+		}
+		runnerOpts = append(runnerOpts, container.WithEngine(engineClient))
+	default:
+		log.Fatalf("Invalid DOCKER_CLIENT %q: use cli or api", client)
+	}
+
+	switch kind := os.Getenv("RUNNER"); kind {
+	case "", "docker":
+		runnerOpts = append(runnerOpts, container.WithHardening(settings.Hardening))
+		runner = container.NewDockerRunner(dockerImage, runnerOpts...)
+	case "local":
+		runner, err = container.NewLocalRunner(settings.Local, runnerOpts...)
+		if err != nil {
+			log.Fatalf("Failed to create local runner: %v", err)
+		}
+	default:
+		log.Fatalf("Invalid RUNNER %q: use docker or local", kind)
+	}
+	execService = executor.NewService(runner,
+		executor.WithLanguages(languages),
+		executor.WithTimeout(settings.Limits.ExecutionTimeout))
+	go probeVersions(runner)
+
+	// Initialize the snippet store
+	snippets, err = newSnippetStore()
+	if err != nil {
+		log.Fatalf("Failed to open snippet store: %v", err)
+	}
+	defer snippets.Close()
+
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	go store.Sweep(sweepCtx, snippets, snippetSweepInterval)
+
+	poolCtx, stopPool := context.WithCancel(context.Background())
+	defer stopPool()
+	if dockerRunner, ok := runner.(*container.DockerRunner); ok {
+		go dockerRunner.MaintainPool(poolCtx)
+	}
 
 	// Initialize Gin router
 	router := setupRouter()
@@ -71,6 +206,34 @@ func main() {
 	}
 }
 
+// probeVersions records the toolchain versions reported by the runner
+func probeVersions(runner codeRunner) {
+	probed, err := runner.ProbeVersions(context.Background())
+	if err != nil {
+		log.Printf("Version probe error: %v", err)
+	}
+
+	versionsMu.Lock()
+	defer versionsMu.Unlock()
+	versions = probed
+}
+
+// newSnippetStore creates the store selected by SNIPPET_STORE
+func newSnippetStore() (store.SnippetStore, error) {
+	switch kind := os.Getenv("SNIPPET_STORE"); kind {
+	case "", "bolt":
+		path := os.Getenv("SNIPPET_DB_PATH")
+		if path == "" {
+			path = defaultSnippetDBPath
+		}
+		return store.NewBoltStore(path)
+	case "memory":
+		return store.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown snippet store %q", kind)
+	}
+}
+
 func setupRouter() *gin.Engine {
 	router := gin.New()
 	router.Use(gin.Logger())
@@ -96,8 +259,14 @@ func setupRouter() *gin.Engine {
 
 	// Routes
 	router.GET("/execute", handleWebSocket)
+	router.POST("/run", handleRun)
 	router.POST("/save", handleSaveCode)
 	router.GET("/share/:id", handleGetSavedCode)
+	router.GET("/share/:id/history", handleGetHistory)
+	router.POST("/share/:id/fork", handleForkCode)
+	router.GET("/config/limits", handleGetLimits)
+	router.GET("/languages", handleGetLanguages)
+	router.GET("/metrics/pool", handleGetPoolStats)
 	router.GET("/", handleHealthCheck)
 
 	return router
@@ -107,6 +276,49 @@ func handleHealthCheck(c *gin.Context) {
 	c.String(http.StatusOK, "Welcome to the backend!")
 }
 
+// handleGetLimits reports the limits executions run with, so clients know
+// how far a request may raise them
+func handleGetLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, settings.Limits)
+}
+
+// handleGetPoolStats reports the hits, misses and idle containers of the
+// warm container pool
+func handleGetPoolStats(c *gin.Context) {
+	var stats container.PoolStats
+	if dockerRunner, ok := runner.(*container.DockerRunner); ok {
+		stats = dockerRunner.PoolStats()
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// handleGetLanguages lists the languages the server runs, in definition
+// order, with the limits they run with
+func handleGetLanguages(c *gin.Context) {
+	versionsMu.RLock()
+	defer versionsMu.RUnlock()
+
+	var infos []LanguageInfo
+	for _, lang := range languages.Languages() {
+		run, compile := settings.Limits.Phases(&lang)
+		info := LanguageInfo{
+			ID:        lang.ID,
+			Name:      lang.DisplayName(),
+			Aliases:   append([]string{}, lang.Aliases...),
+			Version:   versions[lang.ID],
+			Extension: lang.Extension(),
+			Template:  lang.Template,
+			Limits:    run,
+		}
+		if len(lang.Compile) > 0 {
+			info.CompileLimits = &compile
+		}
+		infos = append(infos, info)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"languages": infos})
+}
+
 func handleWebSocket(c *gin.Context) {
 	log.Printf(" handleWebSocket CALLED!!!")
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -117,58 +329,71 @@ func handleWebSocket(c *gin.Context) {
 	defer conn.Close()
 
 	// Read initial request
-	var req executor.ExecRequest
-	if err := conn.ReadJSON(&req); err != nil {
+	var start execStart
+	if err := conn.ReadJSON(&start); err != nil {
 		log.Println("JSON read error:", err)
 		return
 	}
 
+	sess := newSession(conn, negotiateVersion(start.Version))
+	if sess.version >= protocolTyped {
+		if err := sess.send(msgStatus, statusData{Version: sess.version}); err != nil {
+			log.Printf("WebSocket write error: %v", err)
+			return
+		}
+	}
+
 	// Create execution context with timeout
-	ctx, cancel := context.WithTimeout(c.Request.Context(), defaultExecutionTimeout)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), settings.Limits.ExecutionTimeout)
 	defer cancel()
 
 	// Create channels for communication
-	input := make(chan string, 5)
-	output := make(chan string, 5)
-	done := make(chan struct{})
+	input := make(chan executor.Input, 5)
+	output := make(chan executor.Output, 5)
+	done := make(chan execOutcome, 1)
 
 	// Execute code
-	go executeCode(ctx, req, input, output, done)
+	go executeCode(ctx, start.ExecRequest, input, output, done)
 
 	// Handle WebSocket communication
-	handleWebSocketCommunication(ctx, conn, input, output, done)
+	handleWebSocketCommunication(ctx, sess, input, output, done)
+}
+
+// execOutcome is what executeCode reports when the run is over
+type execOutcome struct {
+	result executor.ExecutionResult
+	err    error
 }
 
 func executeCode(
-	ctx context.Context, 
-	req executor.ExecRequest, 
-	input chan string, 
-	output chan string, 
-	done chan struct{}) {
-	defer close(done)
-	err := execService.ExecuteInteractive(ctx, req, input, output)
-	if err != nil {
-		select {
-		case output <- "Execution error: " + err.Error():
-		case <-ctx.Done():
-		}
-	}
+	ctx context.Context,
+	req executor.ExecRequest,
+	input chan executor.Input,
+	output chan executor.Output,
+	done chan execOutcome) {
+	result, err := execService.ExecuteInteractive(ctx, req, input, output)
+	done <- execOutcome{result: result, err: err}
 }
 
-func handleWebSocketCommunication(ctx context.Context, conn *websocket.Conn, input chan string, output chan string, done chan struct{}){
+func handleWebSocketCommunication(ctx context.Context, sess *session, input chan executor.Input, output chan executor.Output, done chan execOutcome) {
 	// Handle input from WebSocket
 	go func() {
 		defer close(input)
 		for {
-			_, message, err := conn.ReadMessage()
+			_, message, err := sess.conn.ReadMessage()
 			if err != nil {
 				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 					log.Printf("WebSocket read error: %v", err)
 				}
 				return
 			}
+			msg, err := sess.parseInput(message)
+			if err != nil {
+				sess.send(msgError, err.Error())
+				continue
+			}
 			select {
-			case input <- string(message):
+			case input <- msg:
 			case <-ctx.Done():
 				return
 			}
@@ -176,62 +401,258 @@ func handleWebSocketCommunication(ctx context.Context, conn *websocket.Conn, inp
 	}()
 
 	// Handle output to WebSocket
+	ctxDone := ctx.Done()
+	var grace <-chan time.Time
 	for {
 		select {
-		case line := <-output:
-			if err := conn.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+		case out := <-output:
+			if err := sess.sendOutput(out); err != nil {
 				log.Printf("WebSocket write error: %v", err)
 				return
 			}
-		case <-done:
+		case outcome := <-done:
+			// Flush output buffered before the run finished
+			for len(output) > 0 {
+				sess.sendOutput(<-output)
+			}
+			if outcome.err != nil {
+				sess.send(msgError, outcome.err.Error())
+			}
+			if outcome.result.Reason != "" {
+				sess.send(msgExit, outcome.result)
+			}
 			// Clean shutdown
-			conn.WriteMessage(websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			sess.close("")
 			return
-		case <-ctx.Done():
+		case <-ctxDone:
+			// The runner stops on its own; give it time to report the result
+			ctxDone = nil
+			grace = time.After(shutdownGracePeriod)
+		case <-grace:
 			// Timeout
-			conn.WriteMessage(websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Execution timeout"))
+			sess.close("Execution timeout")
 			return
 		}
 	}
 }
 
+func handleRun(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBodySize)
+
+	var req executor.ExecRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Code is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), settings.Limits.ExecutionTimeout)
+	defer cancel()
+
+	result, err := execService.Execute(ctx, req)
+	if errors.Is(err, executor.ErrInvalidRequest) || errors.Is(err, executor.ErrInvalidLanguage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Timeouts and program failures are reported in the result
+	c.JSON(http.StatusOK, result)
+}
 
 func handleSaveCode(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBodySize)
+
 	var req SavedCode
 	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Code is too large"})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	ttl, err := validateSavedCode(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errCodeTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
 	id, err := generateUniqueID()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate unique ID"})
 		return
 	}
 
-	codesMutex.Lock()
-	savedCodes[id] = req
-	codesMutex.Unlock()
+	now := time.Now().UTC()
+	snippet := store.Snippet{
+		ID:         id,
+		Language:   req.Language,
+		Code:       req.Code,
+		Files:      req.Files,
+		Entrypoint: req.Entrypoint,
+		Revision:   1,
+		CreatedAt:  now,
+	}
+	if req.ParentID != "" {
+		parent, err := snippets.Get(req.ParentID)
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent code not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Snippet read error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load parent code"})
+			return
+		}
+		snippet = store.NextRevision(parent, snippet)
+	}
+	expiresAt := now.Add(ttl)
+	snippet.ExpiresAt = &expiresAt
 
-	c.JSON(http.StatusOK, gin.H{"id": id})
+	if err := snippets.Save(snippet); err != nil {
+		log.Printf("Snippet save error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "revision": snippet.Revision, "expires_at": snippet.ExpiresAt})
+}
+
+// errCodeTooLarge is returned when saved code exceeds maxSnippetCodeSize
+var errCodeTooLarge = fmt.Errorf("code exceeds %d bytes", maxSnippetCodeSize)
+
+// validateSavedCode checks a save request and returns the snippet lifetime.
+// The size limit covers the code and every file together.
+func validateSavedCode(req SavedCode) (time.Duration, error) {
+	if strings.TrimSpace(req.Code) == "" && len(req.Files) == 0 {
+		return 0, executor.ErrEmptyCode
+	}
+	size := len(req.Code)
+	for _, f := range req.Files {
+		size += len(f.Content)
+	}
+	if size > maxSnippetCodeSize {
+		return 0, errCodeTooLarge
+	}
+	lang, ok := languages.Lookup(req.Language)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", executor.ErrInvalidLanguage, req.Language)
+	}
+	if err := executor.ValidateFiles(req.Files); err != nil {
+		return 0, err
+	}
+	if req.Entrypoint != "" && !hasFile(req, lang.SourceFile, req.Entrypoint) {
+		return 0, fmt.Errorf("entrypoint %s is not one of the files", req.Entrypoint)
+	}
+
+	switch {
+	case req.ExpiresIn < 0:
+		return 0, fmt.Errorf("expires_in cannot be negative")
+	case req.ExpiresIn == 0:
+		return defaultSnippetTTL, nil
+	case req.ExpiresIn > int64(maxSnippetTTL/time.Second):
+		return 0, fmt.Errorf("expires_in cannot exceed %d seconds", int64(maxSnippetTTL/time.Second))
+	default:
+		return time.Duration(req.ExpiresIn) * time.Second, nil
+	}
+}
+
+// hasFile reports whether the saved code contains a file at path. The code
+// itself is stored as the language's source file.
+func hasFile(req SavedCode, sourceFile, path string) bool {
+	if req.Code != "" && path == sourceFile {
+		return true
+	}
+	for _, f := range req.Files {
+		if f.Path == path {
+			return true
+		}
+	}
+	return false
 }
 
 func handleGetSavedCode(c *gin.Context) {
 	log.Printf(" handleGetSavedCode CALLED!!! .GET: share/:id ")
 	id := c.Param("id")
 
-	codesMutex.RLock()
-	savedCode, exists := savedCodes[id]
-	codesMutex.RUnlock()
+	snippet, err := snippets.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Code not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Snippet read error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load code"})
+		return
+	}
+
+	saved := SavedCode{
+		Language:   snippet.Language,
+		Code:       snippet.Code,
+		Files:      snippet.Files,
+		Entrypoint: snippet.Entrypoint,
+		ParentID:   snippet.ParentID,
+		ForkedFrom: snippet.ForkedFrom,
+		Revision:   snippet.Revision,
+		ExpiresAt:  snippet.ExpiresAt,
+	}
+	c.JSON(http.StatusOK, saved)
+}
 
-	if !exists {
+func handleGetHistory(c *gin.Context) {
+	revisions, err := store.History(snippets, c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Code not found"})
 		return
 	}
+	if err != nil {
+		log.Printf("Snippet history error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load history"})
+		return
+	}
 
-	c.JSON(http.StatusOK, savedCode)
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+func handleForkCode(c *gin.Context) {
+	source, err := snippets.Get(c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Code not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Snippet read error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load code"})
+		return
+	}
+
+	id, err := generateUniqueID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate unique ID"})
+		return
+	}
+
+	now := time.Now().UTC()
+	fork := store.Fork(source, id, now)
+	expiresAt := now.Add(defaultSnippetTTL)
+	fork.ExpiresAt = &expiresAt
+	if err := snippets.Save(fork); err != nil {
+		log.Printf("Snippet save error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fork code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id})
 }
 
 func generateUniqueID() (string, error) {
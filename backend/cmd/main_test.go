@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/store"
+)
+
+func TestHandleRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	execService = executor.NewService(echoRunner{})
+	router := setupRouter()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantStdout string
+	}{
+		{
+			name:       "With Stdin",
+			body:       `{"language":"python","code":"print(input())","stdin":"ping\n"}`,
+			wantStatus: http.StatusOK,
+			wantStdout: "hello\nping\n",
+		},
+		{
+			name:       "Without Stdin",
+			body:       `{"language":"python","code":"print('hello')"}`,
+			wantStatus: http.StatusOK,
+			wantStdout: "hello\n",
+		},
+		{
+			name:       "Missing Code",
+			body:       `{"language":"python"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Blank Code",
+			body:       `{"language":"python","code":"   "}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Body Too Large",
+			body:       `{"language":"python","code":"print(1)","stdin":"` + strings.Repeat("x", maxRequestBodySize) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("POST /run status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var result executor.ExecutionResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if result.Stdout != tt.wantStdout || result.Stderr != "warning\n" {
+				t.Errorf("POST /run stdout = %q, stderr = %q", result.Stdout, result.Stderr)
+			}
+			if result.ExitCode != 3 || result.Reason != executor.ReasonExited {
+				t.Errorf("POST /run result = %+v, want exit code 3", result)
+			}
+		})
+	}
+}
+
+func TestSaveAndShare(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snippets = store.NewMemoryStore()
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/save", strings.NewReader(`{"language":"python","code":"print('hi')"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /save status = %d: %s", w.Code, w.Body)
+	}
+
+	var saved struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &saved)
+	if saved.ID == "" {
+		t.Fatal("POST /save returned no id")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/share/"+saved.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /share/:id status = %d: %s", w.Code, w.Body)
+	}
+	var got SavedCode
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if got.Language != "python" || got.Code != "print('hi')" || got.Revision != 1 {
+		t.Errorf("GET /share/:id = %+v", got)
+	}
+	if got.ExpiresAt == nil || time.Until(*got.ExpiresAt) < defaultSnippetTTL-time.Minute {
+		t.Errorf("GET /share/:id expires_at = %v, want default TTL", got.ExpiresAt)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/share/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /share/missing status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSaveAndShareFiles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snippets = store.NewMemoryStore()
+	router := setupRouter()
+
+	body := `{"language":"java","files":[{"path":"Main.java","content":"class Main {}"},{"path":"util/Helper.java","content":"class Helper {}"}],"entrypoint":"Main.java"}`
+	var saved struct {
+		ID string `json:"id"`
+	}
+	if status := postJSON(t, router, "/save", body, &saved); status != http.StatusOK {
+		t.Fatalf("POST /save status = %d", status)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/share/"+saved.ID, nil))
+	var got SavedCode
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if len(got.Files) != 2 || got.Files[1].Path != "util/Helper.java" || got.Entrypoint != "Main.java" {
+		t.Errorf("GET /share/:id = %+v", got)
+	}
+
+	var fork struct {
+		ID string `json:"id"`
+	}
+	postJSON(t, router, "/share/"+saved.ID+"/fork", "", &fork)
+	forked, err := snippets.Get(fork.ID)
+	if err != nil || len(forked.Files) != 2 || forked.Entrypoint != "Main.java" {
+		t.Errorf("fork = %+v, %v, want the files of %s", forked, err, saved.ID)
+	}
+}
+
+// postJSON sends body to path and decodes the JSON response into out
+func postJSON(t *testing.T, router http.Handler, path, body string, out interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if out != nil {
+		json.Unmarshal(w.Body.Bytes(), out)
+	}
+	return w.Code
+}
+
+func TestRevisionsAndForks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snippets = store.NewMemoryStore()
+	router := setupRouter()
+
+	type saveResponse struct {
+		ID       string `json:"id"`
+		Revision int    `json:"revision"`
+	}
+
+	var first, second saveResponse
+	postJSON(t, router, "/save", `{"language":"python","code":"v1"}`, &first)
+	status := postJSON(t, router, "/save", `{"language":"python","code":"v2","parent_id":"`+first.ID+`"}`, &second)
+	if status != http.StatusOK || second.Revision != 2 {
+		t.Fatalf("POST /save with parent = %d %+v, want revision 2", status, second)
+	}
+
+	if status := postJSON(t, router, "/save", `{"language":"python","code":"v2","parent_id":"missing"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("POST /save with missing parent status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	history := func(id string) []store.Snippet {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/share/"+id+"/history", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /share/:id/history status = %d: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Revisions []store.Snippet `json:"revisions"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Revisions
+	}
+
+	revisions := history(second.ID)
+	if len(revisions) != 2 || revisions[0].Code != "v1" || revisions[1].Code != "v2" {
+		t.Errorf("history = %+v, want v1 then v2", revisions)
+	}
+
+	var fork saveResponse
+	if status := postJSON(t, router, "/share/"+second.ID+"/fork", "", &fork); status != http.StatusOK {
+		t.Fatalf("POST /share/:id/fork status = %d", status)
+	}
+	revisions = history(fork.ID)
+	if len(revisions) != 1 || revisions[0].Code != "v2" || revisions[0].ForkedFrom != second.ID {
+		t.Errorf("fork history = %+v, want a single revision forked from %s", revisions, second.ID)
+	}
+
+	if status := postJSON(t, router, "/share/missing/fork", "", nil); status != http.StatusNotFound {
+		t.Errorf("POST /share/missing/fork status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestSaveValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snippets = store.NewMemoryStore()
+	router := setupRouter()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "Valid With Expiry", body: `{"language":"c++","code":"int main() {}","expires_in":60}`, wantStatus: http.StatusOK},
+		{name: "Unknown Language", body: `{"language":"perl","code":"print 1"}`, wantStatus: http.StatusBadRequest},
+		{name: "Empty Code", body: `{"language":"python","code":"  "}`, wantStatus: http.StatusBadRequest},
+		{name: "Files Only", body: `{"language":"c","files":[{"path":"main.c","content":"int main() {}"}]}`, wantStatus: http.StatusOK},
+		{name: "Unsafe File Path", body: `{"language":"c","files":[{"path":"../main.c","content":"int main() {}"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "Unknown Entrypoint", body: `{"language":"python","code":"1","entrypoint":"run.py"}`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "Files Too Large",
+			body:       `{"language":"c","code":"` + strings.Repeat("x", maxSnippetCodeSize/2) + `","files":[{"path":"a.c","content":"` + strings.Repeat("x", maxSnippetCodeSize/2+1) + `"}]}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{name: "Negative Expiry", body: `{"language":"python","code":"1","expires_in":-1}`, wantStatus: http.StatusBadRequest},
+		{name: "Expiry Too Long", body: `{"language":"python","code":"1","expires_in":999999999}`, wantStatus: http.StatusBadRequest},
+		{name: "Expiry Overflowing Duration", body: `{"language":"python","code":"1","expires_in":9223372037}`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "Code Too Large",
+			body:       `{"language":"python","code":"` + strings.Repeat("x", maxSnippetCodeSize+1) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "Body Too Large",
+			body:       `{"language":"python","code":"` + strings.Repeat(`\u0041`, maxSnippetCodeSize) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := postJSON(t, router, "/save", tt.body, nil); status != tt.wantStatus {
+				t.Errorf("POST /save status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestExpiredSnippetNotShared(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snippets = store.NewMemoryStore()
+	router := setupRouter()
+
+	expiresAt := time.Now().Add(-time.Second)
+	snippets.Save(store.Snippet{ID: "old", Language: "python", Code: "1", ExpiresAt: &expiresAt})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/share/old", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /share/old status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestGetLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config/limits", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /config/limits status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var got struct {
+		Run struct {
+			Memory    string `json:"memory"`
+			TimeoutMs int64  `json:"timeout_ms"`
+		} `json:"run"`
+		Max struct {
+			Memory string `json:"memory"`
+		} `json:"max"`
+		ExecutionTimeoutMs int64 `json:"execution_timeout_ms"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := settings.Limits
+	if got.Run.Memory != want.Run.Memory || got.Run.TimeoutMs != want.Run.Timeout.Milliseconds() ||
+		got.Max.Memory != want.Max.Memory || got.ExecutionTimeoutMs != want.ExecutionTimeout.Milliseconds() {
+		t.Errorf("GET /config/limits = %s", w.Body)
+	}
+}
+
+func TestGetLanguages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := setupRouter()
+
+	versionsMu.Lock()
+	versions = map[string]string{"python": "Python 3.12.1"}
+	versionsMu.Unlock()
+	t.Cleanup(func() {
+		versionsMu.Lock()
+		versions = nil
+		versionsMu.Unlock()
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/languages", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /languages status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var got struct {
+		Languages []LanguageInfo `json:"languages"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Languages) != len(languages.Languages()) {
+		t.Fatalf("GET /languages returned %d languages, want %d", len(got.Languages), len(languages.Languages()))
+	}
+
+	byID := make(map[string]LanguageInfo)
+	for _, info := range got.Languages {
+		byID[info.ID] = info
+	}
+	python := byID["python"]
+	if python.Name != "Python" || python.Version != "Python 3.12.1" || python.Extension != ".py" ||
+		python.Template == "" || python.Limits.Memory == "" || python.CompileLimits != nil {
+		t.Errorf("GET /languages python = %+v", python)
+	}
+	cpp := byID["cpp"]
+	if len(cpp.Aliases) != 1 || cpp.Aliases[0] != "c++" || cpp.Version != "" || cpp.CompileLimits == nil {
+		t.Errorf("GET /languages cpp = %+v", cpp)
+	}
+}
+
+func TestGetPoolStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics/pool", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics/pool status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), `"enabled":false`) {
+		t.Errorf("GET /metrics/pool = %s, want the pool disabled", w.Body)
+	}
+}
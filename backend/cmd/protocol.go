@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+)
+
+// WebSocket protocol versions. Clients that do not send a version speak the
+// legacy protocol of raw text frames in both directions.
+const (
+	protocolLegacy  = 1
+	protocolTyped   = 2
+	protocolCurrent = protocolTyped
+)
+
+// Server to client message types
+const (
+	msgStdout = "stdout"
+	msgStderr = "stderr"
+	msgStatus = "status"
+	msgExit   = "exit"
+	msgError  = "error"
+
+	msgDiagnostics = "diagnostics"
+)
+
+// execStart is the first message a client sends on /execute
+type execStart struct {
+	executor.ExecRequest
+	Version int `json:"version,omitempty"`
+}
+
+// serverMessage is the envelope for every typed server message.
+// Time is set on stdout and stderr messages to the microseconds elapsed
+// since the program started.
+type serverMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+	Seq  uint64      `json:"seq"`
+	Time int64       `json:"ts,omitempty"`
+}
+
+// statusData is the payload of status messages. Phase is set when the run
+// starts compiling or running.
+type statusData struct {
+	Version int    `json:"version,omitempty"`
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// clientMessage is the envelope for every typed client message
+type clientMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+}
+
+// negotiateVersion picks the protocol version to use with a client
+func negotiateVersion(requested int) int {
+	if requested <= protocolLegacy {
+		return protocolLegacy
+	}
+	if requested > protocolCurrent {
+		return protocolCurrent
+	}
+	return requested
+}
+
+// session writes messages to a client in its negotiated protocol version
+type session struct {
+	conn    *websocket.Conn
+	version int
+	seq     uint64
+	mu      sync.Mutex
+}
+
+func newSession(conn *websocket.Conn, version int) *session {
+	return &session{
+		conn:    conn,
+		version: version,
+	}
+}
+
+// send writes one message. Legacy clients receive the text of output,
+// status and error messages only.
+func (s *session) send(msgType string, data interface{}) error {
+	return s.write(serverMessage{Type: msgType, Data: data})
+}
+
+// write numbers and writes one message
+func (s *session) write(msg serverMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.version < protocolTyped {
+		text, ok := legacyText(msg.Type, msg.Data)
+		if !ok {
+			return nil
+		}
+		return s.conn.WriteMessage(websocket.TextMessage, []byte(text))
+	}
+
+	s.seq++
+	msg.Seq = s.seq
+	return s.conn.WriteJSON(msg)
+}
+
+// close sends a normal closure frame with the given reason
+func (s *session) close(reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason))
+}
+
+// sendOutput writes a runner output message
+func (s *session) sendOutput(out executor.Output) error {
+	switch out.Type {
+	case executor.OutputStatus:
+		return s.send(msgStatus, statusData{Message: out.Data})
+	case executor.OutputPhase:
+		return s.send(msgStatus, statusData{Phase: out.Data})
+	case executor.OutputDiagnostics:
+		return s.send(msgDiagnostics, out.Diagnostics)
+	}
+	return s.write(serverMessage{
+		Type: string(out.Type),
+		Data: out.Data,
+		Time: out.Elapsed.Microseconds(),
+	})
+}
+
+// legacyText renders a message the way the legacy protocol did
+func legacyText(msgType string, data interface{}) (string, bool) {
+	switch msgType {
+	case msgStdout, msgStderr:
+		// Legacy clients print each frame on its own line
+		return strings.TrimSuffix(fmt.Sprint(data), "\n"), true
+	case msgStatus:
+		status, ok := data.(statusData)
+		return status.Message, ok && status.Message != ""
+	case msgError:
+		return "Execution error: " + fmt.Sprint(data), true
+	case msgExit:
+		result, ok := data.(executor.ExecutionResult)
+		if ok && result.Compile.Failed() && result.Compile.Output != "" {
+			// Legacy clients show compiler errors like program output
+			return strings.TrimSuffix(result.Compile.Output, "\n"), true
+		}
+		if !ok || result.ExitCode == 0 || result.Error != "" {
+			return "", false
+		}
+		return fmt.Sprintf("Execution error: exit status %d", result.ExitCode), true
+	default:
+		return "", false
+	}
+}
+
+// parseInput converts a client frame into runner input
+func (s *session) parseInput(frame []byte) (executor.Input, error) {
+	if s.version < protocolTyped {
+		// Legacy clients send one line of stdin per frame
+		return executor.Input{Type: executor.InputStdin, Data: string(frame) + "\n"}, nil
+	}
+
+	var msg clientMessage
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		return executor.Input{}, fmt.Errorf("invalid message: %w", err)
+	}
+
+	switch t := executor.InputType(msg.Type); t {
+	case executor.InputStdin, executor.InputEOF, executor.InputSignal, executor.InputResize:
+		return executor.Input{Type: t, Data: msg.Data, Cols: msg.Cols, Rows: msg.Rows}, nil
+	default:
+		return executor.Input{}, fmt.Errorf("unknown message type %q", msg.Type)
+	}
+}
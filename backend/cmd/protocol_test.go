@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+)
+
+// echoRunner writes a greeting and a warning, then echoes stdin until EOF
+type echoRunner struct{}
+
+func (echoRunner) RunInteractive(ctx context.Context, req executor.ExecRequest, input <-chan executor.Input, output chan<- executor.Output) (executor.ExecutionResult, error) {
+	output <- executor.Output{Type: executor.OutputStdout, Data: "hello\n"}
+	output <- executor.Output{Type: executor.OutputStderr, Data: "warning\n"}
+	for msg := range input {
+		switch msg.Type {
+		case executor.InputStdin:
+			output <- executor.Output{Type: executor.OutputStdout, Data: msg.Data}
+		case executor.InputEOF:
+			return executor.ExecutionResult{ExitCode: 3}, nil
+		}
+	}
+	return executor.ExecutionResult{}, nil
+}
+
+func startTestServer(t *testing.T) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	execService = executor.NewService(echoRunner{})
+	server := httptest.NewServer(setupRouter())
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/execute"
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	conn := dial(t, startTestServer(t))
+
+	if err := conn.WriteJSON(map[string]string{"language": "python", "code": "print('hello')"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"hello", "warning"} {
+		_, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("ReadMessage() = %q, want %q", got, want)
+		}
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	_, got, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("ReadMessage() = %q, want %q", got, "ping")
+	}
+}
+
+func TestTypedProtocol(t *testing.T) {
+	conn := dial(t, startTestServer(t))
+
+	if err := conn.WriteJSON(map[string]interface{}{"language": "python", "code": "print('hello')", "version": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() serverMessage {
+		t.Helper()
+		var msg serverMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		return msg
+	}
+
+	hello := read()
+	data, _ := json.Marshal(hello.Data)
+	if hello.Type != msgStatus || string(data) != `{"version":2}` {
+		t.Errorf("first message = %+v, want version status", hello)
+	}
+
+	conn.WriteJSON(clientMessage{Type: "stdin", Data: "ping\n"})
+	conn.WriteJSON(clientMessage{Type: "eof"})
+
+	want := []serverMessage{
+		{Type: msgStdout, Data: "hello\n", Seq: 2},
+		{Type: msgStderr, Data: "warning\n", Seq: 3},
+		{Type: msgStdout, Data: "ping\n", Seq: 4},
+	}
+	for _, w := range want {
+		got := read()
+		if got.Type != w.Type || got.Data != w.Data || got.Seq != w.Seq {
+			t.Errorf("ReadJSON() = %+v, want %+v", got, w)
+		}
+	}
+
+	exit := read()
+	result, _ := json.Marshal(exit.Data)
+	var got executor.ExecutionResult
+	json.Unmarshal(result, &got)
+	if exit.Type != msgExit || exit.Seq != 5 || got.ExitCode != 3 || got.Reason != executor.ReasonExited {
+		t.Errorf("final message = %+v, want exit with code 3", exit)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		requested int
+		want      int
+	}{
+		{requested: 0, want: protocolLegacy},
+		{requested: 1, want: protocolLegacy},
+		{requested: 2, want: protocolTyped},
+		{requested: 99, want: protocolCurrent},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateVersion(tt.requested); got != tt.want {
+			t.Errorf("negotiateVersion(%d) = %d, want %d", tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	typed := &session{version: protocolTyped}
+
+	tests := []struct {
+		name    string
+		frame   string
+		want    executor.Input
+		wantErr bool
+	}{
+		{name: "Stdin", frame: `{"type":"stdin","data":"42\n"}`, want: executor.Input{Type: executor.InputStdin, Data: "42\n"}},
+		{name: "EOF", frame: `{"type":"eof"}`, want: executor.Input{Type: executor.InputEOF}},
+		{name: "Signal", frame: `{"type":"signal","data":"SIGINT"}`, want: executor.Input{Type: executor.InputSignal, Data: "SIGINT"}},
+		{name: "Resize", frame: `{"type":"resize","cols":80,"rows":24}`, want: executor.Input{Type: executor.InputResize, Cols: 80, Rows: 24}},
+		{name: "Unknown Type", frame: `{"type":"exec"}`, wantErr: true},
+		{name: "Not JSON", frame: `42`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := typed.parseInput([]byte(tt.frame))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseInput() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	legacy := &session{version: protocolLegacy}
+	got, _ := legacy.parseInput([]byte("42"))
+	if got != (executor.Input{Type: executor.InputStdin, Data: "42\n"}) {
+		t.Errorf("legacy parseInput() = %+v", got)
+	}
+}
+
+func TestLegacyText(t *testing.T) {
+	failed := executor.ExecutionResult{
+		ExitCode: 1,
+		Compile:  &executor.CompileResult{Output: "main.c:1:1: error: expected ';'\n", ExitCode: 1},
+	}
+
+	tests := []struct {
+		name    string
+		msgType string
+		data    interface{}
+		want    string
+		wantOK  bool
+	}{
+		{name: "Stdout", msgType: msgStdout, data: "hello\n", want: "hello", wantOK: true},
+		{name: "Phase", msgType: msgStatus, data: statusData{Phase: executor.PhaseCompiling}},
+		{name: "Compile Error", msgType: msgExit, data: failed, want: "main.c:1:1: error: expected ';'", wantOK: true},
+		{name: "Exit Code", msgType: msgExit, data: executor.ExecutionResult{ExitCode: 2}, want: "Execution error: exit status 2", wantOK: true},
+		{name: "Success", msgType: msgExit, data: executor.ExecutionResult{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := legacyText(tt.msgType, tt.data)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("legacyText() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
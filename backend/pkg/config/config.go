@@ -0,0 +1,454 @@
+// Package config loads the server settings from an optional file and the
+// environment.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/language"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings read at startup
+type Config struct {
+	Limits Limits `json:"limits" yaml:"limits"`
+	Pool   Pool   `json:"pool" yaml:"pool"`
+	Local  Local  `json:"local" yaml:"local"`
+
+	Hardening Hardening `json:"hardening" yaml:"hardening"`
+}
+
+// Local configures the runner that sandboxes programs on the host with
+// Linux namespaces instead of Docker
+type Local struct {
+	// Rootfs is the directory programs see as /. It is mounted under a
+	// throwaway overlay, so programs never change it. It has no default:
+	// it must hold only the toolchains, never the host's root.
+	Rootfs string `json:"rootfs" yaml:"rootfs"`
+
+	// WorkDir holds the sandbox directories of the running programs
+	WorkDir string `json:"work_dir" yaml:"work_dir"`
+
+	// Cgroup is the cgroup v2 directory the cgroup of every program is
+	// created in. Memory, CPU and pids limits need it; empty disables
+	// cgroups.
+	Cgroup string `json:"cgroup" yaml:"cgroup"`
+
+	// UserNamespace also runs programs in a new user namespace, as root
+	// mapped to their uid
+	UserNamespace bool `json:"user_namespace" yaml:"user_namespace"`
+
+	// Programs run as one of UIDs users starting at UID, handed out in
+	// turn so concurrent programs rarely share the nproc ulimit
+	UID  int `json:"uid" yaml:"uid"`
+	UIDs int `json:"uids" yaml:"uids"`
+
+	// TmpfsSize bounds the scratch space of a program, written to its
+	// overlay and /tmp
+	TmpfsSize string `json:"tmpfs_size" yaml:"tmpfs_size"`
+}
+
+// Seccomp profiles selected by name rather than by path
+const (
+	// SeccompBuiltin is the profile shipped with the container package
+	SeccompBuiltin = "builtin"
+
+	// SeccompUnconfined runs containers without seccomp
+	SeccompUnconfined = "unconfined"
+)
+
+// Hardening tightens the containers beyond dropping every capability and
+// the network. The zero value keeps docker's defaults.
+type Hardening struct {
+	// ReadOnly mounts the root filesystem read-only, with a tmpfs /tmp
+	ReadOnly bool `json:"read_only" yaml:"read_only"`
+
+	// TmpfsSize bounds each tmpfs. The sandbox directory is always one,
+	// or a tmpfs volume when the compile and run phases share it.
+	TmpfsSize string `json:"tmpfs_size" yaml:"tmpfs_size"`
+
+	// NoNewPrivileges keeps setuid binaries from gaining privileges
+	NoNewPrivileges bool `json:"no_new_privileges" yaml:"no_new_privileges"`
+
+	// Seccomp is the path of a seccomp profile, SeccompBuiltin or
+	// SeccompUnconfined; empty keeps docker's default profile
+	Seccomp string `json:"seccomp" yaml:"seccomp"`
+
+	// User is the user programs run as, in the format of docker run
+	// --user, such as "1000:1000"; empty keeps the image's user
+	User string `json:"user" yaml:"user"`
+
+	// Runtime is the OCI runtime containers run with, such as runsc;
+	// empty keeps the daemon's default
+	Runtime string `json:"runtime" yaml:"runtime"`
+}
+
+// Pool configures the warm containers kept ready for executions
+type Pool struct {
+	// Size is the number of idle containers kept per language; 0 disables
+	// the pool
+	Size int `json:"size" yaml:"size"`
+
+	// RefillInterval is the pause between two containers started to fill
+	// the pool
+	RefillInterval time.Duration `json:"refill_interval" yaml:"refill_interval"`
+
+	// MaxIdle is how long a container may wait before it is replaced
+	MaxIdle time.Duration `json:"max_idle" yaml:"max_idle"`
+}
+
+// maxPoolSize bounds the idle containers kept per language
+const maxPoolSize = 50
+
+// Limits bounds the resources of every execution. A phase starts from the
+// defaults here, then applies the language definition, then the overrides
+// for the language and, for the run phase, those of the request.
+type Limits struct {
+	// Run and Compile are the defaults of each phase
+	Run     language.Limits `json:"run" yaml:"run"`
+	Compile language.Limits `json:"compile" yaml:"compile"`
+
+	// Max bounds the limits a request may ask for
+	Max language.Limits `json:"max" yaml:"max"`
+
+	// ExecutionTimeout bounds a whole execution, both phases included
+	ExecutionTimeout time.Duration `json:"-" yaml:"execution_timeout"`
+
+	Ulimits Ulimits `json:"ulimits" yaml:"ulimits"`
+
+	// Languages overrides the phase limits by language id
+	Languages map[string]LanguageLimits `json:"languages,omitempty" yaml:"languages,omitempty"`
+}
+
+// Ulimits are applied to every container
+type Ulimits struct {
+	Nproc  int   `json:"nproc" yaml:"nproc"`
+	Nofile int   `json:"nofile" yaml:"nofile"`
+	Fsize  int64 `json:"fsize" yaml:"fsize"`
+}
+
+// LanguageLimits overrides the phase limits of one language
+type LanguageLimits struct {
+	Run     language.Limits `json:"run,omitempty" yaml:"run,omitempty"`
+	Compile language.Limits `json:"compile,omitempty" yaml:"compile,omitempty"`
+}
+
+// Default returns the settings used when nothing is configured. Compilers
+// get more memory and time than the programs they build.
+func Default() Config {
+	return Config{
+		Limits: Limits{
+			Run: language.Limits{
+				CPUs: "0.5", Memory: "100m", Pids: 20, Timeout: 10 * time.Second,
+				OutputBytes: 1 << 20, OutputLines: 10000,
+			},
+			Compile: language.Limits{CPUs: "1", Memory: "512m", Pids: 20, Timeout: 30 * time.Second},
+			Max: language.Limits{
+				CPUs: "2", Memory: "1g", Pids: 100, Timeout: 30 * time.Second,
+				OutputBytes: 16 << 20, OutputLines: 100000,
+			},
+			ExecutionTimeout: 60 * time.Second,
+			Ulimits:          Ulimits{Nproc: 20, Nofile: 64, Fsize: 1000000},
+		},
+		Pool: Pool{
+			RefillInterval: 500 * time.Millisecond,
+			MaxIdle:        10 * time.Minute,
+		},
+		Local: Local{
+			WorkDir:   filepath.Join(os.TempDir(), "codeplayground"),
+			Cgroup:    "/sys/fs/cgroup/codeplayground",
+			UID:       100000,
+			UIDs:      1000,
+			TmpfsSize: "64m",
+		},
+		Hardening: Hardening{
+			TmpfsSize: "64m",
+		},
+	}
+}
+
+// Load reads the settings from the file at path, if any, on top of the
+// defaults, then applies the environment and validates the result
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("error reading config: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("error parsing config: %w", err)
+		}
+	}
+	if err := cfg.applyEnv(os.Getenv); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings with the environment variables that are set
+func (c *Config) applyEnv(getenv func(string) string) error {
+	l := &c.Limits
+	vars := []struct {
+		name string
+		set  func(string) error
+	}{
+		{"LIMITS_RUN_CPUS", setString(&l.Run.CPUs)},
+		{"LIMITS_RUN_MEMORY", setString(&l.Run.Memory)},
+		{"LIMITS_RUN_PIDS", setInt(&l.Run.Pids)},
+		{"LIMITS_RUN_TIMEOUT", setDuration(&l.Run.Timeout)},
+		{"LIMITS_RUN_OUTPUT_BYTES", setInt64(&l.Run.OutputBytes)},
+		{"LIMITS_RUN_OUTPUT_LINES", setInt(&l.Run.OutputLines)},
+		{"LIMITS_COMPILE_CPUS", setString(&l.Compile.CPUs)},
+		{"LIMITS_COMPILE_MEMORY", setString(&l.Compile.Memory)},
+		{"LIMITS_COMPILE_PIDS", setInt(&l.Compile.Pids)},
+		{"LIMITS_COMPILE_TIMEOUT", setDuration(&l.Compile.Timeout)},
+		{"LIMITS_MAX_CPUS", setString(&l.Max.CPUs)},
+		{"LIMITS_MAX_MEMORY", setString(&l.Max.Memory)},
+		{"LIMITS_MAX_PIDS", setInt(&l.Max.Pids)},
+		{"LIMITS_MAX_TIMEOUT", setDuration(&l.Max.Timeout)},
+		{"LIMITS_MAX_OUTPUT_BYTES", setInt64(&l.Max.OutputBytes)},
+		{"LIMITS_MAX_OUTPUT_LINES", setInt(&l.Max.OutputLines)},
+		{"LIMITS_EXECUTION_TIMEOUT", setDuration(&l.ExecutionTimeout)},
+		{"LIMITS_NPROC", setInt(&l.Ulimits.Nproc)},
+		{"LIMITS_NOFILE", setInt(&l.Ulimits.Nofile)},
+		{"LIMITS_FSIZE", setInt64(&l.Ulimits.Fsize)},
+		{"POOL_SIZE", setInt(&c.Pool.Size)},
+		{"POOL_REFILL_INTERVAL", setDuration(&c.Pool.RefillInterval)},
+		{"POOL_MAX_IDLE", setDuration(&c.Pool.MaxIdle)},
+		{"LOCAL_ROOTFS", setString(&c.Local.Rootfs)},
+		{"LOCAL_WORK_DIR", setString(&c.Local.WorkDir)},
+		{"LOCAL_CGROUP", func(s string) error {
+			// "none" disables cgroups, as an empty variable is ignored
+			if s == "none" {
+				s = ""
+			}
+			c.Local.Cgroup = s
+			return nil
+		}},
+		{"LOCAL_USER_NAMESPACE", setBool(&c.Local.UserNamespace)},
+		{"LOCAL_UID", setInt(&c.Local.UID)},
+		{"LOCAL_UIDS", setInt(&c.Local.UIDs)},
+		{"LOCAL_TMPFS_SIZE", setString(&c.Local.TmpfsSize)},
+		{"HARDENING_READ_ONLY", setBool(&c.Hardening.ReadOnly)},
+		{"HARDENING_TMPFS_SIZE", setString(&c.Hardening.TmpfsSize)},
+		{"HARDENING_NO_NEW_PRIVILEGES", setBool(&c.Hardening.NoNewPrivileges)},
+		{"HARDENING_SECCOMP", setString(&c.Hardening.Seccomp)},
+		{"HARDENING_USER", setString(&c.Hardening.User)},
+		{"HARDENING_RUNTIME", setString(&c.Hardening.Runtime)},
+	}
+
+	for _, v := range vars {
+		value := getenv(v.name)
+		if value == "" {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", v.name, err)
+		}
+	}
+	return nil
+}
+
+func setString(p *string) func(string) error {
+	return func(s string) error {
+		*p = s
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		*p = n
+		return err
+	}
+}
+
+func setInt64(p *int64) func(string) error {
+	return func(s string) error {
+		n, err := strconv.ParseInt(s, 10, 64)
+		*p = n
+		return err
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(s string) error {
+		b, err := strconv.ParseBool(s)
+		*p = b
+		return err
+	}
+}
+
+func setDuration(p *time.Duration) func(string) error {
+	return func(s string) error {
+		d, err := time.ParseDuration(s)
+		*p = d
+		return err
+	}
+}
+
+// Validate checks that the settings are complete and consistent
+func (c Config) Validate() error {
+	l := c.Limits
+	if l.ExecutionTimeout <= 0 {
+		return fmt.Errorf("execution timeout must be positive")
+	}
+
+	phases := []struct {
+		name   string
+		limits language.Limits
+	}{
+		{"run", l.Run},
+		{"compile", l.Compile},
+	}
+	for _, p := range phases {
+		if err := p.limits.Validate(); err != nil {
+			return fmt.Errorf("%s limits: %w", p.name, err)
+		}
+		if p.limits.CPUs == "" || p.limits.Memory == "" || p.limits.Pids == 0 || p.limits.Timeout == 0 {
+			return fmt.Errorf("%s limits must set cpus, memory, pids and timeout", p.name)
+		}
+		if p.limits.Timeout > l.ExecutionTimeout {
+			return fmt.Errorf("%s timeout cannot exceed the execution timeout", p.name)
+		}
+	}
+
+	if err := l.Max.Validate(); err != nil {
+		return fmt.Errorf("max limits: %w", err)
+	}
+	if err := l.Run.Within(l.Max); err != nil {
+		return fmt.Errorf("run limits: %w", err)
+	}
+	if l.Ulimits.Nproc <= 0 || l.Ulimits.Nofile <= 0 || l.Ulimits.Fsize <= 0 {
+		return fmt.Errorf("ulimits must be positive")
+	}
+
+	for id, overrides := range l.Languages {
+		if err := overrides.Run.Validate(); err != nil {
+			return fmt.Errorf("language %q run limits: %w", id, err)
+		}
+		if err := overrides.Compile.Validate(); err != nil {
+			return fmt.Errorf("language %q compile limits: %w", id, err)
+		}
+	}
+
+	if c.Pool.Size < 0 || c.Pool.Size > maxPoolSize {
+		return fmt.Errorf("pool size must be between 0 and %d", maxPoolSize)
+	}
+	if c.Pool.RefillInterval <= 0 || c.Pool.MaxIdle <= 0 {
+		return fmt.Errorf("pool refill interval and max idle time must be positive")
+	}
+
+	if err := c.Local.validate(); err != nil {
+		return err
+	}
+
+	return c.Hardening.validate()
+}
+
+// Validate checks the settings of the local runner, which also needs its
+// rootfs set
+func (l Local) Validate() error {
+	if l.Rootfs == "" {
+		return fmt.Errorf("local rootfs must be set to a directory holding the toolchains")
+	}
+	return l.validate()
+}
+
+// validate checks the settings of the local runner whether it is used or
+// not. The host's root as rootfs would expose every file the programs'
+// users can read.
+func (l Local) validate() error {
+	if l.Rootfs != "" {
+		root := filepath.Clean(l.Rootfs)
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		if root == "/" {
+			return fmt.Errorf("local rootfs cannot be the host's root filesystem")
+		}
+	}
+	if l.WorkDir == "" {
+		return fmt.Errorf("local work dir must be set")
+	}
+	if l.UID <= 0 || l.UIDs <= 0 {
+		return fmt.Errorf("local uid and uids must be positive")
+	}
+	if l.TmpfsSize == "" || (language.Limits{Memory: l.TmpfsSize}).Validate() != nil {
+		return fmt.Errorf("invalid local tmpfs size %q", l.TmpfsSize)
+	}
+	return nil
+}
+
+// validate checks the tmpfs size and that a seccomp profile given by path
+// can be read, so containers do not fail to start on it later
+func (h Hardening) validate() error {
+	if h.TmpfsSize == "" || (language.Limits{Memory: h.TmpfsSize}).Validate() != nil {
+		return fmt.Errorf("invalid hardening tmpfs size %q", h.TmpfsSize)
+	}
+	switch h.Seccomp {
+	case "", SeccompBuiltin, SeccompUnconfined:
+		return nil
+	}
+	data, err := os.ReadFile(h.Seccomp)
+	if err != nil {
+		return fmt.Errorf("error reading seccomp profile: %w", err)
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("seccomp profile %s is not valid JSON", h.Seccomp)
+	}
+	return nil
+}
+
+// CheckLanguages reports overrides for languages missing from the registry.
+// Overrides are keyed by language id, not by an alias, as Phases looks them
+// up by id.
+func (c Config) CheckLanguages(languages *language.Registry) error {
+	for id := range c.Limits.Languages {
+		lang, ok := languages.Lookup(id)
+		if !ok {
+			return fmt.Errorf("limits set for unknown language %q", id)
+		}
+		if lang.ID != id {
+			return fmt.Errorf("limits set for language %q must use its id %q", id, lang.ID)
+		}
+	}
+	return nil
+}
+
+// Phases returns the run and compile limits of the language
+func (l Limits) Phases(lang *language.Language) (run, compile language.Limits) {
+	overrides := l.Languages[lang.ID]
+	run = l.Run.Merge(lang.Limits).Merge(overrides.Run)
+	compile = l.Compile.Merge(lang.CompileLimits).Merge(overrides.Compile)
+	return run, compile
+}
+
+// Request applies the limits a request asks for to the run limits. They
+// may not exceed Max.
+func (l Limits) Request(run, requested language.Limits) (language.Limits, error) {
+	if err := requested.Within(l.Max); err != nil {
+		return language.Limits{}, err
+	}
+	return run.Merge(requested), nil
+}
+
+// MarshalJSON reports the execution timeout in milliseconds like the
+// other durations of the API
+func (l Limits) MarshalJSON() ([]byte, error) {
+	type limits Limits
+	return json.Marshal(struct {
+		limits
+		ExecutionTimeoutMs int64 `json:"execution_timeout_ms"`
+	}{limits(l), l.ExecutionTimeout.Milliseconds()})
+}
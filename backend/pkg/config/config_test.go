@@ -0,0 +1,330 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default().Validate() error = %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `limits:
+  run:
+    memory: 200m
+    timeout: 5s
+  max:
+    memory: 2g
+  languages:
+    java:
+      run:
+        memory: 512m
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LIMITS_RUN_CPUS", "0.25")
+	t.Setenv("LIMITS_EXECUTION_TIMEOUT", "45s")
+	t.Setenv("POOL_SIZE", "3")
+	t.Setenv("LIMITS_RUN_OUTPUT_BYTES", "4096")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want := language.Limits{CPUs: "0.25", Memory: "200m", Pids: 20, Timeout: 5 * time.Second, OutputBytes: 4096, OutputLines: 10000}
+	if cfg.Limits.Run != want {
+		t.Errorf("Load() run limits = %+v, want %+v", cfg.Limits.Run, want)
+	}
+	if cfg.Limits.Max.Memory != "2g" || cfg.Limits.Max.CPUs != Default().Limits.Max.CPUs {
+		t.Errorf("Load() max limits = %+v, want file values over the defaults", cfg.Limits.Max)
+	}
+	if cfg.Limits.ExecutionTimeout != 45*time.Second {
+		t.Errorf("Load() execution timeout = %v, want 45s", cfg.Limits.ExecutionTimeout)
+	}
+	if cfg.Pool.Size != 3 || cfg.Pool.MaxIdle != Default().Pool.MaxIdle {
+		t.Errorf("Load() pool = %+v, want size 3 and default max idle", cfg.Pool)
+	}
+	if cfg.Limits.Languages["java"].Run.Memory != "512m" {
+		t.Errorf("Load() language limits = %+v", cfg.Limits.Languages)
+	}
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Limits.Run != Default().Limits.Run {
+		t.Errorf("Load() run limits = %+v, want defaults", cfg.Limits.Run)
+	}
+}
+
+func TestLoadInvalidEnv(t *testing.T) {
+	t.Setenv("LIMITS_RUN_PIDS", "many")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "LIMITS_RUN_PIDS") {
+		t.Errorf("Load() error = %v, want invalid LIMITS_RUN_PIDS", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Limits)
+	}{
+		{name: "No Execution Timeout", modify: func(l *Limits) { l.ExecutionTimeout = 0 }},
+		{name: "Invalid CPUs", modify: func(l *Limits) { l.Run.CPUs = "half" }},
+		{name: "Invalid Memory", modify: func(l *Limits) { l.Compile.Memory = "lots" }},
+		{name: "Missing Run Memory", modify: func(l *Limits) { l.Run.Memory = "" }},
+		{name: "Run Timeout Above Execution", modify: func(l *Limits) { l.Run.Timeout = 2 * time.Minute }},
+		{name: "Run Above Max", modify: func(l *Limits) { l.Max.Memory = "50m" }},
+		{name: "Zero Ulimit", modify: func(l *Limits) { l.Ulimits.Nofile = 0 }},
+		{
+			name: "Invalid Language Override",
+			modify: func(l *Limits) {
+				l.Languages = map[string]LanguageLimits{"c": {Compile: language.Limits{Pids: -1}}}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(&cfg.Limits)
+			if err := cfg.Validate(); err == nil {
+				t.Error("Validate() expected error")
+			}
+		})
+	}
+}
+
+func TestValidatePool(t *testing.T) {
+	tests := []struct {
+		name    string
+		pool    Pool
+		wantErr bool
+	}{
+		{name: "Disabled", pool: Default().Pool},
+		{name: "Enabled", pool: Pool{Size: 2, RefillInterval: time.Second, MaxIdle: time.Minute}},
+		{name: "Negative Size", pool: Pool{Size: -1, RefillInterval: time.Second, MaxIdle: time.Minute}, wantErr: true},
+		{name: "Too Large", pool: Pool{Size: maxPoolSize + 1, RefillInterval: time.Second, MaxIdle: time.Minute}, wantErr: true},
+		{name: "No Refill Interval", pool: Pool{Size: 2, MaxIdle: time.Minute}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Pool = tt.pool
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateLocal(t *testing.T) {
+	hostRoot := filepath.Join(t.TempDir(), "host")
+	if err := os.Symlink("/", hostRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Local)
+	}{
+		{name: "Host Rootfs", modify: func(l *Local) { l.Rootfs = "/" }},
+		{name: "Host Rootfs Unclean", modify: func(l *Local) { l.Rootfs = "/srv/.." }},
+		{name: "Host Rootfs Symlink", modify: func(l *Local) { l.Rootfs = hostRoot }},
+		{name: "No UID", modify: func(l *Local) { l.UID = 0 }},
+		{name: "No UIDs", modify: func(l *Local) { l.UIDs = 0 }},
+		{name: "Invalid Tmpfs Size", modify: func(l *Local) { l.TmpfsSize = "big" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Local.Rootfs = "/srv/rootfs"
+			tt.modify(&cfg.Local)
+			if err := cfg.Validate(); err == nil {
+				t.Error("Validate() expected error")
+			}
+			if err := cfg.Local.Validate(); err == nil {
+				t.Error("Local.Validate() expected error")
+			}
+		})
+	}
+
+	// Only the local runner needs a rootfs
+	local := Default().Local
+	if err := local.Validate(); err == nil {
+		t.Error("Local.Validate() expected error without a rootfs")
+	}
+	local.Rootfs = "/srv/rootfs"
+	if err := local.Validate(); err != nil {
+		t.Errorf("Local.Validate() error = %v", err)
+	}
+}
+
+func TestLoadLocalEnv(t *testing.T) {
+	t.Setenv("LOCAL_ROOTFS", "/srv/rootfs")
+	t.Setenv("LOCAL_CGROUP", "none")
+	t.Setenv("LOCAL_USER_NAMESPACE", "true")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Local.Rootfs != "/srv/rootfs" || cfg.Local.Cgroup != "" || !cfg.Local.UserNamespace {
+		t.Errorf("Load() local = %+v", cfg.Local)
+	}
+	if cfg.Local.UID != Default().Local.UID {
+		t.Errorf("Load() local uid = %d, want the default", cfg.Local.UID)
+	}
+}
+
+func TestValidateHardening(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(profile, []byte(`{"defaultAction":"SCMP_ACT_ALLOW"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	if err := os.WriteFile(invalid, []byte("defaultAction: allow"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		hardening Hardening
+		wantErr   bool
+	}{
+		{name: "Disabled", hardening: Default().Hardening},
+		{name: "Builtin Seccomp", hardening: Hardening{ReadOnly: true, TmpfsSize: "32m", Seccomp: SeccompBuiltin}},
+		{name: "Profile Path", hardening: Hardening{TmpfsSize: "64m", Seccomp: profile}},
+		{name: "Missing Profile", hardening: Hardening{TmpfsSize: "64m", Seccomp: filepath.Join(t.TempDir(), "missing.json")}, wantErr: true},
+		{name: "Invalid Profile", hardening: Hardening{TmpfsSize: "64m", Seccomp: invalid}, wantErr: true},
+		{name: "Invalid Tmpfs Size", hardening: Hardening{ReadOnly: true, TmpfsSize: "lots"}, wantErr: true},
+		{name: "Missing Tmpfs Size", hardening: Hardening{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Hardening = tt.hardening
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadHardeningEnv(t *testing.T) {
+	t.Setenv("HARDENING_READ_ONLY", "1")
+	t.Setenv("HARDENING_NO_NEW_PRIVILEGES", "true")
+	t.Setenv("HARDENING_SECCOMP", SeccompBuiltin)
+	t.Setenv("HARDENING_RUNTIME", "runsc")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := Hardening{ReadOnly: true, TmpfsSize: "64m", NoNewPrivileges: true, Seccomp: SeccompBuiltin, Runtime: "runsc"}
+	if cfg.Hardening != want {
+		t.Errorf("Load() hardening = %+v, want %+v", cfg.Hardening, want)
+	}
+
+	t.Setenv("HARDENING_READ_ONLY", "sometimes")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "HARDENING_READ_ONLY") {
+		t.Errorf("Load() error = %v, want invalid HARDENING_READ_ONLY", err)
+	}
+}
+
+func TestCheckLanguages(t *testing.T) {
+	cfg := Default()
+	cfg.Limits.Languages = map[string]LanguageLimits{"cobol": {}}
+	if err := cfg.CheckLanguages(language.Default()); err == nil {
+		t.Error("CheckLanguages() expected error for unknown language")
+	}
+
+	for _, alias := range []string{"python3", "Python"} {
+		cfg.Limits.Languages = map[string]LanguageLimits{alias: {}}
+		if err := cfg.CheckLanguages(language.Default()); err == nil {
+			t.Errorf("CheckLanguages() expected error for alias %q", alias)
+		}
+	}
+
+	cfg.Limits.Languages = map[string]LanguageLimits{"python": {}}
+	if err := cfg.CheckLanguages(language.Default()); err != nil {
+		t.Errorf("CheckLanguages() error = %v", err)
+	}
+}
+
+func TestPhases(t *testing.T) {
+	limits := Default().Limits
+	limits.Languages = map[string]LanguageLimits{
+		"java": {Run: language.Limits{Pids: 40, OutputLines: 500}},
+	}
+	lang := &language.Language{
+		ID:            "java",
+		Limits:        language.Limits{Memory: "256m"},
+		CompileLimits: language.Limits{Memory: "768m"},
+	}
+
+	run, compile := limits.Phases(lang)
+	want := language.Limits{CPUs: "0.5", Memory: "256m", Pids: 40, Timeout: 10 * time.Second, OutputBytes: 1 << 20, OutputLines: 500}
+	if run != want {
+		t.Errorf("Phases() run = %+v, want %+v", run, want)
+	}
+	if want := (language.Limits{CPUs: "1", Memory: "768m", Pids: 20, Timeout: 30 * time.Second}); compile != want {
+		t.Errorf("Phases() compile = %+v, want %+v", compile, want)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	limits := Default().Limits
+	run := limits.Run
+
+	got, err := limits.Request(run, language.Limits{Memory: "512m", Timeout: 20 * time.Second})
+	if err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+	if got.Memory != "512m" || got.Timeout != 20*time.Second || got.CPUs != run.CPUs {
+		t.Errorf("Request() = %+v, want memory and timeout raised", got)
+	}
+
+	if _, err := limits.Request(run, language.Limits{Memory: "4g"}); err == nil {
+		t.Error("Request() expected error above max memory")
+	}
+	if _, err := limits.Request(run, language.Limits{Timeout: time.Hour}); err == nil {
+		t.Error("Request() expected error above max timeout")
+	}
+}
+
+func TestLimitsJSON(t *testing.T) {
+	data, err := json.Marshal(Default().Limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Run                language.Limits `json:"run"`
+		ExecutionTimeoutMs int64           `json:"execution_timeout_ms"`
+		Ulimits            Ulimits         `json:"ulimits"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Run != Default().Limits.Run || got.ExecutionTimeoutMs != 60000 || got.Ulimits.Nofile != 64 {
+		t.Errorf("json.Marshal(Limits) = %s", data)
+	}
+}
@@ -0,0 +1,171 @@
+//go:build amd64 || arm64
+
+// cgroup v2 limits of local sandboxes
+package container
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// cgroupControllers are enabled for the cgroups of the sandboxes
+const cgroupControllers = "+cpu +memory +pids"
+
+// cpuPeriod is the cpu.max period in microseconds, the one docker uses
+const cpuPeriod = 100000
+
+// cgroupRemoveAttempts and cgroupRemoveInterval bound how long removing a
+// cgroup waits for the processes of an exited sandbox to be reaped
+const (
+	cgroupRemoveAttempts = 50
+	cgroupRemoveInterval = 10 * time.Millisecond
+)
+
+// cgroup is the cgroup a sandbox is started in
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// setupCgroupRoot creates the cgroup the sandboxes' cgroups are created
+// in and enables their controllers, removing cgroups left by an earlier
+// process
+func setupCgroupRoot(root string) error {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return fmt.Errorf("error creating cgroup: %w", err)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("error reading cgroup: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), "code-") {
+			os.Remove(filepath.Join(root, e.Name()))
+		}
+	}
+
+	for _, dir := range []string{filepath.Dir(root), root} {
+		path := filepath.Join(dir, "cgroup.subtree_control")
+		if err := os.WriteFile(path, []byte(cgroupControllers), 0); err != nil {
+			return fmt.Errorf("error enabling cgroup controllers in %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// newCgroup creates the cgroup of a sandbox with the limits of its phase,
+// which docker run sets with --cpus, -m and --pids-limit. Swap is not
+// allowed.
+func newCgroup(root, name string, limits language.Limits) (*cgroup, error) {
+	path := filepath.Join(root, name)
+	if err := os.Mkdir(path, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating cgroup: %w", err)
+	}
+	c := &cgroup{path: path}
+
+	files := []struct {
+		name, value string
+		optional    bool
+	}{
+		{name: "memory.max", value: cgroupLimit(limits.MemoryBytes())},
+		// Kernels without swap accounting have no swap limit
+		{name: "memory.swap.max", value: "0", optional: true},
+		{name: "pids.max", value: cgroupLimit(int64(limits.Pids))},
+		{name: "cpu.max", value: fmt.Sprintf("%s %d", cgroupLimit(limits.NanoCPUs()*cpuPeriod/1e9), cpuPeriod)},
+	}
+	for _, f := range files {
+		err := os.WriteFile(filepath.Join(path, f.name), []byte(f.value), 0o644)
+		if err != nil && !(f.optional && errors.Is(err, os.ErrNotExist)) {
+			c.remove()
+			return nil, fmt.Errorf("error setting %s: %w", f.name, err)
+		}
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		c.remove()
+		return nil, fmt.Errorf("error opening cgroup: %w", err)
+	}
+	c.dir = dir
+	return c, nil
+}
+
+// cgroupLimit formats a limit for a cgroup file, where 0 is no limit
+func cgroupLimit(n int64) string {
+	if n <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// fd returns the descriptor the sandbox init is cloned into the cgroup
+// with
+func (c *cgroup) fd() int {
+	return int(c.dir.Fd())
+}
+
+// oomKilled reports whether the kernel killed a process of the cgroup
+// because it ran out of memory
+func (c *cgroup) oomKilled() bool {
+	return c.stat("memory.events", "oom_kill") > 0
+}
+
+// usage reports the peak memory and the CPU time of the cgroup, or nil if
+// the kernel does not report them
+func (c *cgroup) usage() *executor.ResourceUsage {
+	peak, err := os.ReadFile(filepath.Join(c.path, "memory.peak"))
+	if err != nil {
+		return nil
+	}
+	memory, err := strconv.ParseUint(strings.TrimSpace(string(peak)), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &executor.ResourceUsage{
+		MemoryPeakBytes: memory,
+		CPUTimeMs:       int64(c.stat("cpu.stat", "usage_usec") / 1000),
+	}
+}
+
+// stat reads a key of a flat keyed file such as memory.events, or 0
+func (c *cgroup) stat(file, key string) uint64 {
+	f, err := os.Open(filepath.Join(c.path, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseUint(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// remove deletes the cgroup once the processes of the sandbox are gone.
+// They may still be reaped for a moment after the program exits.
+func (c *cgroup) remove() {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	for i := 0; i < cgroupRemoveAttempts; i++ {
+		err := os.Remove(c.path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(cgroupRemoveInterval)
+	}
+}
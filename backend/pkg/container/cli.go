@@ -0,0 +1,151 @@
+// docker CLI backend
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// cliBackend drives containers with the docker CLI. It is the default
+// backend of a DockerRunner.
+type cliBackend struct {
+	d *DockerRunner
+}
+
+// start runs the phase with docker run or docker exec. The CLI is killed
+// when ctx is done.
+func (b cliBackend) start(ctx context.Context, p phase) (*process, error) {
+	cmd := b.d.command(ctx, "docker", p.args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdin pipe: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdout pipe: %w", err)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &process{
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		wait: func() error {
+			// Wait closes the pipes, so every read must finish first
+			err := cmd.Wait()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return nil
+			}
+			return err
+		},
+		result: func() executor.ExecutionResult {
+			return b.d.phaseResult(p, cmd)
+		},
+	}, nil
+}
+
+func (b cliBackend) kill(containerName string) error {
+	return b.d.command(context.Background(), "docker", "kill", containerName).Run()
+}
+
+func (b cliBackend) signal(p phase, signal string) error {
+	if p.pooled {
+		return b.d.command(context.Background(), "docker", "exec", p.container, "bash", "-c", killAllCommand(signal)).Run()
+	}
+	return b.d.command(context.Background(), "docker", "kill", "--signal="+signal, p.container).Run()
+}
+
+func (b cliBackend) inspect(containerName string) (containerState, error) {
+	out, err := b.d.command(context.Background(), "docker", "inspect",
+		"--format", "{{.State.ExitCode}} {{.State.OOMKilled}}", containerName).Output()
+	if err != nil {
+		return containerState{}, fmt.Errorf("error inspecting container: %w", err)
+	}
+	return parseContainerState(string(out))
+}
+
+func (b cliBackend) remove(containerName string) {
+	b.d.command(context.Background(), "docker", "rm", "-f", containerName).Run()
+}
+
+func (b cliBackend) createVolume(ctx context.Context, name string) error {
+	opts := b.d.volumeOpts()
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := []string{"volume", "create", "--driver", "local"}
+	for _, key := range keys {
+		args = append(args, "--opt", key+"="+opts[key])
+	}
+	args = append(args, name)
+	if out, err := b.d.command(ctx, "docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("error creating volume: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (b cliBackend) removeVolume(name string) {
+	b.d.command(context.Background(), "docker", "volume", "rm", "-f", name).Run()
+}
+
+func (b cliBackend) startIdle(ctx context.Context, name string, spec containerSpec) error {
+	args := append(b.d.prepareBaseArgs(name, spec.limits), "-d")
+	args = append(args, b.d.sandboxMount(spec.volume)...)
+	args = append(args, spec.image)
+	args = append(args, spec.command...)
+	if out, err := b.d.command(ctx, "docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("error starting pooled container: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (b cliBackend) removeStalePool() {
+	filter := "name=" + poolPrefix
+	if out, err := b.d.command(context.Background(), "docker", "ps", "-aq", "--filter", filter).Output(); err == nil {
+		if ids := strings.Fields(string(out)); len(ids) > 0 {
+			b.d.command(context.Background(), "docker", append([]string{"rm", "-f"}, ids...)...).Run()
+		}
+	}
+	if out, err := b.d.command(context.Background(), "docker", "volume", "ls", "-q", "--filter", filter).Output(); err == nil {
+		if names := strings.Fields(string(out)); len(names) > 0 {
+			b.d.command(context.Background(), "docker", append([]string{"volume", "rm", "-f"}, names...)...).Run()
+		}
+	}
+}
+
+// probeVersion runs the version command in a throwaway container, confined
+// and limited like the language's programs
+func (b cliBackend) probeVersion(ctx context.Context, lang *language.Language) (string, error) {
+	runLimits, _ := b.d.limits.Phases(lang)
+	name := fmt.Sprintf("code-version-%s-%d", lang.ID, time.Now().UnixNano())
+	args := append(b.d.prepareBaseArgs(name, runLimits), b.d.sandboxMount("")...)
+	args = append(args, b.d.image(lang))
+	args = append(args, lang.Version...)
+	defer b.remove(name)
+	out, err := b.d.command(ctx, "docker", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("error probing version: %w", err)
+	}
+	return firstLine(string(out))
+}
@@ -0,0 +1,100 @@
+// compile phase
+package container
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+)
+
+// maxCompileOutput bounds the compiler output, and the stderr parsed for
+// diagnostics, kept for a run
+const maxCompileOutput = 64 * 1024
+
+// compile stages the source and runs the compile step. Compiler output is
+// collected, not streamed, so it stays apart from the program's output.
+func (r *phaseRunner) compile(ctx context.Context, p phase, output chan<- executor.Output) (executor.CompileResult, error) {
+	var result executor.CompileResult
+
+	// The kill notice still goes out after the timeout
+	runCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	// The container is killed explicitly, so the CLI is not tied to ctx
+	proc, err := r.backend.start(context.Background(), p)
+	if err != nil {
+		return result, err
+	}
+	defer r.backend.remove(p.container)
+
+	go func() {
+		proc.stdin.Write(p.archive)
+		proc.stdin.Close()
+	}()
+
+	diagnostics := &cappedBuffer{limit: maxCompileOutput}
+	var readers sync.WaitGroup
+	readers.Add(2)
+	for _, r := range []io.Reader{proc.stdout, proc.stderr} {
+		go func(r io.Reader) {
+			defer readers.Done()
+			io.Copy(diagnostics, r)
+		}(r)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		readers.Wait()
+		done <- proc.wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		r.killContainer(runCtx, p.container, output)
+		<-done
+		err = ctx.Err()
+	case err = <-done:
+	}
+
+	state := proc.result()
+	result.Output, result.Truncated = diagnostics.String(), diagnostics.truncated
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.ExitCode = state.ExitCode
+	result.OOMKilled = state.OOMKilled
+	result.TimedOut = ctx.Err() == context.DeadlineExceeded
+	return result, err
+}
+
+// cappedBuffer keeps the first limit bytes written to it and drops the rest
+type cappedBuffer struct {
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+	mu        sync.Mutex
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+	} else {
+		b.buf.Write(p)
+	}
+	// Report every byte as written so the compiler is never blocked
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
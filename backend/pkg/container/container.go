@@ -2,165 +2,415 @@
 package container
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/tiakavousi/codeplayground/pkg/config"
 	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
 )
 
-// Security and resource constants
-const (
-	maxCPU    = "0.5"
-	maxMemory = "100m"
-	maxPids   = "20"
-)
+// commandContextFunc creates the commands used to drive the docker CLI
+type commandContextFunc func(ctx context.Context, name string, arg ...string) *exec.Cmd
+
+// allowedSignals are the signals clients may send to a running program
+var allowedSignals = map[string]bool{
+	"INT":  true,
+	"TERM": true,
+	"KILL": true,
+	"HUP":  true,
+	"QUIT": true,
+	"USR1": true,
+	"USR2": true,
+}
+
+// phaseRunner runs the phases of a request through a backend, streaming
+// their output and forwarding input. The Docker and local runners share it.
+type phaseRunner struct {
+	languages    *language.Registry
+	limits       config.Limits
+	backend      backend
+	flushWindow  time.Duration
+	maxChunkSize int
+}
+
+// newPhaseRunner returns the phase settings both runners start from
+func newPhaseRunner() phaseRunner {
+	return phaseRunner{
+		languages:    language.Default(),
+		limits:       config.Default().Limits,
+		flushWindow:  defaultFlushWindow,
+		maxChunkSize: defaultMaxChunkSize,
+	}
+}
 
 // DockerRunner implements the executor.CodeRunner interface
 type DockerRunner struct {
+	phaseRunner
 	imageName    string
 	securityOpts []string
+	pool         *pool
+	hardening    config.Hardening
+	seccomp      string // the seccomp security option, a path or profile
+	seccompErr   error
+	command      commandContextFunc
+}
+
+// Option configures a DockerRunner. WithRegistry, WithLimits,
+// WithFlushWindow and WithMaxChunkSize also configure a LocalRunner.
+type Option func(*DockerRunner)
+
+// WithRegistry sets the languages the runner accepts
+func WithRegistry(registry *language.Registry) Option {
+	return func(d *DockerRunner) {
+		d.languages = registry
+	}
+}
+
+// WithLimits sets the default and maximum limits of every phase
+func WithLimits(limits config.Limits) Option {
+	return func(d *DockerRunner) {
+		d.limits = limits
+	}
+}
+
+// WithFlushWindow sets how long output may be buffered before it is sent,
+// so partial lines such as input prompts are not held back
+func WithFlushWindow(window time.Duration) Option {
+	return func(d *DockerRunner) {
+		d.flushWindow = window
+	}
+}
+
+// WithMaxChunkSize sets the largest output message in bytes. Longer lines
+// are split across several messages.
+func WithMaxChunkSize(size int) Option {
+	return func(d *DockerRunner) {
+		d.maxChunkSize = size
+	}
 }
 
 // NewDockerRunner creates a new Docker-based code runner
-func NewDockerRunner(imageName string) *DockerRunner {
-	return &DockerRunner{
-		imageName: imageName,
-		securityOpts: []string{
-			"--cap-drop=ALL",
-			"--net=none",
-			"--pids-limit=" + maxPids,
-			"--ulimit", "nproc=20:20",
-			"--ulimit", "nofile=64:64",
-			"--ulimit", "fsize=1000000:1000000",
-		},
+func NewDockerRunner(imageName string, opts ...Option) *DockerRunner {
+	d := &DockerRunner{
+		phaseRunner: newPhaseRunner(),
+		imageName:   imageName,
+		hardening:   config.Default().Hardening,
+		command:     exec.CommandContext,
+	}
+	d.backend = cliBackend{d: d}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	ulimits := d.limits.Ulimits
+	d.securityOpts = []string{
+		"--cap-drop=ALL",
+		"--net=none",
+		"--pids-limit=" + strconv.Itoa(d.limits.Run.Pids),
+		"--ulimit", fmt.Sprintf("nproc=%d:%d", ulimits.Nproc, ulimits.Nproc),
+		"--ulimit", fmt.Sprintf("nofile=%d:%d", ulimits.Nofile, ulimits.Nofile),
+		"--ulimit", fmt.Sprintf("fsize=%d:%d", ulimits.Fsize, ulimits.Fsize),
 	}
+	d.setupSeccomp()
+	d.securityOpts = append(d.securityOpts, d.hardeningOpts()...)
+
+	return d
 }
 
-func (d *DockerRunner) RunInteractive(ctx context.Context, req executor.ExecRequest, input <-chan string, output chan<- string) error {
+// RunInteractive compiles the program if its language has a compile step,
+// then runs it. Each phase runs in its own container with its own limits.
+func (d *DockerRunner) RunInteractive(ctx context.Context, req executor.ExecRequest, input <-chan executor.Input, output chan<- executor.Output) (executor.ExecutionResult, error) {
+	var result executor.ExecutionResult
 	containerName := fmt.Sprintf("code-exec-%d", time.Now().UnixNano())
 
-	cmd := d.prepareCommand(ctx, containerName, req)
-
-	stdin, err := cmd.StdinPipe()
+	plan, err := d.preparePlan(containerName, req)
 	if err != nil {
-		return fmt.Errorf("error creating stdin pipe: %w", err)
+		return result, err
+	}
+	if s, ok := d.takeSlot(plan.language, req); ok {
+		// Deferred first so the slot's volume outlives its containers
+		defer d.destroySlot(s)
+		plan.usePooled(s)
 	}
+	return d.run(ctx, plan, input, output)
+}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("error creating stdout pipe: %w", err)
+// run runs the phases of the plan, compiling first if the language has a
+// compile step
+func (r *phaseRunner) run(ctx context.Context, plan executionPlan, input <-chan executor.Input, output chan<- executor.Output) (executor.ExecutionResult, error) {
+	var result executor.ExecutionResult
+	if plan.volume != "" {
+		// Deferred first so it runs after the containers are removed
+		defer r.backend.removeVolume(plan.volume)
+		if err := r.backend.createVolume(ctx, plan.volume); err != nil {
+			return result, err
+		}
+	}
+
+	if plan.compile != nil {
+		sendPhase(ctx, output, executor.PhaseCompiling)
+		compiled, err := r.compile(ctx, *plan.compile, output)
+		result.Compile = &compiled
+		result.ExitCode = compiled.ExitCode
+		result.OOMKilled = compiled.OOMKilled
+		result.TimedOut = compiled.TimedOut
+		if plan.compile.diagnose != nil {
+			result.Diagnostics = sendDiagnostics(ctx, output, plan.compile.diagnose(compiled.Output))
+		}
+		if err != nil || compiled.Failed() {
+			return result, err
+		}
+	}
+
+	sendPhase(ctx, output, executor.PhaseRunning)
+	run, err := r.runPhase(ctx, plan.run, input, output)
+	run.Compile = result.Compile
+	if plan.run.diagnose == nil {
+		run.Diagnostics = result.Diagnostics
 	}
+	return run, err
+}
+
+// runPhase runs the program, streaming its output and forwarding input
+// until it exits or its timeout passes
+func (r *phaseRunner) runPhase(ctx context.Context, p phase, input <-chan executor.Input, output chan<- executor.Output) (executor.ExecutionResult, error) {
+	var result executor.ExecutionResult
 
-	stderr, err := cmd.StderrPipe()
+	// Messages about the phase still go out after its timeout, until the
+	// run itself is cancelled
+	runCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	proc, err := r.backend.start(ctx, p)
 	if err != nil {
-		return fmt.Errorf("error creating stderr pipe: %w", err)
+		return result, err
 	}
+	// The container is kept after exit so its state can be inspected
+	defer r.backend.remove(p.container)
 
-	if err := cmd.Start(); err != nil {
-		return err
+	// Interpreters report errors on stderr while it is streamed
+	stderr := proc.stderr
+	var stderrLog *cappedBuffer
+	if p.diagnose != nil {
+		stderrLog = &cappedBuffer{limit: maxCompileOutput}
+		stderr = io.TeeReader(proc.stderr, stderrLog)
 	}
+	stream := newOutputStream(output, r.flushWindow, r.maxChunkSize)
+	stream.budget = newOutputBudget(p.spec.limits)
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	go d.handleOutput(&wg, ctx, stdout, stderr, output)
-	go d.handleInput(&wg, ctx, stdin, input, output)
+	outputDone := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		r.handleOutput(&wg, ctx, proc.stdout, stderr, stream)
+	}()
+	go r.handleInput(&wg, ctx, finished, p, proc.stdin, input, output)
 
 	done := make(chan error, 1)
 	go func() {
-		done <- cmd.Wait()
+		<-outputDone
+		done <- proc.wait()
 	}()
 
+	var runErr error
 	select {
 	case <-ctx.Done():
-		d.killContainer(containerName, output)
+		r.killContainer(runCtx, p.container, output)
+		<-done
+		close(finished)
+		wg.Wait()
+		result = proc.result()
+	case <-stream.budget.exceeded:
+		// The truncation notice is the only message the client gets
+		if err := r.backend.kill(p.container); err != nil {
+			sendStatus(runCtx, output, fmt.Sprintf("Failed to kill container: %v", err))
+		}
+		<-done
+		close(finished)
 		wg.Wait()
-		return ctx.Err()
+		result = proc.result()
 	case err := <-done:
+		close(finished)
 		wg.Wait()
-		return err
+		if err != nil {
+			return result, err
+		}
+		result = proc.result()
 	}
-}
-
-func (d *DockerRunner) prepareCommand(ctx context.Context, containerName string, req executor.ExecRequest) *exec.Cmd {
-	args := d.prepareBaseArgs(containerName)
+	// A program that stopped as the run was cancelled or timed out, on its
+	// own or at its output limit, is reported as cancelled or timed out
+	if err := ctx.Err(); err != nil {
+		result.TimedOut = err == context.DeadlineExceeded
+		runErr = err
+	}
+	result.OutputTruncated = stream.budget.truncated()
 
-	switch strings.ToLower(req.Language) {
-	case "java":
-		return d.prepareJavaCommand(ctx, args, req.Code)
-	case "c":
-		return d.prepareCCommand(ctx, args, req.Code)
-	case "c++", "cpp":
-		return d.prepareCppCommand(ctx, args, req.Code)
-	case "javascript", "js":
-		return d.prepareJavaScriptCommand(ctx, args, req.Code)
-	default:
-		args = append(args, d.imageName, strings.ToLower(req.Language), "-c", req.Code)
-		return exec.CommandContext(ctx, "docker", args...)
+	if stderrLog != nil {
+		result.Diagnostics = sendDiagnostics(runCtx, output, p.diagnose(stderrLog.String()))
 	}
+	return result, runErr
 }
 
-// Helper methods moved to container package
-func (d *DockerRunner) prepareBaseArgs(containerName string) []string {
+// prepareBaseArgs starts a docker run command with the phase limits, which
+// have every field set
+func (d *DockerRunner) prepareBaseArgs(containerName string, limits language.Limits) []string {
 	args := []string{
 		"run",
-		"--rm",
 		"--name", containerName,
 		"-i",
-		"--cpus=" + maxCPU,
-		"-m", maxMemory,
+		"--cpus=" + limits.CPUs,
+		"-m", limits.Memory,
 	}
 
 	args = append(args, d.securityOpts...)
 
+	// A later --pids-limit overrides the default from securityOpts
+	if limits.Pids != d.limits.Run.Pids {
+		args = append(args, "--pids-limit="+strconv.Itoa(limits.Pids))
+	}
+
 	return args
 }
 
-// Add other prepare*Command methods here...
+// envList returns the request environment as NAME=value, sorted by name
+func envList(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-func (d *DockerRunner) handleOutput(wg *sync.WaitGroup, ctx context.Context, stdout, stderr io.ReadCloser, output chan<- string) {
-	defer wg.Done()
-	scanner := bufio.NewScanner(io.MultiReader(stdout, stderr))
-	for scanner.Scan() {
-		select {
-		case output <- scanner.Text():
-		case <-ctx.Done():
-			return
-		}
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, name+"="+env[name])
+	}
+	return list
+}
+
+// envArgs passes the environment to docker run or docker exec
+func envArgs(env []string) []string {
+	args := make([]string, 0, 2*len(env))
+	for _, v := range env {
+		args = append(args, "-e", v)
 	}
+	return args
 }
 
-func (d *DockerRunner) handleInput(wg *sync.WaitGroup, ctx context.Context, stdin io.WriteCloser, input <-chan string, output chan<- string) {
+// handleOutput reads stdout and stderr concurrently so neither pipe can
+// block the program while the other is being drained
+func (r *phaseRunner) handleOutput(wg *sync.WaitGroup, ctx context.Context, stdout, stderr io.Reader, stream *outputStream) {
+	defer wg.Done()
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go stream.read(&readers, ctx, executor.OutputStdout, stdout)
+	go stream.read(&readers, ctx, executor.OutputStderr, stderr)
+	readers.Wait()
+}
+
+func (r *phaseRunner) handleInput(wg *sync.WaitGroup, ctx context.Context, finished <-chan struct{}, p phase, stdin io.WriteCloser, input <-chan executor.Input, output chan<- executor.Output) {
 	defer wg.Done()
 	defer stdin.Close()
 
+	if len(p.archive) > 0 {
+		if _, err := stdin.Write(p.archive); err != nil {
+			sendStatus(ctx, output, "Error staging source: "+err.Error())
+			return
+		}
+	}
+
+	stdinOpen := true
 	for {
 		select {
-		case inputLine, ok := <-input:
+		case msg, ok := <-input:
 			if !ok {
 				return
 			}
-			if _, err := fmt.Fprintln(stdin, inputLine); err != nil {
-				output <- "Error writing to stdin: " + err.Error()
-				return
+			switch msg.Type {
+			case executor.InputStdin:
+				if !stdinOpen {
+					continue
+				}
+				if _, err := io.WriteString(stdin, msg.Data); err != nil {
+					sendStatus(ctx, output, "Error writing to stdin: "+err.Error())
+					return
+				}
+			case executor.InputEOF:
+				if stdinOpen {
+					stdin.Close()
+					stdinOpen = false
+				}
+			case executor.InputSignal:
+				r.signalProgram(ctx, p, msg.Data, output)
+			case executor.InputResize:
+				// Containers run without a TTY, so there is nothing to resize
 			}
+		case <-finished:
+			return
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (d *DockerRunner) killContainer(containerName string, output chan<- string) {
-	killCmd := exec.Command("docker", "kill", containerName)
-	if err := killCmd.Run(); err != nil {
-		output <- fmt.Sprintf("Failed to kill container: %v", err)
+func (r *phaseRunner) killContainer(ctx context.Context, containerName string, output chan<- executor.Output) {
+	if err := r.backend.kill(containerName); err != nil {
+		sendStatus(ctx, output, fmt.Sprintf("Failed to kill container: %v", err))
 	} else {
-		output <- "Container killed successfully"
+		sendStatus(ctx, output, "Container killed successfully")
+	}
+}
+
+// signalProgram forwards a client signal to the program. It is the main
+// process of its container unless the container is pooled, where the main
+// process only keeps the container alive and every other process is
+// signalled instead.
+func (r *phaseRunner) signalProgram(ctx context.Context, p phase, signal string, output chan<- executor.Output) {
+	signal = strings.TrimPrefix(strings.ToUpper(signal), "SIG")
+	if !allowedSignals[signal] {
+		sendStatus(ctx, output, fmt.Sprintf("Unsupported signal: %s", signal))
+		return
+	}
+
+	if err := r.backend.signal(p, signal); err != nil {
+		sendStatus(ctx, output, fmt.Sprintf("Failed to send %s: %v", signal, err))
+	}
+}
+
+// sendPhase reports that the run entered a new phase
+func sendPhase(ctx context.Context, output chan<- executor.Output, name string) {
+	send(ctx, output, executor.Output{Type: executor.OutputPhase, Data: name})
+}
+
+// sendStatus reports a runner message that is not program output
+func sendStatus(ctx context.Context, output chan<- executor.Output, msg string) {
+	send(ctx, output, executor.Output{Type: executor.OutputStatus, Data: msg})
+}
+
+// send delivers a runner message. It is dropped once ctx is done and the
+// channel is full, as nobody may be reading it any more, so a run whose
+// client went away can still stop and clean up.
+func send(ctx context.Context, output chan<- executor.Output, out executor.Output) {
+	select {
+	case output <- out:
+		return
+	default:
+	}
+	select {
+	case output <- out:
+	case <-ctx.Done():
 	}
 }
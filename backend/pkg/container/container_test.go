@@ -1,23 +1,46 @@
 package container
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/tiakavousi/codeplayground/pkg/config"
+	"github.com/tiakavousi/codeplayground/pkg/engine"
+	"github.com/tiakavousi/codeplayground/pkg/engine/enginetest"
 	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
 )
 
+func TestMain(m *testing.M) {
+	// LocalRunner starts the test binary as the init of its sandboxes
+	RunSandboxInit()
+	code := m.Run()
+	os.RemoveAll(mockKillDir)
+	os.Exit(code)
+}
+
 // TestDockerRunner wraps DockerRunner for testing
 type TestDockerRunner struct {
 	*DockerRunner
 	execCommand       commandFunc
-	killContainerFunc func(containerName string, output chan<- string)
+	killContainerFunc func(containerName string, output chan<- executor.Output)
 }
 
 // commandFunc is a function type for executing commands
@@ -26,6 +49,10 @@ type commandFunc func(name string, args ...string) *exec.Cmd
 // execCommand is our package-level variable for the command function
 var execCommand = exec.Command
 
+// mockKillDir is where the mocked docker kill marks the containers it
+// killed, so programs that hang until killed can exit
+var mockKillDir = filepath.Join(os.TempDir(), fmt.Sprintf("codeplayground-mock-kill-%d", os.Getpid()))
+
 // mockCommand creates a command that simulates Docker behavior
 func mockCommand(name string, args ...string) *exec.Cmd {
 	cs := []string{"-test.run=TestHelperProcess", "--", name}
@@ -35,6 +62,7 @@ func mockCommand(name string, args ...string) *exec.Cmd {
 		"GO_WANT_HELPER_PROCESS=1",
 		"MOCK_COMMAND=" + name,
 		"MOCK_ARGS=" + strings.Join(args, " "),
+		"MOCK_KILL_DIR=" + mockKillDir,
 	}
 	return cmd
 }
@@ -51,28 +79,110 @@ func TestHelperProcess(t *testing.T) {
 	mockArgs := strings.Split(os.Getenv("MOCK_ARGS"), " ")
 
 	if mockCmd == "docker" {
-		if len(mockArgs) > 0 && mockArgs[0] == "run" {
+		if len(mockArgs) > 0 && (mockArgs[0] == "run" || mockArgs[0] == "exec") {
+			program := strings.Join(mockArgs, " ")
+			var staged []stagedFile
+			if m := stagePattern.FindStringSubmatch(os.Getenv("MOCK_ARGS")); m != nil {
+				staged = readStagedFiles(os.Stdin, m[1])
+			}
+			for _, f := range staged {
+				program += " " + f.content
+			}
+			simulateProgram(program)
+			for _, f := range staged {
+				fmt.Printf("%s:%s\n", f.name, hex.EncodeToString([]byte(f.content)))
+			}
 			fmt.Println("Container output")
-			if strings.Contains(strings.Join(mockArgs, " "), "while True: pass") {
+			if strings.Contains(program, "while True: pass") {
 				time.Sleep(200 * time.Millisecond) // Simulate long-running process
 			}
-		} else if len(mockArgs) > 0 && mockArgs[0] == "kill" {
+		} else if len(mockArgs) > 1 && mockArgs[0] == "kill" {
+			killDir := os.Getenv("MOCK_KILL_DIR")
+			os.MkdirAll(killDir, 0o755)
+			os.WriteFile(filepath.Join(killDir, mockArgs[len(mockArgs)-1]), nil, 0o644)
 			fmt.Println("Container killed successfully")
 		}
 	}
 	os.Exit(0)
 }
 
-func (d *TestDockerRunner) killContainer(containerName string, output chan<- string) {
+// simulateProgram mimics programs whose code contains a known marker
+func simulateProgram(args string) {
+	switch {
+	case strings.Contains(args, "HANG_UNTIL_KILLED"):
+		// Block until docker kill marks the container, like a program
+		// that never exits on its own
+		name := regexp.MustCompile(`--name (\S+)`).FindStringSubmatch(args)[1]
+		for {
+			if _, err := os.Stat(filepath.Join(os.Getenv("MOCK_KILL_DIR"), name)); err == nil {
+				os.Exit(137)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	case strings.Contains(args, "WARN_THEN_READ"):
+		// Warn on stderr, then block until a line of input arrives
+		fmt.Fprintln(os.Stderr, "warning")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		fmt.Print("got " + line)
+	case strings.Contains(args, "EXIT_3"):
+		fmt.Println("failing")
+		os.Exit(3)
+	case strings.Contains(args, "PROMPT_THEN_READ"):
+		fmt.Print("Enter your name: ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		fmt.Print("Hello, " + line)
+	case strings.Contains(args, "TRACEBACK"):
+		fmt.Fprint(os.Stderr, "Traceback (most recent call last):\n"+
+			"  File \"/sandbox/tmp/main.py\", line 1, in <module>\n"+
+			"    TRACEBACK\n"+
+			"NameError: name 'x' is not defined\n")
+	case strings.Contains(args, "STDERR_FLOOD"):
+		// Fill the stderr pipe well past its buffer before touching stdout
+		for i := 0; i < 10000; i++ {
+			fmt.Fprintln(os.Stderr, strings.Repeat("e", 64))
+		}
+		fmt.Println("stdout after flood")
+	}
+}
+
+// stagePattern matches the extraction step of a staged run
+var stagePattern = regexp.MustCompile(`head -c (\d+) \| tar`)
+
+// stagedFile is a file unpacked from the staged archive
+type stagedFile struct {
+	name    string
+	content string
+}
+
+// readStagedFiles reads the staged archive from stdin like the container
+// would. The helper prints each file as "name:hexcontent".
+func readStagedFiles(stdin io.Reader, size string) []stagedFile {
+	n, _ := strconv.Atoi(size)
+	tr := tar.NewReader(io.LimitReader(stdin, int64(n)))
+	var files []stagedFile
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			return files
+		}
+		content, _ := io.ReadAll(tr)
+		files = append(files, stagedFile{name: header.Name, content: string(content)})
+	}
+}
+
+func (d *TestDockerRunner) killContainer(containerName string, output chan<- executor.Output) {
 	d.killContainerFunc(containerName, output)
 }
 
-func NewTestDockerRunner(imageName string) *TestDockerRunner {
+func NewTestDockerRunner(imageName string, opts ...Option) *TestDockerRunner {
 	runner := &TestDockerRunner{
-		DockerRunner: NewDockerRunner(imageName),
+		DockerRunner: NewDockerRunner(imageName, opts...),
 		execCommand:  execCommand,
 	}
 	runner.killContainerFunc = runner.killContainer
+	runner.DockerRunner.command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return runner.execCommand(name, args...)
+	}
 	return runner
 }
 
@@ -99,7 +209,7 @@ func TestRunInteractive(t *testing.T) {
 			code:       "print('hello')",
 			input:      []string{},
 			wantOutput: []string{"Container output"},
-			timeout:    2 * time.Second,
+			timeout:    2*time.Second + raceSlack,
 			wantErr:    false,
 		},
 		{
@@ -117,22 +227,22 @@ func TestRunInteractive(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			runner := NewTestDockerRunner("tayebe/repl")
 			runner.execCommand = mockCommand
-			runner.killContainerFunc = func(containerName string, output chan<- string) {
+			runner.killContainerFunc = func(containerName string, output chan<- executor.Output) {
 				fmt.Println("Container killed successfully")
 			}
 
 			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
 			defer cancel()
 
-			input := make(chan string, len(tt.input))
-			output := make(chan string, 10)
+			input := make(chan executor.Input, len(tt.input))
+			output := make(chan executor.Output, 10)
 
 			// Send inputs
 			go func() {
 				defer close(input)
 				for _, in := range tt.input {
 					select {
-					case input <- in:
+					case input <- executor.Input{Type: executor.InputStdin, Data: in + "\n"}:
 					case <-ctx.Done():
 						return
 					}
@@ -146,7 +256,7 @@ func TestRunInteractive(t *testing.T) {
 
 			go func() {
 				defer close(done)
-				runErr = runner.RunInteractive(ctx, executor.ExecRequest{
+				_, runErr = runner.RunInteractive(ctx, executor.ExecRequest{
 					Language: tt.language,
 					Code:     tt.code,
 				}, input, output)
@@ -158,7 +268,7 @@ func TestRunInteractive(t *testing.T) {
 			go func() {
 				defer close(outputDone)
 				for line := range output {
-					gotOutput = append(gotOutput, line)
+					gotOutput = append(gotOutput, line.Data)
 				}
 			}()
 
@@ -169,14 +279,14 @@ func TestRunInteractive(t *testing.T) {
 				if (runErr != nil) != tt.wantErr {
 					t.Errorf("RunInteractive() error = %v, wantErr %v", runErr, tt.wantErr)
 				}
-			case <-time.After(tt.timeout + time.Second):
+			case <-time.After(tt.timeout + time.Second + raceSlack):
 				t.Fatal("Test timeout exceeded")
 			}
 
 			// Wait for output collection
 			select {
 			case <-outputDone:
-			case <-time.After(500 * time.Millisecond):
+			case <-time.After(500*time.Millisecond + raceSlack):
 				t.Fatal("Timeout waiting for output collection")
 			}
 
@@ -197,7 +307,7 @@ func TestRunInteractive(t *testing.T) {
 	}
 }
 
-func TestPrepareCommand(t *testing.T) {
+func TestPreparePlan(t *testing.T) {
 	tests := []struct {
 		name     string
 		language string
@@ -208,62 +318,1808 @@ func TestPrepareCommand(t *testing.T) {
 			name:     "Python Command",
 			language: "python3",
 			code:     "print('hello')",
-			wantArgs: []string{"python3", "-c", "print('hello')"},
+			wantArgs: []string{"bash", "-c", "cd /sandbox/tmp && head -c 2048 | tar -x -f - && exec 'python3' 'main.py'"},
 		},
 		{
 			name:     "JavaScript Command",
 			language: "javascript",
 			code:     "console.log('hello')",
-			wantArgs: []string{"node", "-e", "console.log('hello')"},
+			wantArgs: []string{"bash", "-c", "cd /sandbox/tmp && head -c 2048 | tar -x -f - && exec 'node' 'main.js'"},
+		},
+		{
+			name:     "Bash Command",
+			language: "sh",
+			code:     "echo hello",
+			wantArgs: []string{"bash", "-c", "cd /sandbox/tmp && head -c 2048 | tar -x -f - && exec 'bash' 'main.sh'"},
 		},
 	}
 
-	ctx := context.Background()
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			runner := NewTestDockerRunner("tayebe/repl")
-			runner.execCommand = mockCommand
 
-			cmd := runner.prepareCommand(ctx, "test-container", executor.ExecRequest{
+			plan, err := runner.preparePlan("test-container", executor.ExecRequest{
 				Language: tt.language,
 				Code:     tt.code,
 			})
+			if err != nil {
+				t.Fatalf("preparePlan() error = %v", err)
+			}
+			if plan.compile != nil || plan.volume != "" {
+				t.Errorf("preparePlan() compiles an interpreted language: %+v", plan)
+			}
 
 			for _, arg := range tt.wantArgs {
 				found := false
-				for _, cmdArg := range cmd.Args {
-					if cmdArg == arg {
+				for _, runArg := range plan.run.args {
+					if runArg == arg {
 						found = true
 						break
 					}
 				}
 				if !found {
-					t.Errorf("prepareCommand() missing argument %q in %v", arg, cmd.Args)
+					t.Errorf("preparePlan() missing argument %q in %v", arg, plan.run.args)
 				}
 			}
 		})
 	}
 }
 
-func TestNewDockerRunner(t *testing.T) {
-	imageName := "tayebe/repl"
-	runner := NewDockerRunner(imageName)
+func TestPreparePlanUnknownLanguage(t *testing.T) {
+	runner := NewTestDockerRunner("tayebe/repl")
 
-	if runner.imageName != imageName {
-		t.Errorf("NewDockerRunner() imageName = %v, want %v", runner.imageName, imageName)
+	_, err := runner.preparePlan("test-container", executor.ExecRequest{
+		Language: "perl",
+		Code:     "print 'hello'",
+	})
+	if !errors.Is(err, executor.ErrInvalidLanguage) {
+		t.Errorf("preparePlan() error = %v, want %v", err, executor.ErrInvalidLanguage)
 	}
+}
 
-	expectedOpts := []string{
-		"--cap-drop=ALL",
-		"--net=none",
-		"--pids-limit=20",
-		"--ulimit", "nproc=20:20",
-		"--ulimit", "nofile=64:64",
-		"--ulimit", "fsize=1000000:1000000",
+func TestPreparePlanLanguageLimits(t *testing.T) {
+	registry, err := language.NewRegistry([]language.Language{
+		{
+			ID:     "python",
+			Run:    []string{"python3", "-c", language.CodePlaceholder},
+			Image:  "example/python",
+			Limits: language.Limits{Memory: "256m", Pids: 50},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
 	}
 
-	if !reflect.DeepEqual(runner.securityOpts, expectedOpts) {
-		t.Errorf("NewDockerRunner() securityOpts = %v, want %v", runner.securityOpts, expectedOpts)
+	runner := NewTestDockerRunner("tayebe/repl")
+	runner.languages = registry
+
+	plan, err := runner.preparePlan("test-container", executor.ExecRequest{
+		Language: "Python",
+		Code:     "print('hello')",
+	})
+	if err != nil {
+		t.Fatalf("preparePlan() error = %v", err)
+	}
+
+	args := strings.Join(plan.run.args, " ")
+	for _, want := range []string{"-m 256m", "--pids-limit=50", "example/python python3 -c"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("preparePlan() args %q missing %q", args, want)
+		}
+	}
+	if want := config.Default().Limits.Run.Timeout; plan.run.timeout != want {
+		t.Errorf("preparePlan() run timeout = %v, want %v", plan.run.timeout, want)
+	}
+}
+
+func TestPreparePlanRequestLimits(t *testing.T) {
+	limits := config.Default().Limits
+	limits.Languages = map[string]config.LanguageLimits{
+		"python": {Run: language.Limits{CPUs: "0.75"}},
+	}
+	runner := NewTestDockerRunner("tayebe/repl")
+	runner.limits = limits
+
+	plan, err := runner.preparePlan("test-container", executor.ExecRequest{
+		Language: "python",
+		Code:     "print('hello')",
+		Limits:   language.Limits{Memory: "512m", Pids: 40, Timeout: 20 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("preparePlan() error = %v", err)
+	}
+	args := strings.Join(plan.run.args, " ")
+	for _, want := range []string{"--cpus=0.75", "-m 512m", "--pids-limit=40"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("preparePlan() args %q missing %q", args, want)
+		}
+	}
+	if plan.run.timeout != 20*time.Second {
+		t.Errorf("preparePlan() run timeout = %v, want 20s", plan.run.timeout)
+	}
+
+	_, err = runner.preparePlan("test-container", executor.ExecRequest{
+		Language: "python",
+		Code:     "print('hello')",
+		Limits:   language.Limits{Memory: "8g"},
+	})
+	if !errors.Is(err, executor.ErrInvalidRequest) {
+		t.Errorf("preparePlan() error = %v, want %v above the max", err, executor.ErrInvalidRequest)
+	}
+}
+
+func TestRunInteractiveStagesSourceIntact(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+	}{
+		{name: "Single Quotes", code: `int main() { char c = '\''; puts("it's"); }`},
+		{name: "Quote Breakout", code: "'; touch /tmp/pwned; echo '"},
+		{name: "Backslashes", code: `printf("a\\b\n\t\x41");`},
+		{name: "Command Substitution", code: "/* $(id) `id` ${HOME} */ int main() { return 0; }"},
+		{name: "Binary-ish Content", code: binaryishContent()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewTestDockerRunner("tayebe/repl")
+			runner.execCommand = mockCommand
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second+raceSlack)
+			defer cancel()
+
+			input := make(chan executor.Input)
+			close(input)
+			output := make(chan executor.Output, 10)
+
+			result, err := runner.RunInteractive(ctx, executor.ExecRequest{Language: "c", Code: tt.code}, input, output)
+			close(output)
+			if err != nil {
+				t.Fatalf("RunInteractive() error = %v", err)
+			}
+
+			// The compile container unpacks the source
+			want := "main.c:" + hex.EncodeToString([]byte(tt.code))
+			if result.Compile == nil {
+				t.Fatal("RunInteractive() did not compile")
+			}
+			if got := strings.Split(result.Compile.Output, "\n")[0]; got != want {
+				t.Errorf("RunInteractive() staged %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestPreparePlanKeepsSourceOutOfArgs(t *testing.T) {
+	runner := NewTestDockerRunner("tayebe/repl")
+	code := "int main() { puts(\"$(whoami)'\"); }"
+
+	for _, lang := range []string{"java", "c", "cpp"} {
+		plan, err := runner.preparePlan("test-container", executor.ExecRequest{
+			Language: lang,
+			Code:     code,
+		})
+		if err != nil {
+			t.Fatalf("preparePlan(%s) error = %v", lang, err)
+		}
+		if plan.compile == nil || len(plan.compile.archive) == 0 {
+			t.Fatalf("preparePlan(%s) stages no source for the compile phase", lang)
+		}
+		for _, arg := range append(plan.compile.args, plan.run.args...) {
+			if strings.Contains(arg, "whoami") {
+				t.Errorf("preparePlan(%s) leaked source into args: %q", lang, arg)
+			}
+		}
+	}
+}
+
+func TestPreparePlanMultipleFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         executor.ExecRequest
+		wantCompile string
+		wantScript  string
+		wantFiles   []string
+	}{
+		{
+			name: "Java Classes",
+			req: executor.ExecRequest{
+				Language: "java",
+				Code:     "class Main {}",
+				Files:    []executor.File{{Path: "Helper.java", Content: "class Helper {}"}},
+			},
+			wantCompile: "'javac' 'Helper.java' 'Main.java'",
+			wantScript:  "cd /sandbox/tmp && exec 'java' 'Main'",
+			wantFiles:   []string{"Helper.java", "Main.java"},
+		},
+		{
+			name: "C With Header",
+			req: executor.ExecRequest{
+				Language: "c",
+				Files: []executor.File{
+					{Path: "main.c", Content: "#include \"util.h\""},
+					{Path: "util.h", Content: "int add(int, int);"},
+					{Path: "lib/util.c", Content: "int add(int a, int b) { return a + b; }"},
+				},
+			},
+			wantCompile: "'gcc' 'lib/util.c' 'main.c' '-o' 'main'",
+			wantScript:  "cd /sandbox/tmp && exec './main'",
+			wantFiles:   []string{"lib/util.c", "main.c", "util.h"},
+		},
+		{
+			name: "C++ Options",
+			req: executor.ExecRequest{
+				Language:     "cpp",
+				Code:         "int main() {}",
+				Standard:     "c++20",
+				Optimization: "O2",
+				Flags:        []string{"-Wall"},
+			},
+			wantCompile: "'g++' 'main.cpp' '-std=c++20' '-O2' '-Wall' '-o' 'main'",
+			wantScript:  "cd /sandbox/tmp && exec './main'",
+			wantFiles:   []string{"main.cpp"},
+		},
+		{
+			name: "Python Package Entrypoint",
+			req: executor.ExecRequest{
+				Language: "python",
+				Files: []executor.File{
+					{Path: "app/__init__.py", Content: ""},
+					{Path: "run.py", Content: "import app"},
+				},
+				Entrypoint: "run.py",
+			},
+			wantScript: "exec 'python3' 'run.py'",
+			wantFiles:  []string{"app/__init__.py", "run.py"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewTestDockerRunner("tayebe/repl")
+
+			plan, err := runner.preparePlan("test-container", tt.req)
+			if err != nil {
+				t.Fatalf("preparePlan() error = %v", err)
+			}
+
+			archive := plan.run.archive
+			if tt.wantCompile != "" {
+				if plan.compile == nil {
+					t.Fatal("preparePlan() has no compile phase")
+				}
+				archive = plan.compile.archive
+				script := plan.compile.args[len(plan.compile.args)-1]
+				if !strings.HasSuffix(script, tt.wantCompile) {
+					t.Errorf("preparePlan() compile script = %q, want suffix %q", script, tt.wantCompile)
+				}
+			}
+
+			script := plan.run.args[len(plan.run.args)-1]
+			if !strings.HasSuffix(script, tt.wantScript) {
+				t.Errorf("preparePlan() run script = %q, want suffix %q", script, tt.wantScript)
+			}
+
+			var names []string
+			tr := tar.NewReader(strings.NewReader(string(archive)))
+			for {
+				header, err := tr.Next()
+				if err != nil {
+					break
+				}
+				names = append(names, header.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantFiles) {
+				t.Errorf("preparePlan() staged %v, want %v", names, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestPreparePlanInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		req  executor.ExecRequest
+	}{
+		{
+			name: "Missing Entrypoint",
+			req: executor.ExecRequest{
+				Language: "c",
+				Files:    []executor.File{{Path: "util.c", Content: "int x;"}},
+			},
+		},
+		{
+			name: "Unknown Entrypoint",
+			req: executor.ExecRequest{
+				Language:   "python",
+				Code:       "print('hello')",
+				Entrypoint: "other.py",
+			},
+		},
+		{
+			name: "Unlisted Flag",
+			req: executor.ExecRequest{
+				Language: "c",
+				Code:     "int main() {}",
+				Flags:    []string{"-fplugin=/tmp/evil.so"},
+			},
+		},
+		{
+			name: "File Conflicts With Code",
+			req: executor.ExecRequest{
+				Language: "python",
+				Code:     "print('hello')",
+				Files:    []executor.File{{Path: "main.py", Content: "print('other')"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewTestDockerRunner("tayebe/repl")
+
+			_, err := runner.preparePlan("test-container", tt.req)
+			if !errors.Is(err, executor.ErrInvalidRequest) {
+				t.Errorf("preparePlan() error = %v, want %v", err, executor.ErrInvalidRequest)
+			}
+		})
+	}
+}
+
+func TestPreparePlanArgsAndEnv(t *testing.T) {
+	runner := NewTestDockerRunner("tayebe/repl")
+
+	plan, err := runner.preparePlan("test-container", executor.ExecRequest{
+		Language: "python",
+		Code:     "import sys; print(sys.argv)",
+		Args:     []string{"input file.txt", "$(id)"},
+		Env:      map[string]string{"NAME": "it's me", "COUNT": "3"},
+	})
+	if err != nil {
+		t.Fatalf("preparePlan() error = %v", err)
+	}
+
+	args := strings.Join(plan.run.args, " ")
+	if !strings.Contains(args, "-e COUNT=3 -e NAME=it's me --tmpfs /sandbox/tmp:rw,exec,nosuid,nodev,mode=1777,size=64m tayebe/repl") {
+		t.Errorf("preparePlan() args %q missing environment before the image", args)
+	}
+	script := plan.run.args[len(plan.run.args)-1]
+	if want := `exec 'python3' 'main.py' 'input file.txt' '$(id)'`; !strings.HasSuffix(script, want) {
+		t.Errorf("preparePlan() script = %q, want suffix %q", script, want)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "main.c", want: "'main.c'"},
+		{in: "it's", want: `'it'\''s'`},
+		{in: "$(id)", want: "'$(id)'"},
+		{in: "", want: "''"},
+	}
+
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// binaryishContent returns every byte value except NUL
+func binaryishContent() string {
+	b := make([]byte, 0, 255)
+	for i := 1; i < 256; i++ {
+		b = append(b, byte(i))
+	}
+	return string(b)
+}
+
+func TestRunInteractiveSeparatesStreams(t *testing.T) {
+	runner := NewTestDockerRunner("tayebe/repl")
+	runner.execCommand = mockCommand
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	input := make(chan executor.Input)
+	output := make(chan executor.Output, 10)
+	errCh := make(chan error, 1)
+
+	go func() {
+		_, err := runner.RunInteractive(ctx, executor.ExecRequest{Language: "python", Code: "WARN_THEN_READ"}, input, output)
+		errCh <- err
+		close(output)
+	}()
+
+	if out := <-output; out.Type != executor.OutputPhase || out.Data != executor.PhaseRunning {
+		t.Fatalf("first output = %+v, want running phase", out)
+	}
+
+	// The warning must arrive while the program is still waiting for input
+	select {
+	case out := <-output:
+		if out.Type != executor.OutputStderr || out.Data != "warning\n" {
+			t.Fatalf("output = %+v, want stderr warning", out)
+		}
+	case <-ctx.Done():
+		t.Fatal("stderr was not delivered before input")
+	}
+
+	input <- executor.Input{Type: executor.InputStdin, Data: "name\n"}
+	close(input)
+
+	var got []executor.Output
+	for out := range output {
+		got = append(got, out)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if len(got) == 0 || got[0].Type != executor.OutputStdout || !strings.HasPrefix(got[0].Data, "got name\n") {
+		t.Errorf("RunInteractive() output = %+v, want stdout echo", got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Elapsed < got[i-1].Elapsed {
+			t.Errorf("output %d elapsed %v before previous %v", i, got[i].Elapsed, got[i-1].Elapsed)
+		}
+	}
+}
+
+func TestRunInteractiveStderrFlood(t *testing.T) {
+	limits := config.Default().Limits
+	limits.Run.OutputLines = 20000
+	runner := NewTestDockerRunner("tayebe/repl", WithLimits(limits))
+	runner.execCommand = mockCommand
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	input := make(chan executor.Input)
+	close(input)
+	output := make(chan executor.Output, 100)
+	errCh := make(chan error, 1)
+
+	go func() {
+		_, err := runner.RunInteractive(ctx, executor.ExecRequest{Language: "python", Code: "STDERR_FLOOD"}, input, output)
+		errCh <- err
+		close(output)
+	}()
+
+	bytes := make(map[executor.OutputType]int)
+	for out := range output {
+		bytes[out.Type] += len(out.Data)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if bytes[executor.OutputStderr] != 10000*65 || bytes[executor.OutputStdout] == 0 {
+		t.Errorf("RunInteractive() output bytes = %v", bytes)
+	}
+}
+
+func TestRunInteractiveOutputLimit(t *testing.T) {
+	limits := config.Default().Limits
+	limits.Run.OutputLines = 100
+	runner := NewTestDockerRunner("tayebe/repl", WithLimits(limits))
+	runner.execCommand = mockCommand
+
+	input := make(chan executor.Input)
+	close(input)
+	output := make(chan executor.Output, 100)
+	errCh := make(chan error, 1)
+	var result executor.ExecutionResult
+	go func() {
+		var err error
+		result, err = runner.RunInteractive(context.Background(), executor.ExecRequest{Language: "python", Code: "STDERR_FLOOD"}, input, output)
+		errCh <- err
+		close(output)
+	}()
+
+	bytes := make(map[executor.OutputType]int)
+	var statuses []string
+	for out := range output {
+		bytes[out.Type] += len(out.Data)
+		if out.Type == executor.OutputStatus {
+			statuses = append(statuses, out.Data)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if bytes[executor.OutputStderr] != 100*65 || bytes[executor.OutputStdout] != 0 {
+		t.Errorf("RunInteractive() output bytes = %v, want the first 100 lines", bytes)
+	}
+	if !reflect.DeepEqual(statuses, []string{"output truncated after 100 lines"}) {
+		t.Errorf("RunInteractive() statuses = %q, want a single truncation notice", statuses)
+	}
+	if !result.OutputTruncated {
+		t.Errorf("RunInteractive() result = %+v, want OutputTruncated", result)
+	}
+}
+
+func TestRunInteractiveFlushesPrompt(t *testing.T) {
+	runner := NewTestDockerRunner("tayebe/repl")
+	runner.execCommand = mockCommand
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	input := make(chan executor.Input)
+	output := make(chan executor.Output, 10)
+	errCh := make(chan error, 1)
+
+	go func() {
+		_, err := runner.RunInteractive(ctx, executor.ExecRequest{Language: "python", Code: "PROMPT_THEN_READ"}, input, output)
+		errCh <- err
+		close(output)
+	}()
+
+	if out := <-output; out.Type != executor.OutputPhase || out.Data != executor.PhaseRunning {
+		t.Fatalf("first output = %+v, want running phase", out)
+	}
+
+	// The prompt has no trailing newline and must not wait for one
+	select {
+	case out := <-output:
+		if out.Type != executor.OutputStdout || out.Data != "Enter your name: " {
+			t.Fatalf("output = %+v, want prompt", out)
+		}
+	case <-ctx.Done():
+		t.Fatal("prompt was not delivered before input")
+	}
+
+	input <- executor.Input{Type: executor.InputStdin, Data: "Ada\n"}
+	close(input)
+	for range output {
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+}
+
+func TestRunInteractiveCompilePhases(t *testing.T) {
+	registry, err := language.NewRegistry([]language.Language{
+		{
+			ID:         "c",
+			SourceFile: "main.c",
+			Compile:    []string{"gcc", "main.c", "-o", "main"},
+			Run:        []string{"./main"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		timeout     time.Duration
+		wantPhases  []string
+		wantCompile string
+		wantStdout  string
+		wantFailed  bool
+		wantErr     error
+	}{
+		{
+			name:        "Compiles Then Runs",
+			code:        "int main() { return 0; }",
+			wantPhases:  []string{executor.PhaseCompiling, executor.PhaseRunning},
+			wantCompile: "Container output\n",
+			wantStdout:  "Container output\n",
+		},
+		{
+			name:        "Compile Error",
+			code:        "EXIT_3",
+			wantPhases:  []string{executor.PhaseCompiling},
+			wantCompile: "failing\n",
+			wantFailed:  true,
+		},
+		{
+			name:       "Compile Timeout",
+			code:       "/* HANG_UNTIL_KILLED */",
+			timeout:    50 * time.Millisecond,
+			wantPhases: []string{executor.PhaseCompiling},
+			wantFailed: true,
+			wantErr:    context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The success paths get the default compile timeout, as helper
+			// processes are slow to start and exit under -race
+			limits := config.Default().Limits
+			if tt.timeout > 0 {
+				limits.Languages = map[string]config.LanguageLimits{"c": {Compile: language.Limits{Timeout: tt.timeout}}}
+			}
+			runner := NewTestDockerRunner("tayebe/repl", WithLimits(limits))
+			runner.languages = registry
+			runner.execCommand = mockCommand
+
+			input := make(chan executor.Input)
+			close(input)
+			output := make(chan executor.Output, 10)
+
+			result, err := runner.RunInteractive(context.Background(), executor.ExecRequest{Language: "c", Code: tt.code}, input, output)
+			close(output)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RunInteractive() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var phases []string
+			var stdout strings.Builder
+			for out := range output {
+				switch out.Type {
+				case executor.OutputPhase:
+					phases = append(phases, out.Data)
+				case executor.OutputStdout:
+					stdout.WriteString(out.Data)
+				}
+			}
+			if !reflect.DeepEqual(phases, tt.wantPhases) {
+				t.Errorf("RunInteractive() phases = %v, want %v", phases, tt.wantPhases)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("RunInteractive() stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+
+			if result.Compile == nil {
+				t.Fatal("RunInteractive() returned no compile result")
+			}
+			if result.Compile.Failed() != tt.wantFailed || result.Compile.TimedOut != (tt.wantErr != nil) {
+				t.Errorf("RunInteractive() compile = %+v, want failed %v", result.Compile, tt.wantFailed)
+			}
+			if !strings.HasSuffix(result.Compile.Output, tt.wantCompile) {
+				t.Errorf("RunInteractive() compile output = %q, want suffix %q", result.Compile.Output, tt.wantCompile)
+			}
+		})
+	}
+}
+
+func TestDiagnosticParsers(t *testing.T) {
+	tests := []struct {
+		fixture string
+		format  string
+		want    []executor.Diagnostic
+	}{
+		{
+			fixture: "gcc.txt",
+			format:  language.DiagnosticsGCC,
+			want: []executor.Diagnostic{
+				{File: "main.c", Line: 6, Column: 30, Severity: executor.SeverityError, Message: "expected ';' before 'return'"},
+				{File: "main.c", Line: 5, Column: 9, Severity: executor.SeverityWarning, Message: "unused variable 'unused' [-Wunused-variable]"},
+				{File: "util.c", Line: 1, Column: 37, Severity: executor.SeverityError, Message: "expected ';' before '}' token"},
+			},
+		},
+		{
+			fixture: "g++.txt",
+			format:  language.DiagnosticsGCC,
+			want: []executor.Diagnostic{
+				{File: "main.cpp", Line: 4, Column: 7, Severity: executor.SeverityError, Message: "'class std::vector<int>' has no member named 'push'"},
+				{File: "main.cpp", Line: 5, Column: 13, Severity: executor.SeverityError, Message: "invalid conversion from 'const char*' to 'int' [-fpermissive]"},
+			},
+		},
+		{
+			fixture: "ld.txt",
+			format:  language.DiagnosticsGCC,
+			want: []executor.Diagnostic{
+				{File: "link.c", Severity: executor.SeverityError, Message: "undefined reference to `missing'"},
+			},
+		},
+		{
+			fixture: "javac.txt",
+			format:  language.DiagnosticsJavac,
+			want: []executor.Diagnostic{
+				{File: "Main.java", Line: 5, Column: 50, Severity: executor.SeverityError, Message: "';' expected"},
+				{File: "util/Helper.java", Line: 3, Column: 28, Severity: executor.SeverityError, Message: "cannot find symbol\nsymbol:   variable nam\nlocation: class Helper"},
+				{File: "Main.java", Line: 8, Column: 25, Severity: executor.SeverityWarning, Message: "[removal] Integer(int) in Integer has been deprecated and marked for removal"},
+			},
+		},
+		{
+			fixture: "python.txt",
+			format:  language.DiagnosticsPython,
+			want: []executor.Diagnostic{
+				{File: "pkg/helpers.py", Line: 2, Severity: executor.SeverityError, Message: "ZeroDivisionError: division by zero"},
+			},
+		},
+		{
+			fixture: "python_syntax.txt",
+			format:  language.DiagnosticsPython,
+			want: []executor.Diagnostic{
+				{File: "syntax.py", Line: 2, Severity: executor.SeverityError, Message: "SyntaxError: '(' was never closed"},
+			},
+		},
+		{
+			fixture: "node.txt",
+			format:  language.DiagnosticsNode,
+			want: []executor.Diagnostic{
+				{File: "main.js", Line: 2, Column: 9, Severity: executor.SeverityError, Message: "Error: boom"},
+			},
+		},
+		{
+			fixture: "node_syntax.txt",
+			format:  language.DiagnosticsNode,
+			want: []executor.Diagnostic{
+				{File: "syntax.js", Line: 2, Column: 13, Severity: executor.SeverityError, Message: "SyntaxError: missing ) after argument list"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/diagnostics/" + tt.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := diagnosticParsers[tt.format](string(data))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse %s = %+v, want %+v", tt.fixture, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticParsersIgnoreProgramOutput(t *testing.T) {
+	output := "starting\nvalue:42\n  File \"notes.txt\", line 1\n"
+	for format, parse := range diagnosticParsers {
+		if got := parse(output); len(got) != 0 {
+			t.Errorf("%s parser = %+v, want no diagnostics", format, got)
+		}
+	}
+}
+
+func TestRunInteractiveSendsDiagnostics(t *testing.T) {
+	registry, err := language.NewRegistry([]language.Language{
+		{ID: "python", SourceFile: "main.py", Run: []string{"python3", "main.py"}, Diagnostics: language.DiagnosticsPython},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	runner := NewTestDockerRunner("tayebe/repl")
+	runner.languages = registry
+	runner.execCommand = mockCommand
+
+	input := make(chan executor.Input)
+	close(input)
+	output := make(chan executor.Output, 10)
+
+	result, err := runner.RunInteractive(context.Background(), executor.ExecRequest{Language: "python", Code: "TRACEBACK"}, input, output)
+	close(output)
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+
+	want := []executor.Diagnostic{{File: "main.py", Line: 1, Severity: executor.SeverityError, Message: "NameError: name 'x' is not defined"}}
+	var sent []executor.Diagnostic
+	for out := range output {
+		if out.Type == executor.OutputDiagnostics {
+			sent = out.Diagnostics
+		}
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("RunInteractive() sent diagnostics %+v, want %+v", sent, want)
+	}
+	if !reflect.DeepEqual(result.Diagnostics, want) {
+		t.Errorf("RunInteractive() result diagnostics = %+v, want %+v", result.Diagnostics, want)
+	}
+}
+
+func TestOutputStreamSplitsLongLines(t *testing.T) {
+	output := make(chan executor.Output, 300)
+	stream := newOutputStream(output, time.Millisecond, 1000)
+
+	line := strings.Repeat("x", 250*1000) + "\n"
+	var wg sync.WaitGroup
+	wg.Add(1)
+	stream.read(&wg, context.Background(), executor.OutputStdout, strings.NewReader(line))
+	close(output)
+
+	var got strings.Builder
+	for out := range output {
+		if len(out.Data) > 1000 {
+			t.Fatalf("chunk of %d bytes exceeds max chunk size", len(out.Data))
+		}
+		got.WriteString(out.Data)
+	}
+	if got.String() != line {
+		t.Errorf("stream delivered %d bytes, want %d", got.Len(), len(line))
+	}
+}
+
+func TestOutputBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     language.Limits
+		chunks     []string
+		wantTaken  []int
+		wantNotice string
+	}{
+		{
+			name:      "Unlimited",
+			chunks:    []string{"a\nb\n", strings.Repeat("x", 4096)},
+			wantTaken: []int{4, 4096},
+		},
+		{
+			name:       "Bytes",
+			limits:     language.Limits{OutputBytes: 10},
+			chunks:     []string{"12345", "678901234", "5"},
+			wantTaken:  []int{5, 5, 0},
+			wantNotice: "output truncated after 10 bytes",
+		},
+		{
+			name:       "Lines",
+			limits:     language.Limits{OutputLines: 2},
+			chunks:     []string{"a\nb", "\nc\n", "d"},
+			wantTaken:  []int{3, 1, 0},
+			wantNotice: "output truncated after 2 lines",
+		},
+		{
+			name:       "Exact Fit",
+			limits:     language.Limits{OutputBytes: 4, OutputLines: 2},
+			chunks:     []string{"a\nb\n", "c"},
+			wantTaken:  []int{4, 0},
+			wantNotice: "output truncated after 4 bytes",
+		},
+		{
+			name:       "Lines Before Bytes",
+			limits:     language.Limits{OutputBytes: 6, OutputLines: 1},
+			chunks:     []string{"a\nbcdefgh"},
+			wantTaken:  []int{2},
+			wantNotice: "output truncated after 1 lines",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := newOutputBudget(tt.limits)
+			var notices []string
+			for i, chunk := range tt.chunks {
+				n, notice := budget.take([]byte(chunk))
+				if n != tt.wantTaken[i] {
+					t.Errorf("take(%q) = %d, want %d", chunk, n, tt.wantTaken[i])
+				}
+				if notice != "" {
+					notices = append(notices, notice)
+				}
+			}
+			if tt.wantNotice == "" {
+				if len(notices) != 0 || budget.truncated() {
+					t.Errorf("notices = %q, want none", notices)
+				}
+			} else if !reflect.DeepEqual(notices, []string{tt.wantNotice}) || !budget.truncated() {
+				t.Errorf("notices = %q, want only %q", notices, tt.wantNotice)
+			}
+		})
+	}
+}
+
+func TestRunInteractiveReportsExitCode(t *testing.T) {
+	runner := NewTestDockerRunner("tayebe/repl")
+	runner.execCommand = mockCommand
+
+	input := make(chan executor.Input)
+	close(input)
+	output := make(chan executor.Output, 10)
+
+	result, err := runner.RunInteractive(context.Background(), executor.ExecRequest{Language: "python", Code: "EXIT_3"}, input, output)
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v, want exit status in result", err)
+	}
+	if result.ExitCode != 3 || result.Signal != "" {
+		t.Errorf("RunInteractive() result = %+v, want exit code 3", result)
+	}
+}
+
+func TestExitResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		inspect string
+		want    executor.ExecutionResult
+	}{
+		{name: "Clean Exit", inspect: "0 false", want: executor.ExecutionResult{ExitCode: 0}},
+		{name: "Error Exit", inspect: "1 false", want: executor.ExecutionResult{ExitCode: 1}},
+		{name: "Killed", inspect: "137 false", want: executor.ExecutionResult{ExitCode: 137, Signal: "SIGKILL"}},
+		{name: "Segfault", inspect: "139 false", want: executor.ExecutionResult{ExitCode: 139, Signal: "SIGSEGV"}},
+		{name: "OOM Killed", inspect: "137 true", want: executor.ExecutionResult{ExitCode: 137, Signal: "SIGKILL", OOMKilled: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewDockerRunner("tayebe/repl")
+			runner.command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+				return exec.Command("echo", tt.inspect)
+			}
+
+			got := runner.exitResult("code-exec-1", &exec.Cmd{})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("exitResult() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeVersions(t *testing.T) {
+	registry, err := language.NewRegistry([]language.Language{
+		{ID: "python", Run: []string{"python3"}, Version: []string{"python3", "--version"}},
+		{ID: "java", Run: []string{"java"}, Image: "example/java", Version: []string{"javac", "-version"}},
+		{ID: "broken", Run: []string{"broken"}, Version: []string{"broken"}},
+		{ID: "plain", Run: []string{"plain"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	runner := NewDockerRunner("tayebe/repl", WithRegistry(registry))
+	runner.command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		if args[len(args)-1] == "broken" {
+			return exec.Command("false")
+		}
+		// Print a blank line and the command as the version
+		return exec.Command("printf", "\n%s\nmore\n", strings.Join(args, " "))
+	}
+
+	versions, err := runner.ProbeVersions(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("ProbeVersions() error = %v, want the broken probe reported", err)
+	}
+	if len(versions) != 2 {
+		t.Errorf("ProbeVersions() = %v, want python and java", versions)
+	}
+	// The probes run confined and limited like programs
+	confined := strings.Join(append(runner.prepareBaseArgs("NAME", runner.limits.Run), runner.sandboxMount("")...), " ")
+	for id, command := range map[string]string{
+		"python": "tayebe/repl python3 --version",
+		"java":   "example/java javac -version",
+	} {
+		version := regexp.MustCompile(`code-version-`+id+`-\d+`).ReplaceAllString(versions[id], "NAME")
+		if want := confined + " " + command; version != want {
+			t.Errorf("ProbeVersions()[%q] = %q, want %q", id, version, want)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	registry, err := language.NewRegistry([]language.Language{
+		{ID: "python", SourceFile: "main.py", Run: []string{"python3", "main.py"}},
+		{ID: "c", SourceFile: "main.c", Compile: []string{"gcc", "main.c", "-o", "main"}, Run: []string{"./main"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	runner := NewTestDockerRunner("tayebe/repl")
+	runner.languages = registry
+	WithPool(config.Pool{Size: 1, RefillInterval: time.Millisecond, MaxIdle: time.Minute})(runner.DockerRunner)
+
+	var mu sync.Mutex
+	var commands []string
+	runner.execCommand = func(name string, args ...string) *exec.Cmd {
+		mu.Lock()
+		commands = append(commands, strings.Join(args, " "))
+		mu.Unlock()
+		return mockCommand(name, args...)
+	}
+	ran := func(pattern string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range commands {
+			if regexp.MustCompile(pattern).MatchString(c) {
+				return true
+			}
+		}
+		return false
+	}
+	run := func(req executor.ExecRequest) string {
+		t.Helper()
+		mu.Lock()
+		commands = nil
+		mu.Unlock()
+
+		input := make(chan executor.Input)
+		close(input)
+		output := make(chan executor.Output, 100)
+		if _, err := runner.RunInteractive(context.Background(), req, input, output); err != nil {
+			t.Fatalf("RunInteractive() error = %v", err)
+		}
+		close(output)
+		var stdout strings.Builder
+		for out := range output {
+			if out.Type == executor.OutputStdout {
+				stdout.WriteString(out.Data)
+			}
+		}
+		return stdout.String()
+	}
+
+	// One slot per language, then the pool is full
+	for i := 0; i < 3; i++ {
+		runner.refillPool(context.Background())
+	}
+	if stats := runner.PoolStats(); stats.Created != 2 || stats.Idle["python"] != 1 || stats.Idle["c"] != 1 {
+		t.Fatalf("PoolStats() = %+v, want one idle slot per language", stats)
+	}
+	for _, want := range []string{
+		`^run --name code-pool-python-\d+ -i .* -d --tmpfs /sandbox/tmp:\S+,size=64m tayebe/repl sleep infinity$`,
+		`^volume create --driver local --opt device=tmpfs --opt o=rw,exec,nosuid,nodev,mode=1777,size=64m --opt type=tmpfs code-pool-c-\d+-src$`,
+		`^run --name code-pool-c-\d+-compile -i --cpus=1 -m 512m .* -d -v code-pool-c-\d+-src:/sandbox/tmp tayebe/repl sleep infinity$`,
+		`^run --name code-pool-c-\d+ -i --cpus=0.5 -m 100m .* -d -v code-pool-c-\d+-src:/sandbox/tmp tayebe/repl sleep infinity$`,
+	} {
+		if !ran(want) {
+			t.Errorf("refillPool() ran %q, want a command matching %q", commands, want)
+		}
+	}
+
+	// A warm slot is used once and destroyed
+	stdout := run(executor.ExecRequest{Language: "python", Code: "print('hi')", Env: map[string]string{"MODE": "test"}})
+	if !strings.Contains(stdout, "main.py:") {
+		t.Errorf("RunInteractive() stdout = %q, want the staged file", stdout)
+	}
+	if !ran(`^exec -i -e MODE=test code-pool-python-\d+ bash -c cd /sandbox/tmp && head -c \d+ \| tar`) || !ran(`^rm -f code-pool-python-\d+$`) {
+		t.Errorf("RunInteractive() ran %q, want docker exec in the pooled container, then its removal", commands)
+	}
+
+	// The pool is empty until it is refilled
+	run(executor.ExecRequest{Language: "python", Code: "print('hi')"})
+	if !ran(`^run --name code-exec-\d+ `) {
+		t.Errorf("RunInteractive() ran %q, want a new container", commands)
+	}
+
+	// Compiled languages use both containers and the shared volume
+	run(executor.ExecRequest{Language: "c", Code: "int main() { return 0; }"})
+	for _, want := range []string{
+		`^exec -i code-pool-c-\d+-compile bash -c cd /sandbox/tmp && head -c \d+ \| tar -x -f - && 'gcc'`,
+		`^exec -i code-pool-c-\d+ bash -c cd /sandbox/tmp && exec './main'$`,
+		`^volume rm -f code-pool-c-\d+-src$`,
+	} {
+		if !ran(want) {
+			t.Errorf("RunInteractive() ran %q, want a command matching %q", commands, want)
+		}
+	}
+
+	// Requests with their own limits need a container started with them
+	runner.refillPool(context.Background())
+	run(executor.ExecRequest{Language: "python", Code: "print('hi')", Limits: language.Limits{Memory: "200m"}})
+	if !ran(`^run --name code-exec-\d+ .*-m 200m`) {
+		t.Errorf("RunInteractive() ran %q, want a new container with the request limits", commands)
+	}
+
+	stats := runner.PoolStats()
+	if !stats.Enabled || stats.Hits != 2 || stats.Misses != 2 || stats.Idle["python"] != 1 {
+		t.Errorf("PoolStats() = %+v, want 2 hits, 2 misses and the refilled slot idle", stats)
+	}
+}
+
+func TestPoolExpire(t *testing.T) {
+	now := time.Now()
+	p := &pool{Pool: config.Pool{Size: 3, MaxIdle: time.Minute}, idle: map[string][]slot{
+		"python": {
+			{run: "old", created: now.Add(-2 * time.Minute)},
+			{run: "new", created: now.Add(-time.Second)},
+		},
+	}}
+
+	expired := p.expire(now)
+	if len(expired) != 1 || expired[0].run != "old" {
+		t.Errorf("expire() = %+v, want the old slot", expired)
+	}
+	if stats := p.snapshot(); stats.Expired != 1 || stats.Idle["python"] != 1 {
+		t.Errorf("snapshot() = %+v, want one expired and one idle", stats)
+	}
+}
+
+func TestPoolDisabled(t *testing.T) {
+	runner := NewDockerRunner("tayebe/repl", WithPool(config.Pool{}))
+	if runner.pool != nil || runner.PoolStats().Enabled {
+		t.Error("WithPool() enabled a pool of size 0")
+	}
+	// Returns at once without touching docker
+	runner.command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		t.Errorf("MaintainPool() ran docker %v", args)
+		return exec.Command("true")
+	}
+	runner.MaintainPool(context.Background())
+}
+
+func TestSignalPooledProgram(t *testing.T) {
+	var commands []string
+	runner := NewDockerRunner("tayebe/repl")
+	runner.command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return exec.Command("true")
+	}
+
+	output := make(chan executor.Output, 1)
+	runner.signalProgram(context.Background(), phase{container: "code-pool-python-1", pooled: true}, "SIGTERM", output)
+	if want := []string{"docker exec code-pool-python-1 bash -c kill -s TERM -1"}; !reflect.DeepEqual(commands, want) {
+		t.Errorf("signalProgram() ran %v, want %v", commands, want)
+	}
+}
+
+func TestExecResult(t *testing.T) {
+	runner := NewDockerRunner("tayebe/repl")
+	runner.command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.Command("echo", "137 true")
+	}
+
+	// The CLI was killed, so the container state is used
+	got := runner.execResult("code-pool-python-1", -1)
+	want := executor.ExecutionResult{ExitCode: 137, Signal: "SIGKILL", OOMKilled: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("execResult() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseContainerState(t *testing.T) {
+	if _, err := parseContainerState("not a state"); err == nil {
+		t.Error("parseContainerState() expected error for malformed state")
+	}
+	if _, err := parseContainerState("x true"); err == nil {
+		t.Error("parseContainerState() expected error for non-numeric exit code")
+	}
+	state, err := parseContainerState("137 true\n")
+	if err != nil || state != (containerState{ExitCode: 137, OOMKilled: true}) {
+		t.Errorf("parseContainerState() = %+v, %v", state, err)
+	}
+}
+
+// recordingStdin captures what handleInput writes to the program
+type recordingStdin struct {
+	strings.Builder
+	closed bool
+}
+
+func (r *recordingStdin) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestHandleInput(t *testing.T) {
+	var commands []string
+	runner := NewDockerRunner("tayebe/repl")
+	runner.command = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return exec.Command("true")
+	}
+
+	input := make(chan executor.Input, 10)
+	input <- executor.Input{Type: executor.InputStdin, Data: "first\n"}
+	input <- executor.Input{Type: executor.InputResize, Cols: 80, Rows: 24}
+	input <- executor.Input{Type: executor.InputSignal, Data: "sigint"}
+	input <- executor.Input{Type: executor.InputSignal, Data: "STOP"}
+	input <- executor.Input{Type: executor.InputEOF}
+	input <- executor.Input{Type: executor.InputStdin, Data: "ignored\n"}
+	close(input)
+	output := make(chan executor.Output, 10)
+
+	stdin := &recordingStdin{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	runner.handleInput(&wg, context.Background(), nil, phase{container: "code-exec-1"}, stdin, input, output)
+	close(output)
+
+	if stdin.String() != "first\n" {
+		t.Errorf("handleInput() wrote %q, want %q", stdin.String(), "first\n")
+	}
+	if !stdin.closed {
+		t.Error("handleInput() did not close stdin on EOF")
+	}
+	if want := []string{"docker kill --signal=INT code-exec-1"}; !reflect.DeepEqual(commands, want) {
+		t.Errorf("handleInput() ran %v, want %v", commands, want)
+	}
+
+	var statuses []string
+	for out := range output {
+		statuses = append(statuses, out.Data)
+	}
+	if len(statuses) != 1 || !strings.Contains(statuses[0], "Unsupported signal: STOP") {
+		t.Errorf("handleInput() status = %v, want unsupported signal", statuses)
+	}
+}
+
+func TestNewDockerRunnerLimits(t *testing.T) {
+	limits := config.Default().Limits
+	limits.Run.Pids = 30
+	limits.Ulimits = config.Ulimits{Nproc: 10, Nofile: 32, Fsize: 2048}
+	runner := NewDockerRunner("tayebe/repl", WithLimits(limits))
+
+	expectedOpts := []string{
+		"--cap-drop=ALL",
+		"--net=none",
+		"--pids-limit=30",
+		"--ulimit", "nproc=10:10",
+		"--ulimit", "nofile=32:32",
+		"--ulimit", "fsize=2048:2048",
+	}
+	if !reflect.DeepEqual(runner.securityOpts, expectedOpts) {
+		t.Errorf("NewDockerRunner() securityOpts = %v, want %v", runner.securityOpts, expectedOpts)
+	}
+}
+
+func TestNewDockerRunner(t *testing.T) {
+	imageName := "tayebe/repl"
+	runner := NewDockerRunner(imageName)
+
+	if runner.imageName != imageName {
+		t.Errorf("NewDockerRunner() imageName = %v, want %v", runner.imageName, imageName)
+	}
+
+	expectedOpts := []string{
+		"--cap-drop=ALL",
+		"--net=none",
+		"--pids-limit=20",
+		"--ulimit", "nproc=20:20",
+		"--ulimit", "nofile=64:64",
+		"--ulimit", "fsize=1000000:1000000",
+	}
+
+	if !reflect.DeepEqual(runner.securityOpts, expectedOpts) {
+		t.Errorf("NewDockerRunner() securityOpts = %v, want %v", runner.securityOpts, expectedOpts)
+	}
+}
+
+func TestNewDockerRunnerHardening(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "profile.json")
+	hardening := config.Hardening{
+		ReadOnly:        true,
+		TmpfsSize:       "32m",
+		NoNewPrivileges: true,
+		Seccomp:         profile,
+		User:            "1000:1000",
+		Runtime:         "runsc",
+	}
+	runner := NewDockerRunner("tayebe/repl", WithHardening(hardening))
+
+	expectedOpts := []string{
+		"--cap-drop=ALL",
+		"--net=none",
+		"--pids-limit=20",
+		"--ulimit", "nproc=20:20",
+		"--ulimit", "nofile=64:64",
+		"--ulimit", "fsize=1000000:1000000",
+		"--runtime=runsc",
+		"--read-only",
+		"--tmpfs", "/tmp:rw,exec,nosuid,nodev,mode=1777,size=32m",
+		"--security-opt", "no-new-privileges",
+		"--security-opt", "seccomp=" + profile,
+		"--user", "1000:1000",
+	}
+	if !reflect.DeepEqual(runner.securityOpts, expectedOpts) {
+		t.Errorf("NewDockerRunner() securityOpts = %v, want %v", runner.securityOpts, expectedOpts)
+	}
+}
+
+func TestNewDockerRunnerBuiltinSeccomp(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	runner := NewDockerRunner("tayebe/repl", WithHardening(config.Hardening{Seccomp: config.SeccompBuiltin}))
+
+	opts := runner.securityOpts[len(runner.securityOpts)-2:]
+	path, ok := strings.CutPrefix(opts[1], "seccomp=")
+	if opts[0] != "--security-opt" || !ok {
+		t.Fatalf("NewDockerRunner() securityOpts = %v, want the seccomp profile last", runner.securityOpts)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading seccomp profile: %v", err)
+	}
+	if !bytes.Equal(written, builtinSeccompProfile) {
+		t.Error("the seccomp profile written is not the builtin profile")
+	}
+
+	// Later runners reuse the file rather than writing one each
+	again := NewDockerRunner("tayebe/repl", WithHardening(config.Hardening{Seccomp: config.SeccompBuiltin}))
+	if again.seccomp != path {
+		t.Errorf("second runner seccomp = %s, want %s", again.seccomp, path)
+	}
+	if files, _ := os.ReadDir(filepath.Dir(path)); len(files) != 1 || !strings.HasPrefix(path, cache) {
+		t.Errorf("seccomp profiles written = %v, want %s alone under the cache directory", files, path)
+	}
+
+	runner = NewDockerRunner("tayebe/repl", WithHardening(config.Hardening{Seccomp: config.SeccompUnconfined}))
+	if last := runner.securityOpts[len(runner.securityOpts)-1]; last != "seccomp=unconfined" {
+		t.Errorf("NewDockerRunner() securityOpts = %v, want seccomp=unconfined", runner.securityOpts)
+	}
+}
+
+func TestPreparePlanReadOnly(t *testing.T) {
+	runner := NewTestDockerRunner("tayebe/repl", WithHardening(config.Hardening{ReadOnly: true, TmpfsSize: "16m"}))
+	tmpfs := []string{"--tmpfs", "/sandbox/tmp:rw,exec,nosuid,nodev,mode=1777,size=16m"}
+
+	plan, err := runner.preparePlan("test-container", executor.ExecRequest{Language: "python3", Code: "print(1)"})
+	if err != nil {
+		t.Fatalf("preparePlan() error = %v", err)
+	}
+	if !containsSequence(plan.run.args, tmpfs) {
+		t.Errorf("preparePlan() run args = %v, want the sandbox directory on a tmpfs", plan.run.args)
+	}
+
+	// The phases of a compiled language share their volume instead
+	plan, err = runner.preparePlan("test-container", executor.ExecRequest{Language: "c", Code: "int main() {}"})
+	if err != nil {
+		t.Fatalf("preparePlan() error = %v", err)
+	}
+	for _, args := range [][]string{plan.compile.args, plan.run.args} {
+		if containsSequence(args, tmpfs) || !containsSequence(args, []string{"-v", "test-container-src:/sandbox/tmp"}) {
+			t.Errorf("preparePlan() args = %v, want the volume mounted", args)
+		}
+	}
+}
+
+// containsSequence reports whether args contains want as consecutive arguments
+func containsSequence(args, want []string) bool {
+	for i := 0; i+len(want) <= len(args); i++ {
+		if reflect.DeepEqual(args[i:i+len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPreparePlanSeccompError(t *testing.T) {
+	// A file where the cache directory should be
+	cache := filepath.Join(t.TempDir(), "cache")
+	os.WriteFile(cache, nil, 0o644)
+	t.Setenv("XDG_CACHE_HOME", cache)
+	runner := NewTestDockerRunner("tayebe/repl", WithHardening(config.Hardening{Seccomp: config.SeccompBuiltin}))
+
+	_, err := runner.preparePlan("test-container", executor.ExecRequest{Language: "python3", Code: "print(1)"})
+	if err == nil || !strings.Contains(err.Error(), "seccomp profile") {
+		t.Errorf("preparePlan() error = %v, want the seccomp profile error", err)
+	}
+}
+
+func TestEngineContainerConfigHardening(t *testing.T) {
+	hardening := config.Hardening{
+		ReadOnly:        true,
+		TmpfsSize:       "32m",
+		NoNewPrivileges: true,
+		Seccomp:         config.SeccompBuiltin,
+		User:            "1000:1000",
+		Runtime:         "runsc",
+	}
+	runner, _ := newEngineRunner(t, WithHardening(hardening))
+
+	cfg := runner.containerConfig(containerSpec{image: "tayebe/repl", command: []string{"true"}})
+	host := cfg.HostConfig
+	if cfg.User != "1000:1000" || host.Runtime != "runsc" || !host.ReadonlyRootfs {
+		t.Errorf("containerConfig() = %+v, want the user, runtime and read-only rootfs", cfg)
+	}
+	wantTmpfs := map[string]string{
+		"/tmp":         "rw,exec,nosuid,nodev,mode=1777,size=32m",
+		"/sandbox/tmp": "rw,exec,nosuid,nodev,mode=1777,size=32m",
+	}
+	if !reflect.DeepEqual(host.Tmpfs, wantTmpfs) {
+		t.Errorf("containerConfig() Tmpfs = %v, want %v", host.Tmpfs, wantTmpfs)
+	}
+	if len(host.SecurityOpt) != 2 || host.SecurityOpt[0] != "no-new-privileges" {
+		t.Fatalf("containerConfig() SecurityOpt = %v, want no-new-privileges and seccomp", host.SecurityOpt)
+	}
+	// The Engine API takes the profile itself
+	profile, ok := strings.CutPrefix(host.SecurityOpt[1], "seccomp=")
+	if !ok || !json.Valid([]byte(profile)) || strings.Contains(profile, "\n") {
+		t.Errorf("containerConfig() SecurityOpt = %.80s..., want the compacted profile", host.SecurityOpt[1])
+	}
+
+	cfg = runner.containerConfig(containerSpec{image: "tayebe/repl", volume: "code-exec-1-src"})
+	if _, ok := cfg.HostConfig.Tmpfs["/sandbox/tmp"]; ok {
+		t.Errorf("containerConfig() Tmpfs = %v, want the volume in the sandbox directory", cfg.HostConfig.Tmpfs)
+	}
+
+	// Without hardening the sandbox directory is still a bounded tmpfs
+	runner, _ = newEngineRunner(t)
+	cfg = runner.containerConfig(containerSpec{image: "tayebe/repl", command: []string{"true"}})
+	if want := map[string]string{"/sandbox/tmp": "rw,exec,nosuid,nodev,mode=1777,size=64m"}; !reflect.DeepEqual(cfg.HostConfig.Tmpfs, want) {
+		t.Errorf("containerConfig() Tmpfs = %v, want %v", cfg.HostConfig.Tmpfs, want)
+	}
+}
+
+func TestBuiltinSeccompProfile(t *testing.T) {
+	var profile struct {
+		DefaultAction string `json:"defaultAction"`
+		Syscalls      []struct {
+			Names  []string `json:"names"`
+			Action string   `json:"action"`
+		} `json:"syscalls"`
+	}
+	if err := json.Unmarshal(builtinSeccompProfile, &profile); err != nil {
+		t.Fatalf("parsing the builtin seccomp profile: %v", err)
+	}
+	if profile.DefaultAction != "SCMP_ACT_ERRNO" {
+		t.Errorf("defaultAction = %s, want SCMP_ACT_ERRNO", profile.DefaultAction)
+	}
+
+	allowed := make(map[string]bool)
+	for _, rule := range profile.Syscalls {
+		for _, name := range rule.Names {
+			if rule.Action == "SCMP_ACT_ALLOW" {
+				allowed[name] = true
+			}
+		}
+	}
+	for _, name := range []string{"read", "write", "execve", "clone", "mmap", "exit_group"} {
+		if !allowed[name] {
+			t.Errorf("%s is not allowed", name)
+		}
+	}
+	for _, name := range []string{"ptrace", "mount", "umount2", "unshare", "setns", "bpf", "keyctl", "kexec_load", "init_module", "perf_event_open", "clone3"} {
+		if allowed[name] {
+			t.Errorf("%s is allowed", name)
+		}
+	}
+}
+
+// engineProgram mimics programs on the fake Engine, like the helper
+// process does for the docker CLI
+func engineProgram(ctx context.Context, cmd []string, stdin io.Reader, stdout, stderr io.Writer) engine.State {
+	program := strings.Join(cmd, " ")
+	if m := stagePattern.FindStringSubmatch(program); m != nil {
+		for _, f := range readStagedFiles(stdin, m[1]) {
+			program += " " + f.content
+		}
+	}
+
+	switch {
+	case strings.Contains(program, "PRINT_FOREVER"):
+		for ctx.Err() == nil {
+			fmt.Fprintln(stdout, "x")
+		}
+		return engine.State{ExitCode: 137}
+	case cmd[0] == "sleep", strings.Contains(program, "while True: pass"):
+		<-ctx.Done()
+		return engine.State{ExitCode: 137}
+	case strings.Contains(program, "--version"):
+		fmt.Fprintln(stdout, "Python 3.12.1")
+		return engine.State{}
+	case strings.Contains(program, "OOM"):
+		return engine.State{ExitCode: 137, OOMKilled: true}
+	case strings.Contains(program, "EXIT_3"):
+		fmt.Fprintln(stdout, "failing")
+		return engine.State{ExitCode: 3}
+	case strings.Contains(program, "READ_LINE"):
+		line, _ := bufio.NewReader(stdin).ReadString('\n')
+		fmt.Fprint(stdout, "got "+line)
+		fmt.Fprintln(stderr, "warning")
+	}
+	fmt.Fprintln(stdout, "Container output")
+	return engine.State{}
+}
+
+// newEngineRunner creates a runner driving a fake Engine
+func newEngineRunner(t *testing.T, opts ...Option) (*DockerRunner, *enginetest.Server) {
+	t.Helper()
+	server := enginetest.NewServer(t)
+	server.Program = engineProgram
+	client, err := engine.NewClient(server.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDockerRunner("tayebe/repl", append(opts, WithEngine(client))...), server
+}
+
+// runEngine runs a request, writing stdin before closing the input, and
+// returns the result, the error and the output by type
+func runEngine(runner executor.CodeRunner, req executor.ExecRequest, stdin string) (executor.ExecutionResult, error, map[executor.OutputType]string) {
+	input := make(chan executor.Input, 1)
+	if stdin != "" {
+		input <- executor.Input{Type: executor.InputStdin, Data: stdin}
+	}
+	close(input)
+	output := make(chan executor.Output, 100)
+
+	result, err := runner.RunInteractive(context.Background(), req, input, output)
+	close(output)
+	outputs := make(map[executor.OutputType]string)
+	for out := range output {
+		outputs[out.Type] += out.Data
+	}
+	return result, err, outputs
+}
+
+func TestEngineRunInteractive(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        executor.ExecRequest
+		stdin      string
+		wantStdout string
+		wantStderr string
+		want       executor.ExecutionResult
+		wantErr    error
+	}{
+		{
+			name:       "Reads Input",
+			req:        executor.ExecRequest{Language: "python", Code: "READ_LINE"},
+			stdin:      "hi\n",
+			wantStdout: "got hi\nContainer output\n",
+			wantStderr: "warning\n",
+		},
+		{
+			name:       "Exit Code",
+			req:        executor.ExecRequest{Language: "python", Code: "EXIT_3"},
+			wantStdout: "failing\n",
+			want:       executor.ExecutionResult{ExitCode: 3},
+		},
+		{
+			name: "OOM Killed",
+			req:  executor.ExecRequest{Language: "python", Code: "OOM"},
+			want: executor.ExecutionResult{ExitCode: 137, Signal: "SIGKILL", OOMKilled: true},
+		},
+		{
+			name:    "Timeout",
+			req:     executor.ExecRequest{Language: "python", Code: "while True: pass", Limits: language.Limits{Timeout: 100 * time.Millisecond}},
+			want:    executor.ExecutionResult{ExitCode: 137, Signal: "SIGKILL", TimedOut: true},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, server := newEngineRunner(t)
+
+			result, err, outputs := runEngine(runner, tt.req, tt.stdin)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RunInteractive() error = %v, want %v", err, tt.wantErr)
+			}
+			result.Usage = nil
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("RunInteractive() result = %+v, want %+v", result, tt.want)
+			}
+			if outputs[executor.OutputStdout] != tt.wantStdout || outputs[executor.OutputStderr] != tt.wantStderr {
+				t.Errorf("RunInteractive() output = %q, %q, want %q, %q", outputs[executor.OutputStdout], outputs[executor.OutputStderr], tt.wantStdout, tt.wantStderr)
+			}
+			if left := server.Containers(); len(left) != 0 {
+				t.Errorf("containers left after run: %v", left)
+			}
+		})
+	}
+}
+
+func TestEngineResourceUsage(t *testing.T) {
+	runner, server := newEngineRunner(t)
+	server.Stats = engine.Stats{MemoryUsage: 3 << 20, MemoryMaxUsage: 5 << 20, CPUUsage: 40e6}
+
+	req := executor.ExecRequest{Language: "python", Code: "while True: pass", Limits: language.Limits{Timeout: 150 * time.Millisecond}}
+	result, _, _ := runEngine(runner, req, "")
+	want := &executor.ResourceUsage{MemoryPeakBytes: 5 << 20, CPUTimeMs: 40}
+	if !reflect.DeepEqual(result.Usage, want) {
+		t.Errorf("RunInteractive() usage = %+v, want %+v", result.Usage, want)
+	}
+}
+
+func TestEngineContainerConfig(t *testing.T) {
+	runner := NewDockerRunner("tayebe/repl")
+	spec := containerSpec{
+		image:   "tayebe/repl",
+		command: []string{"bash", "-c", "cd /sandbox/tmp && exec ./main"},
+		env:     []string{"MODE=test"},
+		limits:  language.Limits{CPUs: "0.5", Memory: "100m", Pids: 20},
+		volume:  "code-exec-1-src",
+	}
+
+	pids := int64(20)
+	want := engine.ContainerConfig{
+		Image:        "tayebe/repl",
+		Cmd:          spec.command,
+		Env:          spec.env,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		OpenStdin:    true,
+		StdinOnce:    true,
+		HostConfig: engine.HostConfig{
+			NanoCPUs:    5e8,
+			Memory:      100 << 20,
+			PidsLimit:   &pids,
+			NetworkMode: "none",
+			CapDrop:     []string{"ALL"},
+			Ulimits: []engine.Ulimit{
+				{Name: "nproc", Soft: 20, Hard: 20},
+				{Name: "nofile", Soft: 64, Hard: 64},
+				{Name: "fsize", Soft: 1000000, Hard: 1000000},
+			},
+			Binds: []string{"code-exec-1-src:/sandbox/tmp"},
+		},
+	}
+	if got := runner.containerConfig(spec); !reflect.DeepEqual(got, want) {
+		t.Errorf("containerConfig() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEngineCompilePhases(t *testing.T) {
+	registry, err := language.NewRegistry([]language.Language{
+		{ID: "c", SourceFile: "main.c", Compile: []string{"gcc", "main.c", "-o", "main"}, Run: []string{"./main"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	runner, server := newEngineRunner(t, WithRegistry(registry), WithHardening(config.Hardening{TmpfsSize: "16m"}))
+
+	result, err, outputs := runEngine(runner, executor.ExecRequest{Language: "c", Code: "int main() { return 0; }"}, "")
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if result.Compile == nil || result.Compile.Output != "Container output\n" || result.Compile.Failed() {
+		t.Errorf("RunInteractive() compile = %+v", result.Compile)
+	}
+	if outputs[executor.OutputPhase] != executor.PhaseCompiling+executor.PhaseRunning {
+		t.Errorf("RunInteractive() phases = %q", outputs[executor.OutputPhase])
+	}
+	if outputs[executor.OutputStdout] != "Container output\n" {
+		t.Errorf("RunInteractive() stdout = %q", outputs[executor.OutputStdout])
+	}
+	if left := append(server.Containers(), server.Volumes()...); len(left) != 0 {
+		t.Errorf("containers and volumes left after run: %v", left)
+	}
+
+	// The shared volume is a tmpfs of the hardening's size
+	if err := runner.backend.createVolume(context.Background(), "code-exec-1-src"); err != nil {
+		t.Fatalf("createVolume() error = %v", err)
+	}
+	want := map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "rw,exec,nosuid,nodev,mode=1777,size=16m"}
+	if v, _ := server.Volume("code-exec-1-src"); v.Driver != "local" || !reflect.DeepEqual(v.DriverOpts, want) {
+		t.Errorf("createVolume() created %+v, want a local tmpfs volume", v)
+	}
+	runner.backend.removeVolume("code-exec-1-src")
+
+	result, _, _ = runEngine(runner, executor.ExecRequest{Language: "c", Code: "EXIT_3"}, "")
+	if result.Compile == nil || !result.Compile.Failed() || result.ExitCode != 3 {
+		t.Errorf("RunInteractive() with compile error = %+v, compile %+v", result, result.Compile)
+	}
+}
+
+func TestEnginePool(t *testing.T) {
+	runner, server := newEngineRunner(t, WithPool(config.Pool{Size: 1, RefillInterval: time.Millisecond, MaxIdle: time.Minute}))
+
+	runner.refillPool(context.Background())
+	if idle := runner.PoolStats().Idle; idle["python"] != 1 {
+		t.Fatalf("PoolStats().Idle = %v, want a python slot", idle)
+	}
+
+	result, err, outputs := runEngine(runner, executor.ExecRequest{Language: "python", Code: "EXIT_3"}, "")
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if result.ExitCode != 3 || outputs[executor.OutputStdout] != "failing\n" {
+		t.Errorf("RunInteractive() = %+v, stdout %q, want exit code 3", result, outputs[executor.OutputStdout])
+	}
+
+	var execs int
+	for _, req := range server.Requests() {
+		if regexp.MustCompile(`^POST /containers/code-pool-python-\d+/exec$`).MatchString(req) {
+			execs++
+		}
+	}
+	if execs != 1 {
+		t.Errorf("requests = %v, want one exec in the pooled container", server.Requests())
+	}
+	if left := server.Containers(); len(left) != 0 {
+		t.Errorf("containers left after run: %v", left)
+	}
+	if stats := runner.PoolStats(); stats.Hits != 1 {
+		t.Errorf("PoolStats() = %+v, want one hit", stats)
+	}
+}
+
+func TestEngineOutputLimit(t *testing.T) {
+	limits := config.Default().Limits
+	limits.Run.OutputBytes = 1000
+	runner, server := newEngineRunner(t, WithLimits(limits))
+
+	input := make(chan executor.Input)
+	close(input)
+	output := make(chan executor.Output, 100)
+	start := time.Now()
+	result, err := runner.RunInteractive(context.Background(), executor.ExecRequest{Language: "python", Code: "PRINT_FOREVER"}, input, output)
+	close(output)
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > limits.Run.Timeout/2 {
+		t.Errorf("RunInteractive() took %v, want the program killed at its output limit", elapsed)
+	}
+
+	var stdout strings.Builder
+	var statuses []string
+	for out := range output {
+		switch out.Type {
+		case executor.OutputStdout:
+			stdout.WriteString(out.Data)
+		case executor.OutputStatus:
+			statuses = append(statuses, out.Data)
+		}
+	}
+	if stdout.String() != strings.Repeat("x\n", 500) {
+		t.Errorf("RunInteractive() stdout = %d bytes, want the first 1000", stdout.Len())
+	}
+	if !reflect.DeepEqual(statuses, []string{"output truncated after 1000 bytes"}) {
+		t.Errorf("RunInteractive() statuses = %q, want a single truncation notice", statuses)
+	}
+	if !result.OutputTruncated || result.ExitCode != 137 {
+		t.Errorf("RunInteractive() result = %+v, want the program killed with its output truncated", result)
+	}
+	if left := server.Containers(); len(left) != 0 {
+		t.Errorf("containers left = %v", left)
+	}
+}
+
+// TestEngineClientGone stops reading output mid-run, as the WebSocket
+// handler does when its client disconnects, then cancels the run
+func TestEngineClientGone(t *testing.T) {
+	// Without an output budget the program only stops when cancelled
+	limits := config.Default().Limits
+	limits.Run.OutputBytes, limits.Run.OutputLines = 0, 0
+	runner, server := newEngineRunner(t, WithLimits(limits))
+
+	input := make(chan executor.Input)
+	output := make(chan executor.Output, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := runner.RunInteractive(ctx, executor.ExecRequest{Language: "python", Code: "PRINT_FOREVER"}, input, output)
+		done <- err
+	}()
+
+	// The program fills the channel and blocks on it
+	for len(output) < cap(output) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("RunInteractive() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("RunInteractive() blocked on output nobody reads")
+	}
+	if left := append(server.Containers(), server.Volumes()...); len(left) != 0 {
+		t.Errorf("containers and volumes left after run: %v", left)
+	}
+}
+
+func TestEngineSignalProgram(t *testing.T) {
+	runner, server := newEngineRunner(t)
+	client, _ := engine.NewClient(server.Host)
+	ctx := context.Background()
+	for _, name := range []string{"code-exec-1", "code-pool-python-1"} {
+		client.CreateContainer(ctx, name, engine.ContainerConfig{Cmd: []string{"sleep", "infinity"}})
+		client.StartContainer(ctx, name)
+	}
+
+	output := make(chan executor.Output, 1)
+	runner.signalProgram(context.Background(), phase{container: "code-exec-1"}, "SIGINT", output)
+	if c, _ := server.Container("code-exec-1"); !reflect.DeepEqual(c.Signals, []string{"SIGINT"}) {
+		t.Errorf("signals = %v, want SIGINT", c.Signals)
+	}
+
+	runner.signalProgram(context.Background(), phase{container: "code-pool-python-1", pooled: true}, "SIGTERM", output)
+	if c, _ := server.Container("code-pool-python-1"); len(c.Signals) != 0 {
+		t.Errorf("signals = %v, want the pooled container kept alive", c.Signals)
+	}
+	if len(output) != 0 {
+		t.Errorf("signalProgram() reported %q", (<-output).Data)
+	}
+}
+
+func TestEngineProbeVersions(t *testing.T) {
+	registry, err := language.NewRegistry([]language.Language{
+		{ID: "python", Run: []string{"python3"}, Version: []string{"python3", "--version"}},
+		{ID: "broken", Run: []string{"broken"}, Version: []string{"broken", "EXIT_3"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	runner, server := newEngineRunner(t, WithRegistry(registry))
+
+	versions, err := runner.ProbeVersions(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("ProbeVersions() error = %v, want the broken probe reported", err)
+	}
+	if want := map[string]string{"python": "Python 3.12.1"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("ProbeVersions() = %v, want %v", versions, want)
+	}
+	if left := server.Containers(); len(left) != 0 {
+		t.Errorf("containers left after probe: %v", left)
 	}
 }
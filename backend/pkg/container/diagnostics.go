@@ -0,0 +1,223 @@
+// compiler and interpreter diagnostics
+package container
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// maxDiagnostics bounds how many diagnostics are reported for one phase
+const maxDiagnostics = 100
+
+// diagnosticParser extracts diagnostics from the output of a tool
+type diagnosticParser func(output string) []executor.Diagnostic
+
+// diagnosticParsers are indexed by the format named in a language definition
+var diagnosticParsers = map[string]diagnosticParser{
+	language.DiagnosticsGCC:    parseGCC,
+	language.DiagnosticsJavac:  parseJavac,
+	language.DiagnosticsPython: parsePython,
+	language.DiagnosticsNode:   parseNode,
+}
+
+var (
+	// gccPattern matches "file:line:col: severity: message"; the column is
+	// missing for some messages
+	gccPattern = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*)$`)
+
+	// linkerPattern matches "file:(.section+0x1f): message"
+	linkerPattern = regexp.MustCompile(`^([^:\s]+):\(\.[^)]*\): (.*)$`)
+
+	// javacPattern matches "File.java:line: severity: message"
+	javacPattern = regexp.MustCompile(`^(.+\.java):(\d+): (error|warning): (.*)$`)
+
+	// pythonFramePattern matches a traceback frame or the location of a
+	// syntax error
+	pythonFramePattern = regexp.MustCompile(`^  File "(.+)", line (\d+)`)
+
+	// nodeHeaderPattern matches the "file:line" that starts an uncaught error
+	nodeHeaderPattern = regexp.MustCompile(`^(.+):(\d+)$`)
+
+	// nodeFramePattern matches "at fn (file:line:col)" and "at file:line:col"
+	nodeFramePattern = regexp.MustCompile(`^\s+at (?:.* \()?(.+?):(\d+):(\d+)\)?$`)
+)
+
+// parseGCC reads gcc and g++ output, including linker errors
+func parseGCC(output string) []executor.Diagnostic {
+	var diags []executor.Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		if m := gccPattern.FindStringSubmatch(line); m != nil {
+			severity := m[4]
+			if severity == "fatal error" {
+				severity = executor.SeverityError
+			}
+			diags = append(diags, executor.Diagnostic{
+				File:     sourcePath(m[1]),
+				Line:     atoi(m[2]),
+				Column:   atoi(m[3]),
+				Severity: severity,
+				Message:  m[5],
+			})
+		} else if m := linkerPattern.FindStringSubmatch(line); m != nil {
+			diags = append(diags, executor.Diagnostic{
+				File:     sourcePath(m[1]),
+				Severity: executor.SeverityError,
+				Message:  m[2],
+			})
+		}
+	}
+	return limitDiagnostics(diags)
+}
+
+// parseJavac reads javac output. Each message is followed by the source
+// line, a caret under the column and optional detail lines.
+func parseJavac(output string) []executor.Diagnostic {
+	var diags []executor.Diagnostic
+	var current *executor.Diagnostic
+	sawCaret := false
+
+	for _, line := range strings.Split(output, "\n") {
+		if m := javacPattern.FindStringSubmatch(line); m != nil {
+			diags = append(diags, executor.Diagnostic{
+				File:     sourcePath(m[1]),
+				Line:     atoi(m[2]),
+				Severity: m[3],
+				Message:  m[4],
+			})
+			current = &diags[len(diags)-1]
+			sawCaret = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch trimmed := strings.TrimSpace(line); {
+		case !sawCaret && trimmed == "^":
+			current.Column = strings.Index(line, "^") + 1
+			sawCaret = true
+		case sawCaret && strings.HasPrefix(line, "  ") && trimmed != "":
+			// Details such as "symbol:" and "location:"
+			current.Message += "\n" + trimmed
+		case sawCaret:
+			current = nil
+		}
+	}
+	return limitDiagnostics(diags)
+}
+
+// parsePython reads tracebacks and syntax errors. The diagnostic points at
+// the innermost frame in the program's own files.
+func parsePython(output string) []executor.Diagnostic {
+	var diags []executor.Diagnostic
+	var location *executor.Diagnostic
+
+	for _, line := range strings.Split(output, "\n") {
+		if m := pythonFramePattern.FindStringSubmatch(line); m != nil {
+			if userFile(m[1]) || location == nil {
+				location = &executor.Diagnostic{File: sourcePath(m[1]), Line: atoi(m[2])}
+			}
+			continue
+		}
+		if location == nil || line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "Traceback ") {
+			continue
+		}
+
+		// The first unindented line after the frames names the exception
+		location.Severity = executor.SeverityError
+		location.Message = line
+		diags = append(diags, *location)
+		location = nil
+	}
+	return limitDiagnostics(diags)
+}
+
+// parseNode reads uncaught errors: a "file:line" header, the source line,
+// a caret, the error message and the stack. The diagnostic points at the
+// first stack frame in the program's own files, or at the header.
+func parseNode(output string) []executor.Diagnostic {
+	lines := strings.Split(output, "\n")
+	var diags []executor.Diagnostic
+
+	for i := 0; i < len(lines); i++ {
+		m := nodeHeaderPattern.FindStringSubmatch(lines[i])
+		if m == nil || !userFile(m[1]) || i+2 >= len(lines) {
+			continue
+		}
+		caret := strings.Index(lines[i+2], "^")
+		if caret < 0 {
+			continue
+		}
+		diag := executor.Diagnostic{
+			File:     sourcePath(m[1]),
+			Line:     atoi(m[2]),
+			Column:   caret + 1,
+			Severity: executor.SeverityError,
+		}
+
+		// The message is the first line after the blank one below the caret
+		j := i + 3
+		for j < len(lines) && strings.TrimSpace(lines[j]) == "" {
+			j++
+		}
+		if j == len(lines) {
+			break
+		}
+		diag.Message = lines[j]
+
+		for j++; j < len(lines); j++ {
+			frame := nodeFramePattern.FindStringSubmatch(lines[j])
+			if frame == nil {
+				break
+			}
+			if userFile(frame[1]) {
+				diag.File, diag.Line, diag.Column = sourcePath(frame[1]), atoi(frame[2]), atoi(frame[3])
+				break
+			}
+		}
+		diags = append(diags, diag)
+		i = j
+	}
+	return limitDiagnostics(diags)
+}
+
+// userFile reports whether the path is one of the staged source files
+func userFile(p string) bool {
+	if strings.HasPrefix(p, "node:") || strings.HasPrefix(p, "<") {
+		return false
+	}
+	return !path.IsAbs(p) || strings.HasPrefix(p, sandboxDir+"/")
+}
+
+// sourcePath makes paths inside the sandbox relative to it
+func sourcePath(p string) string {
+	return strings.TrimPrefix(p, sandboxDir+"/")
+}
+
+// atoi parses a number matched by a pattern, 0 if the group was empty
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func limitDiagnostics(diags []executor.Diagnostic) []executor.Diagnostic {
+	if len(diags) > maxDiagnostics {
+		return diags[:maxDiagnostics]
+	}
+	return diags
+}
+
+// sendDiagnostics reports diagnostics in a message of their own and
+// returns them
+func sendDiagnostics(ctx context.Context, output chan<- executor.Output, diags []executor.Diagnostic) []executor.Diagnostic {
+	if len(diags) > 0 {
+		send(ctx, output, executor.Output{Type: executor.OutputDiagnostics, Diagnostics: diags})
+	}
+	return diags
+}
@@ -0,0 +1,356 @@
+// Engine API backend
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/engine"
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// statsInterval is how often the resource usage of a running program is
+// sampled
+const statsInterval = 100 * time.Millisecond
+
+// execPollInterval and execPollAttempts bound how long an exec instance
+// may still be reported running once its output has ended
+const (
+	execPollInterval = 10 * time.Millisecond
+	execPollAttempts = 100
+)
+
+// WithEngine drives containers through the Engine API instead of the
+// docker CLI, which reports exit codes, OOM kills and resource usage
+// without parsing CLI output
+func WithEngine(client *engine.Client) Option {
+	return func(d *DockerRunner) {
+		d.backend = &engineBackend{d: d, client: client}
+	}
+}
+
+// engineBackend drives containers through the Engine API
+type engineBackend struct {
+	d      *DockerRunner
+	client *engine.Client
+}
+
+// containerConfig creates the container of a spec with the isolation of
+// prepareBaseArgs and securityOpts
+func (d *DockerRunner) containerConfig(spec containerSpec) engine.ContainerConfig {
+	pids := int64(spec.limits.Pids)
+	ulimits := d.limits.Ulimits
+	cfg := engine.ContainerConfig{
+		Image:        spec.image,
+		Cmd:          spec.command,
+		Env:          spec.env,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		OpenStdin:    true,
+		StdinOnce:    true,
+		HostConfig: engine.HostConfig{
+			NanoCPUs:    spec.limits.NanoCPUs(),
+			Memory:      spec.limits.MemoryBytes(),
+			PidsLimit:   &pids,
+			NetworkMode: "none",
+			CapDrop:     []string{"ALL"},
+			Ulimits: []engine.Ulimit{
+				{Name: "nproc", Soft: int64(ulimits.Nproc), Hard: int64(ulimits.Nproc)},
+				{Name: "nofile", Soft: int64(ulimits.Nofile), Hard: int64(ulimits.Nofile)},
+				{Name: "fsize", Soft: ulimits.Fsize, Hard: ulimits.Fsize},
+			},
+		},
+	}
+	if spec.volume != "" {
+		cfg.HostConfig.Binds = []string{spec.volume + ":" + sandboxDir}
+	}
+	cfg.User = d.hardening.User
+	cfg.HostConfig.Runtime = d.hardening.Runtime
+	cfg.HostConfig.ReadonlyRootfs = d.hardening.ReadOnly
+	cfg.HostConfig.Tmpfs = d.hardeningTmpfs(spec.volume)
+	cfg.HostConfig.SecurityOpt = d.hardeningSecurityOpts()
+	return cfg
+}
+
+// start creates, attaches to and starts the container of the phase, or
+// runs the phase in its pooled container with an exec instance
+func (e *engineBackend) start(ctx context.Context, p phase) (*process, error) {
+	var (
+		stream *engine.Stream
+		execID string
+		err    error
+	)
+	if p.pooled {
+		stream, execID, err = e.startExec(ctx, p.container, p.spec.command, p.spec.env)
+	} else {
+		stream, err = e.startContainer(ctx, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+	demuxed := make(chan struct{})
+	go func() {
+		defer close(demuxed)
+		err := stream.Demux(stdoutWriter, stderrWriter)
+		stdoutWriter.CloseWithError(err)
+		stderrWriter.CloseWithError(err)
+	}()
+	usage := e.sampleUsage(p.container)
+
+	exitCode := -1
+	return &process{
+		stdin:  streamInput{stream},
+		stdout: stdout,
+		stderr: stderr,
+		wait: func() error {
+			// Output still unread when the phase ended is dropped
+			stdout.Close()
+			stderr.Close()
+			<-demuxed
+			stream.Close()
+			usage.stop()
+
+			if p.pooled {
+				code, err := e.waitExec(execID)
+				exitCode = code
+				return err
+			}
+			_, err := e.client.WaitContainer(context.Background(), p.container)
+			return err
+		},
+		result: func() executor.ExecutionResult {
+			var result executor.ExecutionResult
+			if p.pooled {
+				result = e.d.execResult(p.container, exitCode)
+			} else {
+				result = e.d.exitResult(p.container, nil)
+			}
+			result.Usage = usage.usage()
+			return result
+		},
+	}, nil
+}
+
+// startContainer creates the container of the phase and starts it
+// attached, so none of its output is missed
+func (e *engineBackend) startContainer(ctx context.Context, p phase) (*engine.Stream, error) {
+	if _, err := e.client.CreateContainer(ctx, p.container, e.d.containerConfig(p.spec)); err != nil {
+		return nil, fmt.Errorf("error creating container: %w", err)
+	}
+
+	stream, err := e.client.AttachContainer(ctx, p.container)
+	if err == nil {
+		if err = e.client.StartContainer(ctx, p.container); err != nil {
+			stream.Close()
+		}
+	}
+	if err != nil {
+		e.remove(p.container)
+		return nil, fmt.Errorf("error starting container: %w", err)
+	}
+	return stream, nil
+}
+
+// startExec runs a command attached in a running container
+func (e *engineBackend) startExec(ctx context.Context, container string, command, env []string) (*engine.Stream, string, error) {
+	id, err := e.client.CreateExec(ctx, container, engine.ExecConfig{
+		Cmd:          command,
+		Env:          env,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating exec: %w", err)
+	}
+	stream, err := e.client.StartExec(ctx, id)
+	if err != nil {
+		return nil, "", fmt.Errorf("error starting exec: %w", err)
+	}
+	return stream, id, nil
+}
+
+// waitExec returns the exit code of an exec instance whose output has
+// ended. The Engine may report it running for a moment after.
+func (e *engineBackend) waitExec(id string) (int, error) {
+	for i := 0; ; i++ {
+		state, err := e.client.InspectExec(context.Background(), id)
+		if err != nil {
+			return -1, fmt.Errorf("error inspecting exec: %w", err)
+		}
+		if !state.Running {
+			return state.ExitCode, nil
+		}
+		if i == execPollAttempts {
+			return -1, fmt.Errorf("exec %s is still running", id)
+		}
+		time.Sleep(execPollInterval)
+	}
+}
+
+// runExec runs a command in a running container and discards its output
+func (e *engineBackend) runExec(container string, command ...string) error {
+	stream, id, err := e.startExec(context.Background(), container, command, nil)
+	if err != nil {
+		return err
+	}
+	stream.CloseWrite()
+	stream.Demux(io.Discard, io.Discard)
+	stream.Close()
+
+	code, err := e.waitExec(id)
+	if err == nil && code != 0 {
+		err = fmt.Errorf("%s exited with status %d", command[0], code)
+	}
+	return err
+}
+
+// startIdle creates and starts a pooled container without attaching to it
+func (e *engineBackend) startIdle(ctx context.Context, name string, spec containerSpec) error {
+	cfg := e.d.containerConfig(spec)
+	cfg.AttachStdin, cfg.AttachStdout, cfg.AttachStderr = false, false, false
+	cfg.OpenStdin, cfg.StdinOnce = false, false
+
+	if _, err := e.client.CreateContainer(ctx, name, cfg); err != nil {
+		return fmt.Errorf("error creating pooled container: %w", err)
+	}
+	if err := e.client.StartContainer(ctx, name); err != nil {
+		return fmt.Errorf("error starting pooled container: %w", err)
+	}
+	return nil
+}
+
+func (e *engineBackend) removeStalePool() {
+	ctx := context.Background()
+	if ids, err := e.client.ListContainers(ctx, poolPrefix); err == nil {
+		for _, id := range ids {
+			e.client.RemoveContainer(ctx, id)
+		}
+	}
+	if names, err := e.client.ListVolumes(ctx, poolPrefix); err == nil {
+		for _, name := range names {
+			e.client.RemoveVolume(ctx, name)
+		}
+	}
+}
+
+func (e *engineBackend) kill(containerName string) error {
+	return e.client.KillContainer(context.Background(), containerName, "SIGKILL")
+}
+
+func (e *engineBackend) signal(p phase, signal string) error {
+	if p.pooled {
+		return e.runExec(p.container, "bash", "-c", killAllCommand(signal))
+	}
+	return e.client.KillContainer(context.Background(), p.container, "SIG"+signal)
+}
+
+func (e *engineBackend) inspect(containerName string) (containerState, error) {
+	state, err := e.client.InspectContainer(context.Background(), containerName)
+	if err != nil {
+		return containerState{}, fmt.Errorf("error inspecting container: %w", err)
+	}
+	return containerState{ExitCode: state.ExitCode, OOMKilled: state.OOMKilled}, nil
+}
+
+func (e *engineBackend) remove(containerName string) {
+	e.client.RemoveContainer(context.Background(), containerName)
+}
+
+func (e *engineBackend) createVolume(ctx context.Context, name string) error {
+	err := e.client.CreateVolume(ctx, engine.VolumeConfig{Name: name, Driver: "local", DriverOpts: e.d.volumeOpts()})
+	if err != nil {
+		return fmt.Errorf("error creating volume: %w", err)
+	}
+	return nil
+}
+
+func (e *engineBackend) removeVolume(name string) {
+	e.client.RemoveVolume(context.Background(), name)
+}
+
+func (e *engineBackend) probeVersion(ctx context.Context, lang *language.Language) (string, error) {
+	return e.d.probeVersionDirect(ctx, lang, e.d.image(lang))
+}
+
+// streamInput is the stdin of an attached program. Closing it leaves the
+// program's output open.
+type streamInput struct {
+	*engine.Stream
+}
+
+func (s streamInput) Close() error {
+	return s.CloseWrite()
+}
+
+// usageSampler samples the resource usage of a container until stopped,
+// keeping the peak memory and the CPU time
+type usageSampler struct {
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	mu      sync.Mutex
+	memory  uint64
+	cpu     uint64
+	sampled bool
+}
+
+// sampleUsage starts sampling the container's resource usage
+func (e *engineBackend) sampleUsage(container string) *usageSampler {
+	ctx, cancel := context.WithCancel(context.Background())
+	u := &usageSampler{cancel: cancel, stopped: make(chan struct{})}
+	go func() {
+		defer close(u.stopped)
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+		for {
+			if stats, err := e.client.ContainerStats(ctx, container); err == nil {
+				u.add(stats)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return u
+}
+
+// add records a sample. Stopped containers report no usage.
+func (u *usageSampler) add(stats engine.Stats) {
+	if stats.MemoryUsage == 0 && stats.CPUUsage == 0 {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.memory = max(u.memory, stats.MemoryUsage, stats.MemoryMaxUsage)
+	u.cpu = max(u.cpu, stats.CPUUsage)
+	u.sampled = true
+}
+
+func (u *usageSampler) stop() {
+	u.cancel()
+	<-u.stopped
+}
+
+// usage returns the usage sampled, or nil if no sample was taken
+func (u *usageSampler) usage() *executor.ResourceUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.sampled {
+		return nil
+	}
+	return &executor.ResourceUsage{
+		MemoryPeakBytes: u.memory,
+		CPUTimeMs:       int64(u.cpu / uint64(time.Millisecond)),
+	}
+}
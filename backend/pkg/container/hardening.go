@@ -0,0 +1,186 @@
+// hardening profile of the containers
+package container
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tiakavousi/codeplayground/pkg/config"
+)
+
+// builtinSeccompProfile allows the system calls compilers and programs
+// need and denies the rest, such as ptrace, mount, bpf and keyctl, and
+// creating namespaces
+//
+//go:embed seccomp.json
+var builtinSeccompProfile []byte
+
+// tmpfsOptions are the mount options of the writable tmpfs directories of
+// a read-only container. Compilers and programs execute files there.
+const tmpfsOptions = "rw,exec,nosuid,nodev,mode=1777,size="
+
+// WithHardening sets the runtime, filesystem, privileges, seccomp profile
+// and user of the containers
+func WithHardening(h config.Hardening) Option {
+	return func(d *DockerRunner) {
+		d.hardening = h
+	}
+}
+
+// setupSeccomp prepares the seccomp profile for the backend. The docker CLI
+// reads it from a file while the Engine API takes its content, so the
+// builtin profile is written out for the CLI and a profile given by path is
+// read for the Engine API. A failure is reported by every run.
+func (d *DockerRunner) setupSeccomp() {
+	switch d.hardening.Seccomp {
+	case "":
+		return
+	case config.SeccompUnconfined:
+		d.seccomp = config.SeccompUnconfined
+		return
+	}
+
+	if _, ok := d.backend.(*engineBackend); !ok {
+		if d.hardening.Seccomp != config.SeccompBuiltin {
+			d.seccomp = d.hardening.Seccomp
+			return
+		}
+		path, err := writeSeccompProfile()
+		if err != nil {
+			d.seccompErr = fmt.Errorf("error writing seccomp profile: %w", err)
+			return
+		}
+		d.seccomp = path
+		return
+	}
+
+	profile := builtinSeccompProfile
+	if d.hardening.Seccomp != config.SeccompBuiltin {
+		var err error
+		if profile, err = os.ReadFile(d.hardening.Seccomp); err != nil {
+			d.seccompErr = fmt.Errorf("error reading seccomp profile: %w", err)
+			return
+		}
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, profile); err != nil {
+		d.seccompErr = fmt.Errorf("invalid seccomp profile: %w", err)
+		return
+	}
+	d.seccomp = compact.String()
+}
+
+// writeSeccompProfile writes the builtin profile for the docker CLI to a
+// path named after its content under the user's cache directory. Every
+// runner and restart reuses the file instead of leaving one behind each.
+func writeSeccompProfile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "codeplayground")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(builtinSeccompProfile)
+	path := filepath.Join(dir, fmt.Sprintf("seccomp-%x.json", sum[:8]))
+	if data, err := os.ReadFile(path); err == nil && bytes.Equal(data, builtinSeccompProfile) {
+		return path, nil
+	}
+
+	// Renamed into place so a concurrent start never reads half a profile
+	f, err := os.CreateTemp(dir, "seccomp-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(builtinSeccompProfile)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// hardeningOpts returns the docker run flags of the hardening profile,
+// which are added to securityOpts
+func (d *DockerRunner) hardeningOpts() []string {
+	h := d.hardening
+	var opts []string
+	if h.Runtime != "" {
+		opts = append(opts, "--runtime="+h.Runtime)
+	}
+	if h.ReadOnly {
+		opts = append(opts, "--read-only", "--tmpfs", "/tmp:"+tmpfsOptions+h.TmpfsSize)
+	}
+	if h.NoNewPrivileges {
+		opts = append(opts, "--security-opt", "no-new-privileges")
+	}
+	if d.seccomp != "" {
+		opts = append(opts, "--security-opt", "seccomp="+d.seccomp)
+	}
+	if h.User != "" {
+		opts = append(opts, "--user", h.User)
+	}
+	return opts
+}
+
+// sandboxMount returns the docker run flags mounting the sandbox directory:
+// the run's volume when its phases share one, or else a tmpfs. Either way
+// the scratch space of a program is bounded by the tmpfs size.
+func (d *DockerRunner) sandboxMount(volume string) []string {
+	if volume != "" {
+		return []string{"-v", volume + ":" + sandboxDir}
+	}
+	return []string{"--tmpfs", sandboxDir + ":" + tmpfsOptions + d.hardening.TmpfsSize}
+}
+
+// volumeOpts returns the driver options of the local volume driver making
+// the volume shared by the phases of a run a tmpfs, bounded like the
+// sandbox directory of a single phase
+func (d *DockerRunner) volumeOpts() map[string]string {
+	return map[string]string{
+		"type":   "tmpfs",
+		"device": "tmpfs",
+		"o":      tmpfsOptions + d.hardening.TmpfsSize,
+	}
+}
+
+// hardeningTmpfs returns the tmpfs mounts of a container created through
+// the Engine API, like the flags of hardeningOpts and sandboxMount
+func (d *DockerRunner) hardeningTmpfs(volume string) map[string]string {
+	if volume != "" && !d.hardening.ReadOnly {
+		return nil
+	}
+	tmpfs := make(map[string]string)
+	if d.hardening.ReadOnly {
+		tmpfs["/tmp"] = tmpfsOptions + d.hardening.TmpfsSize
+	}
+	if volume == "" {
+		tmpfs[sandboxDir] = tmpfsOptions + d.hardening.TmpfsSize
+	}
+	return tmpfs
+}
+
+// hardeningSecurityOpts returns the security options of a container
+// created through the Engine API
+func (d *DockerRunner) hardeningSecurityOpts() []string {
+	var opts []string
+	if d.hardening.NoNewPrivileges {
+		opts = append(opts, "no-new-privileges")
+	}
+	if d.seccomp != "" {
+		opts = append(opts, "seccomp="+d.seccomp)
+	}
+	return opts
+}
@@ -0,0 +1,589 @@
+//go:build integration
+
+package container
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/config"
+	"github.com/tiakavousi/codeplayground/pkg/engine"
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+)
+
+// The integration suite runs hostile programs in real containers to prove
+// the limits hold. It needs a Docker daemon with the image pulled:
+//
+//	go test -tags integration -timeout 30m ./pkg/container
+//
+// INTEGRATION_IMAGE overrides the image, tayebe/repl by default.
+
+// integrationTimeout is the run timeout of the hostile programs, long
+// enough for node to start on half a CPU
+const integrationTimeout = 5 * time.Second
+
+// hostileCase is a program, in each language, that attacks one limit
+type hostileCase struct {
+	name     string
+	programs map[string]string
+
+	// reason is the termination reason the run must report, and stdout,
+	// if set, must match the program's output
+	reason string
+	stdout *regexp.Regexp
+
+	// check inspects the output further, given the limits of the run
+	check func(t *testing.T, stdout string, limits config.Limits)
+}
+
+var hostileCases = []hostileCase{
+	{
+		name:   "Fork Bomb",
+		reason: executor.ReasonExited,
+		stdout: regexp.MustCompile(`^contained after (\d+)\n$`),
+		programs: map[string]string{
+			"python": `
+import os, time
+n = 0
+try:
+    while n < 1000:
+        if os.fork() == 0:
+            time.sleep(60)
+            os._exit(0)
+        n += 1
+except OSError:
+    print("contained after", n)
+`,
+			"javascript": `
+const { spawn } = require("child_process");
+for (let n = 0; n < 1000; n++) {
+  const child = spawn("sleep", ["60"]);
+  if (child.pid === undefined) {
+    console.log("contained after " + n);
+    process.exit(0);
+  }
+}
+`,
+			"c": `
+#include <stdio.h>
+#include <unistd.h>
+
+int main(void) {
+    for (int n = 0; n < 1000; n++) {
+        pid_t pid = fork();
+        if (pid == 0) {
+            sleep(60);
+            _exit(0);
+        }
+        if (pid < 0) {
+            printf("contained after %d\n", n);
+            return 0;
+        }
+    }
+    return 1;
+}
+`,
+			"cpp": `
+#include <iostream>
+#include <unistd.h>
+
+int main() {
+    for (int n = 0; n < 1000; n++) {
+        pid_t pid = fork();
+        if (pid == 0) {
+            sleep(60);
+            _exit(0);
+        }
+        if (pid < 0) {
+            std::cout << "contained after " << n << std::endl;
+            return 0;
+        }
+    }
+    return 1;
+}
+`,
+			// Every process started also takes a reaper thread of the JVM
+			"java": `
+public class Main {
+    public static void main(String[] args) {
+        int n = 0;
+        try {
+            for (; n < 1000; n++)
+                new ProcessBuilder("sleep", "60").start();
+        } catch (java.io.IOException | OutOfMemoryError e) {
+            System.out.println("contained after " + n);
+            System.exit(0);
+        }
+        System.exit(1);
+    }
+}
+`,
+		},
+		check: func(t *testing.T, stdout string, limits config.Limits) {
+			m := regexp.MustCompile(`\d+`).FindString(stdout)
+			if n, _ := strconv.Atoi(m); n >= limits.Run.Pids {
+				t.Errorf("forked %d processes, want fewer than the pids limit %d", n, limits.Run.Pids)
+			}
+		},
+	},
+	{
+		// Bash retries failed forks for longer than the timeout
+		name:   "Shell Fork Bomb",
+		reason: executor.ReasonTimedOut,
+		programs: map[string]string{
+			"bash": `:(){ :|:& };:`,
+		},
+	},
+	{
+		name:   "Memory Balloon",
+		reason: executor.ReasonOOMKilled,
+		programs: map[string]string{
+			"python": `
+chunks = []
+while True:
+    chunks.append(b"x" * (16 << 20))
+`,
+			"javascript": `
+const chunks = [];
+for (;;) chunks.push(Buffer.alloc(16 << 20, 1));
+`,
+			"bash": `x=xxxxxxxxxxxxxxxx; while true; do x=$x$x; done`,
+			"c": `
+#include <stdlib.h>
+#include <string.h>
+
+int main(void) {
+    for (;;) {
+        char *p = malloc(16 << 20);
+        if (p != NULL)
+            memset(p, 1, 16 << 20);
+    }
+}
+`,
+			"cpp": `
+#include <cstring>
+#include <new>
+
+int main() {
+    for (;;) {
+        char *p = new (std::nothrow) char[16 << 20];
+        if (p != nullptr)
+            std::memset(p, 1, 16 << 20);
+    }
+}
+`,
+			// The heap is bounded by the JVM, so native memory is
+			// allocated to reach the cgroup limit
+			"java": `
+import java.lang.reflect.Field;
+import sun.misc.Unsafe;
+
+public class Main {
+    public static void main(String[] args) throws Exception {
+        Field f = Unsafe.class.getDeclaredField("theUnsafe");
+        f.setAccessible(true);
+        Unsafe unsafe = (Unsafe) f.get(null);
+        for (;;) {
+            long p = unsafe.allocateMemory(16 << 20);
+            unsafe.setMemory(p, 16 << 20, (byte) 1);
+        }
+    }
+}
+`,
+		},
+	},
+	{
+		// Programs run as the init of their container, which ignores
+		// SIGXFSZ, so the write fails instead
+		name:   "File Past Fsize",
+		reason: executor.ReasonExited,
+		stdout: regexp.MustCompile(`^size (\d+)\n$`),
+		programs: map[string]string{
+			"python": `
+import os
+fd = os.open("big", os.O_WRONLY | os.O_CREAT | os.O_TRUNC)
+try:
+    for _ in range(32):
+        os.write(fd, b"x" * 65536)
+except OSError:
+    pass
+print("size", os.path.getsize("big"))
+`,
+			"javascript": `
+const fs = require("fs");
+process.on("SIGXFSZ", () => {});
+const fd = fs.openSync("big", "w");
+try {
+  for (let i = 0; i < 32; i++) fs.writeSync(fd, Buffer.alloc(65536, 1));
+} catch (e) {}
+console.log("size " + fs.statSync("big").size);
+`,
+			"bash": `
+head -c 2097152 /dev/zero > big
+echo "size $(stat -c %s big)"
+`,
+			"c": `
+#include <fcntl.h>
+#include <signal.h>
+#include <stdio.h>
+#include <string.h>
+#include <sys/stat.h>
+#include <unistd.h>
+
+int main(void) {
+    static char buf[65536];
+    struct stat st;
+    signal(SIGXFSZ, SIG_IGN);
+    memset(buf, 1, sizeof buf);
+    int fd = open("big", O_WRONLY | O_CREAT | O_TRUNC, 0644);
+    for (int i = 0; i < 32; i++)
+        if (write(fd, buf, sizeof buf) < 0)
+            break;
+    stat("big", &st);
+    printf("size %ld\n", (long)st.st_size);
+    return 0;
+}
+`,
+			"cpp": `
+#include <csignal>
+#include <fstream>
+#include <iostream>
+#include <sys/stat.h>
+#include <vector>
+
+int main() {
+    std::signal(SIGXFSZ, SIG_IGN);
+    std::vector<char> buf(65536, 1);
+    {
+        std::ofstream out("big", std::ios::binary);
+        for (int i = 0; i < 32 && out.write(buf.data(), buf.size()); i++) {
+        }
+    }
+    struct stat st;
+    stat("big", &st);
+    std::cout << "size " << st.st_size << std::endl;
+    return 0;
+}
+`,
+			// The JVM ignores SIGXFSZ itself
+			"java": `
+import java.io.File;
+import java.io.FileOutputStream;
+import java.io.IOException;
+
+public class Main {
+    public static void main(String[] args) {
+        byte[] buf = new byte[65536];
+        try (FileOutputStream out = new FileOutputStream("big")) {
+            for (int i = 0; i < 32; i++) {
+                out.write(buf);
+            }
+        } catch (IOException e) {
+        }
+        System.out.println("size " + new File("big").length());
+    }
+}
+`,
+		},
+		check: func(t *testing.T, stdout string, limits config.Limits) {
+			m := regexp.MustCompile(`\d+`).FindString(stdout)
+			if n, _ := strconv.ParseInt(m, 10, 64); n > limits.Ulimits.Fsize {
+				t.Errorf("wrote %d bytes, want at most the fsize limit %d", n, limits.Ulimits.Fsize)
+			}
+		},
+	},
+	{
+		name:   "Infinite Output",
+		reason: executor.ReasonOutputLimit,
+		programs: map[string]string{
+			"python":     `while True: print("x")`,
+			"javascript": `for (;;) process.stdout.write("x\n");`,
+			"bash":       `while true; do echo x; done`,
+			"c": `
+#include <stdio.h>
+
+int main(void) {
+    for (;;)
+        puts("x");
+}
+`,
+			"cpp": `
+#include <iostream>
+
+int main() {
+    for (;;)
+        std::cout << "x\n";
+}
+`,
+			"java": `
+public class Main {
+    public static void main(String[] args) {
+        for (;;)
+            System.out.println("x");
+    }
+}
+`,
+		},
+		check: func(t *testing.T, stdout string, limits config.Limits) {
+			if lines := strings.Count(stdout, "\n"); int64(len(stdout)) > limits.Run.OutputBytes || lines > limits.Run.OutputLines {
+				t.Errorf("got %d bytes in %d lines, want at most the output limits", len(stdout), lines)
+			}
+		},
+	},
+	{
+		name:   "Network Access",
+		reason: executor.ReasonExited,
+		stdout: regexp.MustCompile(`^blocked\n$`),
+		programs: map[string]string{
+			"python": `
+import socket
+try:
+    socket.create_connection(("1.1.1.1", 53), timeout=2)
+    print("connected")
+except OSError:
+    print("blocked")
+`,
+			"javascript": `
+const socket = require("net").connect({ host: "1.1.1.1", port: 53, timeout: 2000 });
+socket.on("connect", () => { console.log("connected"); process.exit(0); });
+socket.on("error", () => { console.log("blocked"); process.exit(0); });
+socket.on("timeout", () => { console.log("blocked"); process.exit(0); });
+`,
+			"bash": `
+if (echo > /dev/tcp/1.1.1.1/53) 2>/dev/null; then echo connected; else echo blocked; fi
+`,
+			"c": `
+#include <arpa/inet.h>
+#include <stdio.h>
+#include <sys/socket.h>
+
+int main(void) {
+    struct sockaddr_in addr = {.sin_family = AF_INET, .sin_port = htons(53)};
+    inet_pton(AF_INET, "1.1.1.1", &addr.sin_addr);
+    int fd = socket(AF_INET, SOCK_STREAM, 0);
+    if (fd >= 0 && connect(fd, (struct sockaddr *)&addr, sizeof addr) == 0)
+        puts("connected");
+    else
+        puts("blocked");
+    return 0;
+}
+`,
+			"cpp": `
+#include <arpa/inet.h>
+#include <iostream>
+#include <sys/socket.h>
+
+int main() {
+    sockaddr_in addr{};
+    addr.sin_family = AF_INET;
+    addr.sin_port = htons(53);
+    inet_pton(AF_INET, "1.1.1.1", &addr.sin_addr);
+    int fd = socket(AF_INET, SOCK_STREAM, 0);
+    if (fd >= 0 && connect(fd, reinterpret_cast<sockaddr *>(&addr), sizeof addr) == 0)
+        std::cout << "connected" << std::endl;
+    else
+        std::cout << "blocked" << std::endl;
+    return 0;
+}
+`,
+			"java": `
+import java.io.IOException;
+import java.net.InetSocketAddress;
+import java.net.Socket;
+
+public class Main {
+    public static void main(String[] args) {
+        try (Socket socket = new Socket()) {
+            socket.connect(new InetSocketAddress("1.1.1.1", 53), 2000);
+            System.out.println("connected");
+        } catch (IOException e) {
+            System.out.println("blocked");
+        }
+    }
+}
+`,
+		},
+	},
+	{
+		// Only the container's own processes are visible
+		name:   "Host Proc",
+		reason: executor.ReasonExited,
+		stdout: regexp.MustCompile(`^procs (\d+)\n$`),
+		programs: map[string]string{
+			"python": `
+import os
+print("procs", sum(name.isdigit() for name in os.listdir("/proc")))
+`,
+			"javascript": `
+const procs = require("fs").readdirSync("/proc").filter((name) => /^\d+$/.test(name));
+console.log("procs " + procs.length);
+`,
+			"bash": `
+n=0
+for p in /proc/[0-9]*; do n=$((n + 1)); done
+echo "procs $n"
+`,
+			"c": `
+#include <ctype.h>
+#include <dirent.h>
+#include <stdio.h>
+
+int main(void) {
+    DIR *dir = opendir("/proc");
+    struct dirent *e;
+    int n = 0;
+    while ((e = readdir(dir)) != NULL)
+        if (isdigit((unsigned char)e->d_name[0]))
+            n++;
+    printf("procs %d\n", n);
+    return 0;
+}
+`,
+			"cpp": `
+#include <cctype>
+#include <dirent.h>
+#include <iostream>
+
+int main() {
+    DIR *dir = opendir("/proc");
+    int n = 0;
+    while (dirent *e = readdir(dir))
+        if (std::isdigit(static_cast<unsigned char>(e->d_name[0])))
+            n++;
+    std::cout << "procs " << n << std::endl;
+    return 0;
+}
+`,
+			"java": `
+import java.io.File;
+
+public class Main {
+    public static void main(String[] args) {
+        int n = 0;
+        for (String name : new File("/proc").list()) {
+            if (name.chars().allMatch(Character::isDigit)) {
+                n++;
+            }
+        }
+        System.out.println("procs " + n);
+    }
+}
+`,
+		},
+		check: func(t *testing.T, stdout string, limits config.Limits) {
+			m := regexp.MustCompile(`\d+`).FindString(stdout)
+			if n, _ := strconv.Atoi(m); n > 2 {
+				t.Errorf("saw %d processes in /proc, want only the program's", n)
+			}
+		},
+	},
+	{
+		name:   "Sleep Past Timeout",
+		reason: executor.ReasonTimedOut,
+		programs: map[string]string{
+			"python":     "import time\ntime.sleep(60)",
+			"javascript": `setTimeout(() => {}, 60000);`,
+			"bash":       `sleep 60`,
+			"c": `
+#include <unistd.h>
+
+int main(void) {
+    sleep(60);
+    return 0;
+}
+`,
+			"cpp": `
+#include <chrono>
+#include <thread>
+
+int main() {
+    std::this_thread::sleep_for(std::chrono::seconds(60));
+    return 0;
+}
+`,
+			"java": `
+public class Main {
+    public static void main(String[] args) throws InterruptedException {
+        Thread.sleep(60000);
+    }
+}
+`,
+		},
+	},
+}
+
+// integrationRunners returns the runners the hostile programs run with:
+// the docker CLI, the Engine API, and the CLI with the builtin hardening
+// profile. It skips the test without a daemon and the image.
+func integrationRunners(t *testing.T, limits config.Limits) map[string]*DockerRunner {
+	t.Helper()
+	image := os.Getenv("INTEGRATION_IMAGE")
+	if image == "" {
+		image = "tayebe/repl"
+	}
+	if out, err := exec.Command("docker", "image", "inspect", image).CombinedOutput(); err != nil {
+		t.Skipf("no Docker daemon with image %s: %v: %s", image, err, out)
+	}
+
+	client, err := engine.NewClient(os.Getenv("DOCKER_HOST"))
+	if err != nil {
+		t.Fatalf("engine.NewClient() error = %v", err)
+	}
+	hardening := config.Hardening{
+		ReadOnly:        true,
+		TmpfsSize:       "64m",
+		NoNewPrivileges: true,
+		Seccomp:         config.SeccompBuiltin,
+	}
+	return map[string]*DockerRunner{
+		"CLI":      NewDockerRunner(image, WithLimits(limits)),
+		"API":      NewDockerRunner(image, WithLimits(limits), WithEngine(client)),
+		"Hardened": NewDockerRunner(image, WithLimits(limits), WithHardening(hardening)),
+	}
+}
+
+// TestHostilePrograms runs the cases one at a time, as fork bombs share
+// the nproc limit of the image's user with every other container
+func TestHostilePrograms(t *testing.T) {
+	limits := config.Default().Limits
+	limits.Run.Timeout = integrationTimeout
+
+	for backend, runner := range integrationRunners(t, limits) {
+		service := executor.NewService(runner, executor.WithTimeout(limits.ExecutionTimeout))
+		for _, tc := range hostileCases {
+			for lang, program := range tc.programs {
+				t.Run(backend+"/"+tc.name+"/"+lang, func(t *testing.T) {
+					start := time.Now()
+					result, err := service.Execute(context.Background(), executor.ExecRequest{Language: lang, Code: program})
+					elapsed := time.Since(start)
+					if err != nil && tc.reason != executor.ReasonTimedOut {
+						t.Fatalf("Execute() error = %v", err)
+					}
+
+					if result.Compile.Failed() {
+						t.Fatalf("compile failed: %s", result.Compile.Output)
+					}
+					if result.Reason != tc.reason {
+						t.Errorf("Reason = %s, want %s (exit code %d, stderr %.200q)", result.Reason, tc.reason, result.ExitCode, result.Stderr)
+					}
+					if tc.stdout != nil && !tc.stdout.MatchString(result.Stdout) {
+						t.Errorf("stdout = %.200q, want a match for %s", result.Stdout, tc.stdout)
+					}
+					if tc.check != nil {
+						tc.check(t, result.Stdout, limits)
+					}
+					// Compiling and removing the containers take a while too
+					if elapsed > limits.Compile.Timeout+2*integrationTimeout {
+						t.Errorf("run took %v, want it stopped at the timeout", elapsed)
+					}
+				})
+			}
+		}
+	}
+}
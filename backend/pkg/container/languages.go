@@ -2,65 +2,227 @@
 package container
 
 import (
-	"context"
 	"fmt"
-	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
 )
 
-func (d *DockerRunner) prepareJavaCommand(ctx context.Context, baseArgs []string, code string) *exec.Cmd {
-	// Add the Docker image name
-	args := append(baseArgs, d.imageName)
+// phase is one container started for a request
+type phase struct {
+	name      string // executor.PhaseCompiling or executor.PhaseRunning
+	container string
+	args      []string // arguments of docker run, or docker exec if pooled
+	archive   []byte   // written to stdin before any user input
+	timeout   time.Duration
+	pooled    bool
+
+	// spec describes the container for the Engine API; its env and command
+	// are also what docker exec runs in a pooled container
+	spec containerSpec
+
+	// diagnose parses the compiler output, or the program's stderr
+	diagnose diagnosticParser
+}
+
+// containerSpec is the container a phase runs in, with every limit set
+type containerSpec struct {
+	image   string
+	command []string
+	env     []string // NAME=value, sorted by name
+	limits  language.Limits
+	volume  string // mounted at the sandbox directory when set
+}
 
-	// Add the command to run
-	command := fmt.Sprintf(`
-		cd /sandbox/tmp &&
-		echo '%s' > Main.java &&
-		javac Main.java &&
-		java Main
-	`, code)
+// executionPlan lists the containers started for a request. Compiled
+// languages build in one container and run in another, sharing the
+// sandbox directory through a volume.
+type executionPlan struct {
+	language string
+	compile  *phase
+	run      phase
+	volume   string
+}
+
+// inContainer returns the phase run with docker exec in a container that
+// is already running
+func (p phase) inContainer(container string) phase {
+	p.container = container
+	p.pooled = true
+	p.args = append(append([]string{"exec", "-i"}, envArgs(p.spec.env)...), container)
+	p.args = append(p.args, p.spec.command...)
+	return p
+}
+
+// usePooled runs the plan in the containers of a pooled slot
+func (plan *executionPlan) usePooled(s slot) {
+	plan.run = plan.run.inContainer(s.run)
+	if plan.compile != nil {
+		compile := plan.compile.inContainer(s.compile)
+		plan.compile = &compile
+	}
+	// The slot's volume is removed with its containers
+	plan.volume = ""
+}
 
-	args = append(args, "bash", "-c", command)
-	return exec.CommandContext(ctx, "docker", args...)
+// image returns the Docker image used to run the language
+func (d *DockerRunner) image(lang *language.Language) string {
+	if lang.Image != "" {
+		return lang.Image
+	}
+	return d.imageName
 }
 
-func (d *DockerRunner) prepareCCommand(ctx context.Context, baseArgs []string, code string) *exec.Cmd {
-	// Add the Docker image name
-	args := append(baseArgs, d.imageName)
+// preparePlan builds the containers for the request
+func (d *DockerRunner) preparePlan(containerName string, req executor.ExecRequest) (executionPlan, error) {
+	if d.seccompErr != nil {
+		return executionPlan{}, d.seccompErr
+	}
+	plan, err := d.planPhases(containerName, req)
+	if err != nil {
+		return executionPlan{}, err
+	}
 
-	// Add the command to run
-	command := fmt.Sprintf(`
-		cd /sandbox/tmp &&
-		echo '%s' > main.c &&
-		gcc main.c -o main &&
-		./main
-	`, code)
+	lang, _ := d.languages.Lookup(plan.language)
+	for _, p := range []*phase{plan.compile, &plan.run} {
+		if p != nil {
+			p.spec.image = d.image(lang)
+			p.args = d.runArgs(p.container, p.spec)
+		}
+	}
+	return plan, nil
+}
 
-	args = append(args, "bash", "-c", command)
-	return exec.CommandContext(ctx, "docker", args...)
+// runArgs returns the docker run command of a container
+func (d *DockerRunner) runArgs(containerName string, spec containerSpec) []string {
+	args := append(d.prepareBaseArgs(containerName, spec.limits), envArgs(spec.env)...)
+	args = append(args, d.sandboxMount(spec.volume)...)
+	args = append(args, spec.image)
+	return append(args, spec.command...)
 }
 
-func (d *DockerRunner) prepareCppCommand(ctx context.Context, baseArgs []string, code string) *exec.Cmd {
-	// Add the Docker image name
-	args := append(baseArgs, d.imageName)
+// planPhases builds the phases of the request, with their commands and
+// limits. Their containers have no image yet.
+func (r *phaseRunner) planPhases(containerName string, req executor.ExecRequest) (executionPlan, error) {
+	lang, ok := r.languages.Lookup(req.Language)
+	if !ok {
+		return executionPlan{}, fmt.Errorf("%w: %s", executor.ErrInvalidLanguage, req.Language)
+	}
+
+	options, err := lang.OptionArgs(req.Standard, req.Optimization, req.Flags)
+	if err != nil {
+		return executionPlan{}, fmt.Errorf("%w: %w", executor.ErrInvalidRequest, err)
+	}
+
+	runLimits, compileLimits := r.limits.Phases(lang)
+	runLimits, err = r.limits.Request(runLimits, req.Limits)
+	if err != nil {
+		return executionPlan{}, fmt.Errorf("%w: %w", executor.ErrInvalidRequest, err)
+	}
+
+	run := phase{
+		name:      executor.PhaseRunning,
+		container: containerName,
+		timeout:   runLimits.Timeout,
+		spec: containerSpec{
+			env:    envList(req.Env),
+			limits: runLimits,
+		},
+	}
+
+	// Interpreters that take the program inline run without a shell
+	if lang.SourceFile == "" && len(req.Files) == 0 {
+		command := language.ExpandArgs(lang.Run, "", nil, options)
+		for i, arg := range command {
+			command[i] = strings.ReplaceAll(arg, language.CodePlaceholder, req.Code)
+		}
+		run.spec.command = append(command, req.Args...)
+		return executionPlan{language: lang.ID, run: run}, nil
+	}
+
+	files, entrypoint, err := sourceFiles(lang, req)
+	if err != nil {
+		return executionPlan{}, err
+	}
 
-	// Add the command to run
-	command := fmt.Sprintf(`
-		cd /sandbox/tmp &&
-		echo '%s' > main.cpp &&
-		g++ main.cpp -o main &&
-		./main
-	`, code)
+	archive, err := buildArchive(files)
+	if err != nil {
+		return executionPlan{}, fmt.Errorf("error staging source: %w", err)
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sources := lang.Sources(paths, entrypoint)
+	command := language.ExpandArgs(lang.Run, entrypoint, sources, options)
+	runStep := "exec " + shellJoin(append(command, req.Args...))
+
+	if len(lang.Compile) == 0 {
+		run.diagnose = diagnosticParsers[lang.Diagnostics]
+		run.archive = archive
+		run.spec.command = shellScript(extractCommand(len(archive)), runStep)
+		return executionPlan{language: lang.ID, run: run}, nil
+	}
+
+	plan := executionPlan{language: lang.ID, volume: containerName + "-src"}
+	compile := shellJoin(language.ExpandArgs(lang.Compile, entrypoint, sources, options))
+	plan.compile = &phase{
+		name:      executor.PhaseCompiling,
+		container: containerName + "-compile",
+		archive:   archive,
+		timeout:   compileLimits.Timeout,
+		diagnose:  diagnosticParsers[lang.Diagnostics],
+		spec: containerSpec{
+			command: shellScript(extractCommand(len(archive)), compile),
+			limits:  compileLimits,
+			volume:  plan.volume,
+		},
+	}
+
+	run.spec.command = shellScript(runStep)
+	run.spec.volume = plan.volume
+	plan.run = run
+	return plan, nil
+}
 
-	args = append(args, "bash", "-c", command)
-	return exec.CommandContext(ctx, "docker", args...)
+// shellScript runs the steps in the sandbox directory, stopping at the
+// first that fails
+func shellScript(steps ...string) []string {
+	steps = append([]string{"cd " + sandboxDir}, steps...)
+	return []string{"bash", "-c", strings.Join(steps, " && ")}
 }
 
-func (d *DockerRunner) prepareJavaScriptCommand(ctx context.Context, baseArgs []string, code string) *exec.Cmd {
-	// Add the Docker image name
-	args := append(baseArgs, d.imageName)
+// sourceFiles collects the files to stage and the entrypoint to run. Code
+// is written to the language's source file, which is also the default
+// entrypoint.
+func sourceFiles(lang *language.Language, req executor.ExecRequest) (map[string]string, string, error) {
+	files := make(map[string]string, len(req.Files)+1)
+	if req.Code != "" {
+		if lang.SourceFile == "" {
+			return nil, "", fmt.Errorf("%w: language %s takes its code from files", executor.ErrInvalidRequest, lang.ID)
+		}
+		files[lang.SourceFile] = req.Code
+	}
+	for _, f := range req.Files {
+		if _, exists := files[f.Path]; exists {
+			return nil, "", fmt.Errorf("%w: file %s conflicts with the code", executor.ErrInvalidRequest, f.Path)
+		}
+		files[f.Path] = f.Content
+	}
 
-	// For JavaScript, we use node directly instead of writing to a file
-	args = append(args, "node", "-e", code)
+	entrypoint := req.Entrypoint
+	if entrypoint == "" {
+		entrypoint = lang.SourceFile
+	}
+	if entrypoint == "" {
+		return nil, "", fmt.Errorf("%w: language %s needs an entrypoint", executor.ErrInvalidRequest, lang.ID)
+	}
+	if _, ok := files[entrypoint]; !ok {
+		return nil, "", fmt.Errorf("%w: entrypoint %s is not one of the files", executor.ErrInvalidRequest, entrypoint)
+	}
 
-	return exec.CommandContext(ctx, "docker", args...)
+	return files, entrypoint, nil
 }
@@ -0,0 +1,188 @@
+// runner that sandboxes programs on the host without Docker
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/config"
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// sandboxInitArg is the argv[0] the server is started with as the init
+// of a sandbox
+const sandboxInitArg = "codeplayground-sandbox-init"
+
+// sandboxEnv is the environment every sandboxed program starts with. The
+// request's environment overrides it.
+var sandboxEnv = []string{
+	"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	"HOME=" + sandboxDir,
+}
+
+// LocalRunner implements the executor.CodeRunner interface by running
+// programs directly on the host, each in new pid, mount, network, IPC and
+// UTS namespaces with a throwaway overlay of the root filesystem. Its
+// cgroup, rlimits and seccomp filter give it the limits a DockerRunner
+// gives containers. Phases run and stream exactly as with a DockerRunner;
+// there are no images, no hardening options and no warm pool.
+type LocalRunner struct {
+	phaseRunner
+}
+
+// NewLocalRunner creates a runner sandboxing programs on the host. It
+// needs root, and Linux. Binaries that create one must call
+// RunSandboxInit first thing in main. Options other than WithRegistry,
+// WithLimits, WithFlushWindow and WithMaxChunkSize have no effect.
+func NewLocalRunner(cfg config.Local, opts ...Option) (*LocalRunner, error) {
+	settings := &DockerRunner{phaseRunner: newPhaseRunner()}
+	for _, opt := range opts {
+		opt(settings)
+	}
+	r := &LocalRunner{phaseRunner: settings.phaseRunner}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.WorkDir, 0o711); err != nil {
+		return nil, fmt.Errorf("error creating work dir: %w", err)
+	}
+	if _, err := os.Stat(cfg.Rootfs); err != nil {
+		return nil, fmt.Errorf("invalid rootfs: %w", err)
+	}
+
+	local := &localSandboxes{r: &r.phaseRunner, cfg: cfg, running: make(map[string]*sandboxProcess)}
+	if err := local.setup(); err != nil {
+		return nil, err
+	}
+	r.backend = local
+	return r, nil
+}
+
+// RunInteractive compiles the program if its language has a compile step,
+// then runs it. Each phase runs in its own sandbox with its own limits.
+func (r *LocalRunner) RunInteractive(ctx context.Context, req executor.ExecRequest, input <-chan executor.Input, output chan<- executor.Output) (executor.ExecutionResult, error) {
+	plan, err := r.planPhases(fmt.Sprintf("code-exec-%d", time.Now().UnixNano()), req)
+	if err != nil {
+		return executor.ExecutionResult{}, err
+	}
+	return r.run(ctx, plan, input, output)
+}
+
+// localSandboxes is the backend of a LocalRunner. It tracks the sandboxes
+// started, keyed by the container name of their phase.
+type localSandboxes struct {
+	r       *phaseRunner
+	cfg     config.Local
+	nextUID atomic.Uint32
+
+	mu      sync.Mutex
+	running map[string]*sandboxProcess
+}
+
+// sandboxSpec is sent by the runner to the sandbox init, which sets up
+// the sandbox and executes the command
+type sandboxSpec struct {
+	Rootfs    string `json:"rootfs"`
+	Scratch   string `json:"scratch"`          // host directory the tmpfs is mounted on
+	Volume    string `json:"volume,omitempty"` // host directory bound to the sandbox directory
+	TmpfsSize string `json:"tmpfs_size"`
+
+	// UID and GID are the ids the program runs as, unless it runs as root
+	// in a user namespace
+	UID           int  `json:"uid"`
+	GID           int  `json:"gid"`
+	UserNamespace bool `json:"user_namespace"`
+
+	Ulimits config.Ulimits `json:"ulimits"`
+	Command []string       `json:"command"`
+	Env     []string       `json:"env"`
+}
+
+// sandboxEnviron returns the environment of a program given the request's
+func sandboxEnviron(env []string) []string {
+	set := make(map[string]bool, len(env))
+	for _, v := range env {
+		name, _, _ := strings.Cut(v, "=")
+		set[name] = true
+	}
+	environ := make([]string, 0, len(sandboxEnv)+len(env))
+	for _, v := range sandboxEnv {
+		if name, _, _ := strings.Cut(v, "="); !set[name] {
+			environ = append(environ, v)
+		}
+	}
+	return append(environ, env...)
+}
+
+// uid hands out the next program uid
+func (s *localSandboxes) uid() int {
+	n := s.nextUID.Add(1) - 1
+	return s.cfg.UID + int(n%uint32(s.cfg.UIDs))
+}
+
+// sandbox returns the running sandbox of a container
+func (s *localSandboxes) sandbox(containerName string) (*sandboxProcess, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sp, ok := s.running[containerName]
+	return sp, ok
+}
+
+// remove deletes what is left of a sandbox once its program has exited
+func (s *localSandboxes) remove(containerName string) {
+	s.mu.Lock()
+	sp, ok := s.running[containerName]
+	delete(s.running, containerName)
+	s.mu.Unlock()
+	if ok {
+		sp.cleanup()
+	}
+}
+
+// createVolume does nothing, as each sandbox creates the directory the
+// phases of a run share as it starts
+func (s *localSandboxes) createVolume(ctx context.Context, name string) error {
+	return nil
+}
+
+// removeVolume deletes the directory the phases of a run share
+func (s *localSandboxes) removeVolume(name string) {
+	os.RemoveAll(filepath.Join(s.cfg.WorkDir, name))
+}
+
+// inspect fails, as the exit state of a sandbox is kept by its process
+func (s *localSandboxes) inspect(containerName string) (containerState, error) {
+	return containerState{}, fmt.Errorf("no container %s to inspect", containerName)
+}
+
+// startIdle fails, as sandboxes are never pooled
+func (s *localSandboxes) startIdle(ctx context.Context, name string, spec containerSpec) error {
+	return fmt.Errorf("the local runner has no pool")
+}
+
+func (s *localSandboxes) removeStalePool() {}
+
+func (s *localSandboxes) probeVersion(ctx context.Context, lang *language.Language) (string, error) {
+	return s.r.probeVersionDirect(ctx, lang, "")
+}
+
+// removeStale deletes the sandbox directories left by an earlier process
+func (s *localSandboxes) removeStale() {
+	entries, err := os.ReadDir(s.cfg.WorkDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "code-") {
+			os.RemoveAll(filepath.Join(s.cfg.WorkDir, e.Name()))
+		}
+	}
+}
@@ -0,0 +1,265 @@
+//go:build amd64 || arm64
+
+// sandboxes started on the host
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"golang.org/x/sys/unix"
+)
+
+// sandboxCloneFlags are the namespaces every sandbox gets
+const sandboxCloneFlags = unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWNET | unix.CLONE_NEWIPC | unix.CLONE_NEWUTS
+
+// sandboxProcess is the init of a running sandbox, which became the
+// program once the sandbox was set up
+type sandboxProcess struct {
+	cmd     *exec.Cmd
+	cgroup  *cgroup // nil when cgroups are disabled
+	scratch string
+}
+
+// setup checks the runner can create sandboxes and removes those left by
+// an earlier process
+func (s *localSandboxes) setup() error {
+	if !s.cfg.UserNamespace && os.Geteuid() != 0 {
+		return fmt.Errorf("the local runner must run as root unless it uses a user namespace")
+	}
+	s.removeStale()
+	if s.cfg.Cgroup != "" {
+		if err := setupCgroupRoot(s.cfg.Cgroup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start starts the init of a new sandbox, which sets the sandbox up and
+// then executes the program of the phase. It returns once the program
+// runs, or with the error that stopped the init.
+func (s *localSandboxes) start(ctx context.Context, p phase) (*process, error) {
+	uid := s.uid()
+	spec := sandboxSpec{
+		Rootfs:        s.cfg.Rootfs,
+		Scratch:       filepath.Join(s.cfg.WorkDir, p.container),
+		TmpfsSize:     s.cfg.TmpfsSize,
+		UID:           uid,
+		GID:           uid,
+		UserNamespace: s.cfg.UserNamespace,
+		Ulimits:       s.r.limits.Ulimits,
+		Command:       p.spec.command,
+		Env:           sandboxEnviron(p.spec.env),
+	}
+	if err := os.Mkdir(spec.Scratch, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating sandbox: %w", err)
+	}
+	sp := &sandboxProcess{scratch: spec.Scratch}
+
+	if p.spec.volume != "" {
+		// The phases of a run share the volume, each as its own user.
+		// Other users cannot read it through the rootfs.
+		spec.Volume = filepath.Join(s.cfg.WorkDir, p.spec.volume)
+		if err := os.MkdirAll(spec.Volume, 0o700); err != nil {
+			sp.cleanup()
+			return nil, fmt.Errorf("error creating volume: %w", err)
+		}
+		if err := chownTree(spec.Volume, uid); err != nil {
+			sp.cleanup()
+			return nil, fmt.Errorf("error preparing volume: %w", err)
+		}
+	}
+
+	cmd := &exec.Cmd{
+		Path: "/proc/self/exe",
+		Args: []string{sandboxInitArg},
+		// The server's environment is not passed on to the sandbox
+		Env: []string{},
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: sandboxCloneFlags,
+			Pdeathsig:  syscall.SIGKILL,
+		},
+	}
+	if s.cfg.UserNamespace {
+		cmd.SysProcAttr.Cloneflags |= unix.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+	}
+	if s.cfg.Cgroup != "" {
+		cg, err := newCgroup(s.cfg.Cgroup, p.container, p.spec.limits)
+		if err != nil {
+			sp.cleanup()
+			return nil, err
+		}
+		sp.cgroup = cg
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = cg.fd()
+	}
+	sp.cmd = cmd
+
+	proc, err := startSandbox(cmd, spec)
+	if err != nil {
+		sp.cleanup()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.running[p.container] = sp
+	s.mu.Unlock()
+
+	proc.result = func() executor.ExecutionResult {
+		return sp.result()
+	}
+	return proc, nil
+}
+
+// startSandbox starts the sandbox init, sends it the spec and waits for
+// it to execute the program. The init reports why it failed on a pipe
+// that executing the program closes.
+func startSandbox(cmd *exec.Cmd, spec sandboxSpec) (*process, error) {
+	specReader, specWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating spec pipe: %w", err)
+	}
+	defer specWriter.Close()
+	errReader, errWriter, err := os.Pipe()
+	if err != nil {
+		specReader.Close()
+		return nil, fmt.Errorf("error creating error pipe: %w", err)
+	}
+	defer errReader.Close()
+	cmd.ExtraFiles = []*os.File{specReader, errWriter}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stderr pipe: %w", err)
+	}
+
+	err = cmd.Start()
+	specReader.Close()
+	errWriter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error starting sandbox: %w", err)
+	}
+
+	if err := json.NewEncoder(specWriter).Encode(spec); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("error sending sandbox spec: %w", err)
+	}
+	specWriter.Close()
+
+	if msg, _ := io.ReadAll(errReader); len(msg) > 0 {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("error starting sandbox: %s", msg)
+	}
+
+	return &process{
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		wait: func() error {
+			// Wait closes the pipes, so every read must finish first
+			err := cmd.Wait()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return nil
+			}
+			return err
+		},
+	}, nil
+}
+
+// chownTree gives every file under dir to the user
+func chownTree(dir string, uid int) error {
+	return filepath.WalkDir(dir, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, uid)
+	})
+}
+
+// result reports how the program exited, with the usage measured by its
+// cgroup or, without one, by the kernel for the program and the children
+// it waited for
+func (sp *sandboxProcess) result() executor.ExecutionResult {
+	var result executor.ExecutionResult
+	state := sp.cmd.ProcessState
+	if state == nil {
+		result.ExitCode = -1
+		return result
+	}
+
+	status := state.Sys().(syscall.WaitStatus)
+	if status.Signaled() {
+		result.ExitCode = signalExitBase + int(status.Signal())
+	} else {
+		result.ExitCode = status.ExitStatus()
+	}
+
+	if sp.cgroup != nil {
+		result.OOMKilled = sp.cgroup.oomKilled()
+		result.Usage = sp.cgroup.usage()
+	} else if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		result.Usage = &executor.ResourceUsage{
+			MemoryPeakBytes: uint64(rusage.Maxrss) * 1024,
+			CPUTimeMs:       (state.UserTime() + state.SystemTime()).Milliseconds(),
+		}
+	}
+	return withSignal(result)
+}
+
+// cleanup removes the cgroup and scratch directory of an exited sandbox
+func (sp *sandboxProcess) cleanup() {
+	if sp.cgroup != nil {
+		sp.cgroup.remove()
+	}
+	os.RemoveAll(sp.scratch)
+}
+
+// kill kills the program, and with it every process of its sandbox
+func (s *localSandboxes) kill(containerName string) error {
+	sp, ok := s.sandbox(containerName)
+	if !ok {
+		return fmt.Errorf("no sandbox %s", containerName)
+	}
+	if err := sp.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+// signal sends a signal, named without its SIG prefix, to the program.
+// Like the main process of a container, it ignores the signals it has no
+// handler for, except SIGKILL.
+func (s *localSandboxes) signal(p phase, signal string) error {
+	sp, ok := s.sandbox(p.container)
+	if !ok {
+		return fmt.Errorf("no sandbox %s", p.container)
+	}
+	sig := unix.SignalNum("SIG" + signal)
+	if sig == 0 {
+		return fmt.Errorf("unknown signal %s", signal)
+	}
+	return sp.cmd.Process.Signal(sig)
+}
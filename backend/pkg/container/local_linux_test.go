@@ -0,0 +1,288 @@
+//go:build amd64 || arm64
+
+package container
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/config"
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// localLanguages run bash on the host's root filesystem
+func localLanguages(t *testing.T) *language.Registry {
+	t.Helper()
+	registry, err := language.NewRegistry([]language.Language{
+		{ID: "bash", SourceFile: "main.sh", Run: []string{"bash", "main.sh"}, Version: []string{"bash", "--version"}},
+		{ID: "inline", Run: []string{"bash", "-c", language.CodePlaceholder}},
+		{ID: "built", SourceFile: "main.sh", Compile: []string{"cp", "main.sh", "main"}, Run: []string{"bash", "main"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	return registry
+}
+
+// newLocalRunner creates a runner sandboxing programs in a temporary work
+// dir, without cgroups. It skips the test where sandboxes cannot be built.
+func newLocalRunner(t *testing.T, opts ...Option) (*LocalRunner, config.Local) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("the local runner needs root")
+	}
+	cfg := config.Default().Local
+	cfg.Rootfs = t.TempDir()
+	cfg.WorkDir = t.TempDir()
+	cfg.Cgroup = ""
+
+	runner, err := NewLocalRunner(cfg, append([]Option{WithRegistry(localLanguages(t))}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewLocalRunner() error = %v", err)
+	}
+	// The programs run the host's bash, so the sandboxes get the host's
+	// root, which NewLocalRunner refuses
+	runner.backend.(*localSandboxes).cfg.Rootfs = "/"
+	_, err, _ = runEngine(runner, executor.ExecRequest{Language: "inline", Code: "true"}, "")
+	if errors.Is(err, syscall.EPERM) || err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skipf("sandboxes cannot be built here: %v", err)
+	}
+	return runner, cfg
+}
+
+func TestNewLocalRunnerRootfs(t *testing.T) {
+	for _, rootfs := range []string{"", "/"} {
+		cfg := config.Default().Local
+		cfg.Rootfs = rootfs
+		cfg.WorkDir = t.TempDir()
+		if _, err := NewLocalRunner(cfg); err == nil || !strings.Contains(err.Error(), "rootfs") {
+			t.Errorf("NewLocalRunner() with rootfs %q error = %v, want a rootfs error", rootfs, err)
+		}
+	}
+}
+
+func TestLocalRunInteractive(t *testing.T) {
+	runner, cfg := newLocalRunner(t)
+
+	code := `read line
+echo "got $line"
+echo warning >&2
+echo "$(id -u) $(hostname) $PWD $GREETING"
+exit 3`
+	req := executor.ExecRequest{Language: "bash", Code: code, Env: map[string]string{"GREETING": "hi"}}
+	result, err, outputs := runEngine(runner, req, "hello\n")
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if result.ExitCode != 3 || result.Usage == nil {
+		t.Errorf("RunInteractive() = %+v, want exit code 3 with usage", result)
+	}
+
+	stdout := outputs[executor.OutputStdout]
+	if !strings.HasPrefix(stdout, "got hello\n") {
+		t.Errorf("RunInteractive() stdout = %q, want the input echoed", stdout)
+	}
+	uid, _ := strconv.Atoi(strings.Fields(stdout + " x x")[2])
+	if uid < cfg.UID || uid >= cfg.UID+cfg.UIDs {
+		t.Errorf("program ran as uid %d, want one of the %d from %d", uid, cfg.UIDs, cfg.UID)
+	}
+	if !strings.HasSuffix(stdout, " sandbox "+sandboxDir+" hi\n") {
+		t.Errorf("RunInteractive() stdout = %q, want the sandbox hostname, directory and env", stdout)
+	}
+	if !strings.HasSuffix(outputs[executor.OutputStderr], "warning\n") {
+		t.Errorf("RunInteractive() stderr = %q", outputs[executor.OutputStderr])
+	}
+
+	if left, _ := os.ReadDir(cfg.WorkDir); len(left) != 0 {
+		t.Errorf("sandbox directories left after run: %v", left)
+	}
+}
+
+func TestLocalIsolation(t *testing.T) {
+	runner, _ := newLocalRunner(t)
+	marker := filepath.Join("/etc", "codeplayground-local-test")
+
+	checks := []struct {
+		name string
+		code string
+		want string
+	}{
+		{name: "PID Namespace", code: "echo $$", want: "1"},
+		{name: "Network Namespace", code: "tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' '", want: "lo"},
+		{name: "No Host Mounts", code: "ls /sys | wc -l", want: "0"},
+		{name: "Throwaway Rootfs", code: "echo x > " + marker + " 2>/dev/null; echo $?", want: "1"},
+		{name: "Writable Tmp", code: "echo x > /tmp/f && cat /tmp/f", want: "x"},
+		{name: "No Capabilities", code: "grep CapEff /proc/self/status | cut -f2", want: "0000000000000000"},
+		{name: "No New Privileges", code: "grep NoNewPrivs /proc/self/status | cut -f2", want: "1"},
+		{name: "Seccomp", code: "grep '^Seccomp:' /proc/self/status | cut -f2", want: "2"},
+		{name: "No Namespaces", code: "unshare -U true 2>/dev/null; echo $?", want: "1"},
+		{name: "Ulimits", code: "echo $(ulimit -u) $(ulimit -n)", want: "20 64"},
+	}
+
+	for _, tt := range checks {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err, outputs := runEngine(runner, executor.ExecRequest{Language: "inline", Code: tt.code}, "")
+			if err != nil {
+				t.Fatalf("RunInteractive() error = %v", err)
+			}
+			if got := strings.TrimSpace(outputs[executor.OutputStdout]); got != tt.want || result.ExitCode != 0 {
+				t.Errorf("RunInteractive() stdout = %q, exit code %d, want %q", got, result.ExitCode, tt.want)
+			}
+		})
+	}
+	if _, err := os.Stat(marker); err == nil {
+		os.Remove(marker)
+		t.Errorf("the program wrote %s on the host", marker)
+	}
+}
+
+func TestLocalLimits(t *testing.T) {
+	limits := config.Default().Limits
+	limits.Run.Timeout = time.Second
+	limits.Ulimits.Fsize = 4096
+	runner, _ := newLocalRunner(t, WithLimits(limits))
+
+	// The program is pid 1 of its sandbox, which signals such as SIGXFSZ
+	// do not kill, so the write is made by a child
+	_, err, outputs := runEngine(runner, executor.ExecRequest{Language: "inline", Code: "head -c 8192 /dev/zero > big; echo $?"}, "")
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if got := outputs[executor.OutputStdout]; got != "153\n" {
+		t.Errorf("RunInteractive() stdout = %q, want the writer killed by SIGXFSZ past fsize", got)
+	}
+
+	start := time.Now()
+	result, err, _ := runEngine(runner, executor.ExecRequest{Language: "inline", Code: "sleep 30 & sleep 30"}, "")
+	if !errors.Is(err, context.DeadlineExceeded) || !result.TimedOut || result.Signal != "SIGKILL" {
+		t.Errorf("RunInteractive() = %+v, %v, want killed at the timeout", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunInteractive() took %v, want the sandbox killed with the program", elapsed)
+	}
+}
+
+func TestLocalCompilePhases(t *testing.T) {
+	runner, cfg := newLocalRunner(t)
+
+	result, err, outputs := runEngine(runner, executor.ExecRequest{Language: "built", Code: "echo built; ls"}, "")
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if result.Compile == nil || result.Compile.Failed() {
+		t.Errorf("RunInteractive() compile = %+v", result.Compile)
+	}
+	if got := outputs[executor.OutputStdout]; got != "built\nmain\nmain.sh\n" {
+		t.Errorf("RunInteractive() stdout = %q, want the compiled program run beside its source", got)
+	}
+	if left, _ := os.ReadDir(cfg.WorkDir); len(left) != 0 {
+		t.Errorf("sandbox directories left after run: %v", left)
+	}
+}
+
+func TestLocalSignalProgram(t *testing.T) {
+	runner, _ := newLocalRunner(t)
+
+	input := make(chan executor.Input)
+	output := make(chan executor.Output, 100)
+	code := "trap 'echo caught; exit 5' TERM; echo ready; while :; do sleep 0.1; done"
+	done := make(chan executor.ExecutionResult, 1)
+	go func() {
+		result, _ := runner.RunInteractive(context.Background(), executor.ExecRequest{Language: "inline", Code: code}, input, output)
+		close(output)
+		done <- result
+	}()
+
+	var stdout string
+	for out := range output {
+		if out.Type != executor.OutputStdout {
+			continue
+		}
+		stdout += out.Data
+		if out.Data == "ready\n" {
+			input <- executor.Input{Type: executor.InputSignal, Data: "TERM"}
+		}
+	}
+	if result := <-done; result.ExitCode != 5 || stdout != "ready\ncaught\n" {
+		t.Errorf("RunInteractive() = %+v, stdout %q, want the trap run", result, stdout)
+	}
+}
+
+func TestLocalOutputLimit(t *testing.T) {
+	limits := config.Default().Limits
+	limits.Run.OutputLines = 1000
+	runner, _ := newLocalRunner(t, WithLimits(limits))
+
+	result, err, outputs := runEngine(runner, executor.ExecRequest{Language: "inline", Code: "yes"}, "")
+	if err != nil {
+		t.Fatalf("RunInteractive() error = %v", err)
+	}
+	if outputs[executor.OutputStdout] != strings.Repeat("y\n", 1000) {
+		t.Errorf("RunInteractive() stdout = %d bytes, want the first 1000 lines", len(outputs[executor.OutputStdout]))
+	}
+	if outputs[executor.OutputStatus] != "output truncated after 1000 lines" {
+		t.Errorf("RunInteractive() status = %q, want the truncation notice", outputs[executor.OutputStatus])
+	}
+	if !result.OutputTruncated || result.Signal != "SIGKILL" {
+		t.Errorf("RunInteractive() result = %+v, want yes killed", result)
+	}
+}
+
+func TestLocalProbeVersions(t *testing.T) {
+	runner, _ := newLocalRunner(t)
+
+	versions, err := runner.ProbeVersions(context.Background())
+	if err != nil {
+		t.Fatalf("ProbeVersions() error = %v", err)
+	}
+	if !strings.HasPrefix(versions["bash"], "GNU bash") {
+		t.Errorf("ProbeVersions() = %v, want the bash version", versions)
+	}
+}
+
+func TestCgroupLimits(t *testing.T) {
+	root := t.TempDir()
+	limits := language.Limits{CPUs: "0.5", Memory: "100m", Pids: 20}
+
+	cg, err := newCgroup(root, "code-exec-1", limits)
+	if err != nil {
+		t.Fatalf("newCgroup() error = %v", err)
+	}
+	defer cg.dir.Close()
+
+	want := map[string]string{
+		"memory.max":      "104857600",
+		"memory.swap.max": "0",
+		"pids.max":        "20",
+		"cpu.max":         "50000 100000",
+	}
+	for name, value := range want {
+		if got, _ := os.ReadFile(filepath.Join(cg.path, name)); string(got) != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	files := map[string]string{
+		"memory.events": "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\n",
+		"memory.peak":   "52428800\n",
+		"cpu.stat":      "usage_usec 250000\nuser_usec 200000\nsystem_usec 50000\n",
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(cg.path, name), []byte(content), 0o644)
+	}
+	if !cg.oomKilled() {
+		t.Error("oomKilled() = false, want the oom_kill event seen")
+	}
+	if got, want := cg.usage(), (&executor.ResourceUsage{MemoryPeakBytes: 50 << 20, CPUTimeMs: 250}); !reflect.DeepEqual(got, want) {
+		t.Errorf("usage() = %+v, want %+v", got, want)
+	}
+}
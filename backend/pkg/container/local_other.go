@@ -0,0 +1,36 @@
+//go:build !linux || !(amd64 || arm64)
+
+// local runner stubs where sandboxes cannot be built
+package container
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// errLocalUnsupported is returned by NewLocalRunner off Linux
+var errLocalUnsupported = fmt.Errorf("the local runner is not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+
+type sandboxProcess struct{}
+
+func (sp *sandboxProcess) cleanup() {}
+
+func (s *localSandboxes) setup() error {
+	return errLocalUnsupported
+}
+
+func (s *localSandboxes) start(ctx context.Context, p phase) (*process, error) {
+	return nil, errLocalUnsupported
+}
+
+func (s *localSandboxes) kill(containerName string) error {
+	return errLocalUnsupported
+}
+
+func (s *localSandboxes) signal(p phase, signal string) error {
+	return errLocalUnsupported
+}
+
+// RunSandboxInit returns at once, as no sandbox is started here
+func RunSandboxInit() {}
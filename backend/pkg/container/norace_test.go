@@ -0,0 +1,7 @@
+//go:build !race
+
+package container
+
+// raceSlack is added to the deadlines of tests that wait on helper
+// processes, which take seconds to start and exit under the race detector
+const raceSlack = 0
@@ -0,0 +1,280 @@
+// warm container pool
+package container
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/config"
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// poolPrefix starts the names of the containers and volumes of the pool
+const poolPrefix = "code-pool-"
+
+// slot holds the idle containers ready for one execution of a language.
+// Compiled languages get a compile and a run container sharing a volume.
+type slot struct {
+	run     string
+	compile string
+	volume  string
+	created time.Time
+}
+
+// PoolStats reports how executions were served by the pool
+type PoolStats struct {
+	Enabled bool `json:"enabled"`
+
+	// Hits and Misses count executions that did and did not find a warm
+	// container
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+
+	// Created, Expired and Failed count slots started, replaced after
+	// waiting too long and failed to start
+	Created uint64 `json:"created"`
+	Expired uint64 `json:"expired"`
+	Failed  uint64 `json:"failed"`
+
+	// Idle is the number of warm slots by language id
+	Idle map[string]int `json:"idle"`
+}
+
+// pool keeps warm containers per language. A slot is handed out once and
+// destroyed after the execution; containers are never reused.
+type pool struct {
+	config.Pool
+
+	mu    sync.Mutex
+	idle  map[string][]slot
+	stats PoolStats
+}
+
+// WithPool keeps warm containers ready so executions skip the container
+// start. A size of 0 disables the pool.
+func WithPool(cfg config.Pool) Option {
+	return func(d *DockerRunner) {
+		if cfg.Size <= 0 {
+			d.pool = nil
+			return
+		}
+		d.pool = &pool{Pool: cfg, idle: make(map[string][]slot)}
+	}
+}
+
+// take hands out a warm slot for the language, if there is one
+func (p *pool) take(languageID string) (slot, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	slots := p.idle[languageID]
+	if len(slots) == 0 {
+		p.stats.Misses++
+		return slot{}, false
+	}
+	s := slots[0]
+	p.idle[languageID] = slots[1:]
+	p.stats.Hits++
+	return s, true
+}
+
+// miss counts an execution that could not use the pool
+func (p *pool) miss() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Misses++
+}
+
+// put adds a started slot to the pool
+func (p *pool) put(languageID string, s slot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle[languageID] = append(p.idle[languageID], s)
+	p.stats.Created++
+}
+
+// failed counts a slot that could not be started
+func (p *pool) failed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Failed++
+}
+
+// expire removes and returns the slots idle for longer than MaxIdle
+func (p *pool) expire(now time.Time) []slot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var expired []slot
+	for id, slots := range p.idle {
+		// Slots are kept oldest first
+		n := 0
+		for n < len(slots) && now.Sub(slots[n].created) > p.MaxIdle {
+			n++
+		}
+		expired = append(expired, slots[:n]...)
+		p.idle[id] = slots[n:]
+	}
+	p.stats.Expired += uint64(len(expired))
+	return expired
+}
+
+// neediest returns the language with the fewest idle slots, if any is
+// below the pool size
+func (p *pool) neediest(langs []language.Language) (language.Language, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best, found := language.Language{}, false
+	for _, lang := range langs {
+		n := len(p.idle[lang.ID])
+		if n < p.Size && (!found || n < len(p.idle[best.ID])) {
+			best, found = lang, true
+		}
+	}
+	return best, found
+}
+
+// drain removes and returns every idle slot
+func (p *pool) drain() []slot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var slots []slot
+	for id, idle := range p.idle {
+		slots = append(slots, idle...)
+		delete(p.idle, id)
+	}
+	return slots
+}
+
+// snapshot returns a copy of the stats with the current idle counts
+func (p *pool) snapshot() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	stats.Enabled = true
+	stats.Idle = make(map[string]int, len(p.idle))
+	for id, slots := range p.idle {
+		stats.Idle[id] = len(slots)
+	}
+	return stats
+}
+
+// PoolStats reports the pool's hits, misses and idle containers
+func (d *DockerRunner) PoolStats() PoolStats {
+	if d.pool == nil {
+		return PoolStats{}
+	}
+	return d.pool.snapshot()
+}
+
+// MaintainPool fills the pool at the refill rate and replaces containers
+// idle for too long, until ctx is done. Idle containers are then
+// destroyed. It returns at once when the pool is disabled.
+func (d *DockerRunner) MaintainPool(ctx context.Context) {
+	if d.pool == nil {
+		return
+	}
+	// Containers left by an earlier process would never be handed out
+	d.backend.removeStalePool()
+
+	ticker := time.NewTicker(d.pool.RefillInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, s := range d.pool.drain() {
+				d.destroySlot(s)
+			}
+			return
+		case <-ticker.C:
+			d.refillPool(ctx)
+		}
+	}
+}
+
+// refillPool destroys expired slots and starts one slot for the language
+// that has the fewest
+func (d *DockerRunner) refillPool(ctx context.Context) {
+	for _, s := range d.pool.expire(time.Now()) {
+		d.destroySlot(s)
+	}
+
+	lang, ok := d.pool.neediest(d.languages.Languages())
+	if !ok {
+		return
+	}
+	s, err := d.startSlot(ctx, &lang)
+	if err != nil {
+		d.pool.failed()
+		return
+	}
+	d.pool.put(lang.ID, s)
+}
+
+// startSlot starts idle containers for the language with the limits of
+// its phases. They wait for docker exec without network access.
+func (d *DockerRunner) startSlot(ctx context.Context, lang *language.Language) (slot, error) {
+	name := fmt.Sprintf("%s%s-%d", poolPrefix, lang.ID, time.Now().UnixNano())
+	s := slot{run: name, created: time.Now()}
+	runLimits, compileLimits := d.limits.Phases(lang)
+	spec := containerSpec{image: d.image(lang), command: []string{"sleep", "infinity"}}
+
+	if len(lang.Compile) > 0 {
+		s.compile = name + "-compile"
+		s.volume = name + "-src"
+		spec.volume = s.volume
+		if err := d.backend.createVolume(ctx, s.volume); err != nil {
+			d.destroySlot(s)
+			return slot{}, err
+		}
+		spec.limits = compileLimits
+		if err := d.startIdle(ctx, s.compile, spec); err != nil {
+			d.destroySlot(s)
+			return slot{}, err
+		}
+	}
+	spec.limits = runLimits
+	if err := d.startIdle(ctx, s.run, spec); err != nil {
+		d.destroySlot(s)
+		return slot{}, err
+	}
+	return s, nil
+}
+
+// startIdle starts a detached container that sleeps until it is removed
+func (d *DockerRunner) startIdle(ctx context.Context, name string, spec containerSpec) error {
+	if d.seccompErr != nil {
+		return d.seccompErr
+	}
+	return d.backend.startIdle(ctx, name, spec)
+}
+
+// destroySlot removes the containers of a slot, then its volume
+func (d *DockerRunner) destroySlot(s slot) {
+	d.backend.remove(s.run)
+	if s.compile != "" {
+		d.backend.remove(s.compile)
+	}
+	if s.volume != "" {
+		d.backend.removeVolume(s.volume)
+	}
+}
+
+// takeSlot hands out warm containers for the request. Requests that set
+// their own limits need containers started with them.
+func (d *DockerRunner) takeSlot(languageID string, req executor.ExecRequest) (slot, bool) {
+	if d.pool == nil {
+		return slot{}, false
+	}
+	if req.Limits != (language.Limits{}) {
+		d.pool.miss()
+		return slot{}, false
+	}
+	return d.pool.take(languageID)
+}
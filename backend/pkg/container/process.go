@@ -0,0 +1,70 @@
+// program processes started with the docker CLI, the Engine API or in a
+// local sandbox
+package container
+
+import (
+	"context"
+	"io"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// process is the running program of a phase
+type process struct {
+	stdin  io.WriteCloser
+	stdout io.Reader
+	stderr io.Reader
+
+	// wait blocks until the program exits. It must be called once stdout
+	// and stderr have been read, and fails only when the program's exit
+	// cannot be observed.
+	wait func() error
+
+	// result reports how the program terminated, once wait has returned
+	result func() executor.ExecutionResult
+}
+
+// backend drives the containers of a runner: the docker CLI, the
+// Engine API or local sandboxes
+type backend interface {
+	// start starts the program of a phase in a new container, or in its
+	// pooled container
+	start(ctx context.Context, p phase) (*process, error)
+
+	// kill stops the program of a container at once
+	kill(containerName string) error
+
+	// signal sends a signal, named without its SIG prefix, to the program
+	// of a phase
+	signal(p phase, signal string) error
+
+	// inspect reads the exit state of a stopped container
+	inspect(containerName string) (containerState, error)
+
+	// remove deletes a container once its state has been read
+	remove(containerName string)
+
+	// createVolume creates the volume shared by the phases of a run, a
+	// tmpfs bounded like the sandbox directory
+	createVolume(ctx context.Context, name string) error
+
+	// removeVolume deletes the volume shared by the phases of a run
+	removeVolume(name string)
+
+	// startIdle starts a detached pooled container that sleeps until it
+	// is removed
+	startIdle(ctx context.Context, name string, spec containerSpec) error
+
+	// removeStalePool removes the containers and volumes of the pool
+	removeStalePool()
+
+	// probeVersion runs the version command of a language
+	probeVersion(ctx context.Context, lang *language.Language) (string, error)
+}
+
+// killAllCommand signals every process of a pooled container but its main
+// process, which only keeps the container alive
+func killAllCommand(signal string) string {
+	return "kill -s " + signal + " -1"
+}
@@ -0,0 +1,9 @@
+//go:build race
+
+package container
+
+import "time"
+
+// raceSlack is added to the deadlines of tests that wait on helper
+// processes, which take seconds to start and exit under the race detector
+const raceSlack = 30 * time.Second
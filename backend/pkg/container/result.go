@@ -0,0 +1,120 @@
+// exit status reporting
+package container
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+)
+
+// signalExitBase is added to the signal number by the container runtime
+// when the main process is killed by a signal
+const signalExitBase = 128
+
+// signalNames maps signal numbers to the names reported to clients
+var signalNames = map[int]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	6:  "SIGABRT",
+	7:  "SIGBUS",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	10: "SIGUSR1",
+	11: "SIGSEGV",
+	12: "SIGUSR2",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+	24: "SIGXCPU",
+	25: "SIGXFSZ",
+}
+
+// containerState is the part of docker inspect output we report
+type containerState struct {
+	ExitCode  int
+	OOMKilled bool
+}
+
+// exitResult reports how the container's program terminated. The state
+// from docker inspect is preferred; the exit status of the docker CLI, if
+// cmd is set, is used when the container cannot be inspected.
+func (d *DockerRunner) exitResult(containerName string, cmd *exec.Cmd) executor.ExecutionResult {
+	var result executor.ExecutionResult
+
+	state, err := d.backend.inspect(containerName)
+	switch {
+	case err == nil:
+		result.ExitCode = state.ExitCode
+		result.OOMKilled = state.OOMKilled
+	case cmd != nil && cmd.ProcessState != nil:
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	default:
+		result.ExitCode = -1
+	}
+
+	return withSignal(result)
+}
+
+// execResult reports how a program started with docker exec in a pooled
+// container terminated, given the exit code of the exec or -1 if it is
+// unknown. The container outlives the program, so it is only inspected for
+// OOM kills and for the status left when the exec itself was killed.
+func (d *DockerRunner) execResult(containerName string, exitCode int) executor.ExecutionResult {
+	result := executor.ExecutionResult{ExitCode: exitCode}
+
+	if state, err := d.backend.inspect(containerName); err == nil {
+		result.OOMKilled = state.OOMKilled
+		if result.ExitCode == -1 {
+			result.ExitCode = state.ExitCode
+		}
+	}
+
+	return withSignal(result)
+}
+
+// phaseResult reports how the program of a phase terminated
+func (d *DockerRunner) phaseResult(p phase, cmd *exec.Cmd) executor.ExecutionResult {
+	if p.pooled {
+		// docker exec exits with the program's status
+		exitCode := -1
+		if cmd.ProcessState != nil {
+			exitCode = cmd.ProcessState.ExitCode()
+		}
+		return d.execResult(p.container, exitCode)
+	}
+	return d.exitResult(p.container, cmd)
+}
+
+// withSignal names the signal that killed the program, if any
+func withSignal(result executor.ExecutionResult) executor.ExecutionResult {
+	if code := result.ExitCode - signalExitBase; code > 0 {
+		if name, ok := signalNames[code]; ok {
+			result.Signal = name
+		}
+	}
+	return result
+}
+
+// parseContainerState parses the "<exit code> <oom killed>" inspect format
+func parseContainerState(s string) (containerState, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return containerState{}, fmt.Errorf("unexpected container state %q", s)
+	}
+
+	exitCode, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return containerState{}, fmt.Errorf("invalid exit code %q: %w", fields[0], err)
+	}
+	oomKilled, err := strconv.ParseBool(fields[1])
+	if err != nil {
+		return containerState{}, fmt.Errorf("invalid OOM flag %q: %w", fields[1], err)
+	}
+
+	return containerState{ExitCode: exitCode, OOMKilled: oomKilled}, nil
+}
@@ -0,0 +1,250 @@
+//go:build amd64 || arm64
+
+// sandbox init, run in the namespaces of a new sandbox
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Descriptors the sandbox init receives from the runner
+const (
+	sandboxSpecFD  = 3
+	sandboxErrorFD = 4
+)
+
+// sandboxHostname is the hostname programs see
+const sandboxHostname = "sandbox"
+
+// sandboxDevices are bound from the host into the sandbox's /dev
+var sandboxDevices = []string{"null", "zero", "full", "random", "urandom"}
+
+// RunSandboxInit sets up the sandbox and executes the program when the
+// process was started by a LocalRunner as the init of a sandbox, and then
+// never returns. Otherwise it returns at once. Binaries that create a
+// LocalRunner call it first thing in main.
+func RunSandboxInit() {
+	if os.Args[0] != sandboxInitArg {
+		return
+	}
+	// Thread attributes such as the seccomp filter must be set on the
+	// thread that executes the program
+	runtime.LockOSThread()
+
+	errPipe := os.NewFile(sandboxErrorFD, "error")
+	syscall.CloseOnExec(sandboxErrorFD)
+	err := runSandbox(os.NewFile(sandboxSpecFD, "spec"))
+	fmt.Fprint(errPipe, err)
+	os.Exit(1)
+}
+
+// runSandbox sets up the sandbox and executes the program. It returns
+// only on failure.
+func runSandbox(specFile *os.File) error {
+	var spec sandboxSpec
+	if err := json.NewDecoder(specFile).Decode(&spec); err != nil {
+		return fmt.Errorf("error reading spec: %w", err)
+	}
+	specFile.Close()
+	if len(spec.Command) == 0 {
+		return fmt.Errorf("no command")
+	}
+
+	if err := mountRootfs(spec); err != nil {
+		return err
+	}
+	if err := unix.Sethostname([]byte(sandboxHostname)); err != nil {
+		return fmt.Errorf("error setting hostname: %w", err)
+	}
+	if err := os.Chdir(sandboxDir); err != nil {
+		return err
+	}
+	path, err := lookPath(spec.Command[0], spec.Env)
+	if err != nil {
+		return err
+	}
+
+	ulimits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_NPROC, uint64(spec.Ulimits.Nproc)},
+		{unix.RLIMIT_NOFILE, uint64(spec.Ulimits.Nofile)},
+		{unix.RLIMIT_FSIZE, uint64(spec.Ulimits.Fsize)},
+	}
+	for _, l := range ulimits {
+		// syscall.Setrlimit keeps the nofile limit from being restored
+		// when the program is executed
+		if err := syscall.Setrlimit(l.resource, &syscall.Rlimit{Cur: l.value, Max: l.value}); err != nil {
+			return fmt.Errorf("error setting rlimit %d: %w", l.resource, err)
+		}
+	}
+
+	if err := dropPrivileges(spec); err != nil {
+		return err
+	}
+	if err := installSeccomp(); err != nil {
+		return err
+	}
+	return syscall.Exec(path, spec.Command, spec.Env)
+}
+
+// mountRootfs builds the sandbox's root filesystem and pivots into it.
+// The rootfs is overlaid by a tmpfs that disappears with the sandbox;
+// the sandbox directory is the run's volume when it has one. Host mounts
+// under the rootfs, such as its /proc, are not carried over.
+func mountRootfs(spec sandboxSpec) error {
+	// Nothing mounted here propagates back to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("error making mounts private: %w", err)
+	}
+
+	tmpfs := "mode=0755,size=" + spec.TmpfsSize
+	if err := unix.Mount("tmpfs", spec.Scratch, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, tmpfs); err != nil {
+		return fmt.Errorf("error mounting scratch tmpfs: %w", err)
+	}
+	upper, work, root := filepath.Join(spec.Scratch, "upper"), filepath.Join(spec.Scratch, "work"), filepath.Join(spec.Scratch, "root")
+	for _, dir := range []string{upper, work, root} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	overlay := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", spec.Rootfs, upper, work)
+	if err := unix.Mount("overlay", root, "overlay", 0, overlay); err != nil {
+		return fmt.Errorf("error mounting rootfs overlay: %w", err)
+	}
+
+	sandbox := filepath.Join(root, sandboxDir)
+	if err := os.MkdirAll(sandbox, 0o755); err != nil {
+		return err
+	}
+	if spec.Volume != "" {
+		if err := unix.Mount(spec.Volume, sandbox, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("error mounting volume: %w", err)
+		}
+	} else if !spec.UserNamespace {
+		if err := os.Chown(sandbox, spec.UID, spec.GID); err != nil {
+			return err
+		}
+	}
+
+	mounts := []struct {
+		target, fstype, data string
+		flags                uintptr
+	}{
+		{"proc", "proc", "", unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC},
+		{"tmp", "tmpfs", "mode=1777,size=" + spec.TmpfsSize, unix.MS_NOSUID | unix.MS_NODEV},
+		{"dev", "tmpfs", "mode=0755", unix.MS_NOSUID | unix.MS_NOEXEC},
+	}
+	for _, m := range mounts {
+		target := filepath.Join(root, m.target)
+		if err := os.MkdirAll(target, 0o755); err != nil {
+			return err
+		}
+		if err := unix.Mount(m.fstype, target, m.fstype, m.flags, m.data); err != nil {
+			return fmt.Errorf("error mounting /%s: %w", m.target, err)
+		}
+	}
+	if err := populateDev(filepath.Join(root, "dev")); err != nil {
+		return err
+	}
+
+	old := filepath.Join(root, ".oldroot")
+	if err := os.Mkdir(old, 0o700); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(root, old); err != nil {
+		return fmt.Errorf("error pivoting root: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := unix.Unmount("/.oldroot", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("error unmounting host root: %w", err)
+	}
+	return os.Remove("/.oldroot")
+}
+
+// populateDev binds the devices programs need into dev, with the usual
+// links to the standard streams
+func populateDev(dev string) error {
+	for _, name := range sandboxDevices {
+		target := filepath.Join(dev, name)
+		if err := os.WriteFile(target, nil, 0o666); err != nil {
+			return err
+		}
+		if err := unix.Mount("/dev/"+name, target, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("error mounting /dev/%s: %w", name, err)
+		}
+	}
+	links := map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropPrivileges empties the capability bounding set, so the program
+// gets no capabilities even as root in a user namespace, and switches to
+// the program's user otherwise
+func dropPrivileges(spec sandboxSpec) error {
+	for c := 0; c <= unix.CAP_LAST_CAP; c++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return fmt.Errorf("error dropping capability %d: %w", c, err)
+		}
+	}
+	if spec.UserNamespace {
+		return nil
+	}
+
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("error clearing groups: %w", err)
+	}
+	if err := syscall.Setresgid(spec.GID, spec.GID, spec.GID); err != nil {
+		return fmt.Errorf("error setting gid: %w", err)
+	}
+	if err := syscall.Setresuid(spec.UID, spec.UID, spec.UID); err != nil {
+		return fmt.Errorf("error setting uid: %w", err)
+	}
+	// Changing user cleared the parent death signal
+	if err := unix.Prctl(unix.PR_SET_PDEATHSIG, uintptr(unix.SIGKILL), 0, 0, 0); err != nil {
+		return fmt.Errorf("error setting parent death signal: %w", err)
+	}
+	return nil
+}
+
+// lookPath finds the program in the PATH of its environment, once the
+// sandbox root is in place
+func lookPath(name string, env []string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	var path string
+	for _, v := range env {
+		if p, ok := strings.CutPrefix(v, "PATH="); ok {
+			path = p
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		file := filepath.Join(dir, name)
+		if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in PATH", name)
+}
@@ -0,0 +1,8 @@
+//go:build linux
+
+package container
+
+import "golang.org/x/sys/unix"
+
+// auditArch identifies the system calls of this architecture to seccomp
+const auditArch = unix.AUDIT_ARCH_AARCH64
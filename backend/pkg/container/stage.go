@@ -0,0 +1,72 @@
+// staging of user source files into the sandbox
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sandboxDir is the writable working directory inside the container
+const sandboxDir = "/sandbox/tmp"
+
+// buildArchive packs the files into a tar stream. The stream is written to
+// the container's stdin ahead of any user input, so the source never passes
+// through a shell.
+func buildArchive(files map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+
+	for _, name := range names {
+		content := files[name]
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(content)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("error writing archive header for %s: %w", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return nil, fmt.Errorf("error writing archive entry for %s: %w", name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("error closing archive: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// extractCommand reads exactly size bytes of tar stream from stdin and
+// unpacks them into the working directory. head -c stops reading at the
+// archive boundary, leaving the rest of stdin for the program.
+func extractCommand(size int) string {
+	return fmt.Sprintf("head -c %d | tar -x -f -", size)
+}
+
+// shellQuote quotes s for safe use as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes every argument and joins them into a command line
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,212 @@
+// program output streaming
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// Output streaming defaults
+const (
+	defaultFlushWindow  = 20 * time.Millisecond
+	defaultMaxChunkSize = 64 * 1024
+	readBufferSize      = 4096
+)
+
+// outputStream tags program output with its stream and the time since the
+// program started. Sends are serialized so the order of messages on the
+// output channel matches the order of their timestamps.
+type outputStream struct {
+	output       chan<- executor.Output
+	start        time.Time
+	flushWindow  time.Duration
+	maxChunkSize int
+	mu           sync.Mutex
+
+	// budget bounds the output forwarded from both streams; nil forwards
+	// everything
+	budget *outputBudget
+}
+
+func newOutputStream(output chan<- executor.Output, flushWindow time.Duration, maxChunkSize int) *outputStream {
+	return &outputStream{
+		output:       output,
+		start:        time.Now(),
+		flushWindow:  flushWindow,
+		maxChunkSize: maxChunkSize,
+	}
+}
+
+// read forwards r to the output channel in chunks until EOF. Bytes are held
+// for at most the flush window, so prompts without a trailing newline still
+// appear promptly, and no chunk is larger than maxChunkSize however long
+// the line. Output past the budget is dropped.
+func (s *outputStream) read(wg *sync.WaitGroup, ctx context.Context, outputType executor.OutputType, r io.Reader) {
+	defer wg.Done()
+
+	chunks := make(chan []byte)
+	go readChunks(ctx, r, chunks)
+
+	var pending []byte
+	var flush <-chan time.Time
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if len(pending) > 0 {
+					s.send(ctx, outputType, string(pending))
+				}
+				return
+			}
+			n, notice := s.budget.take(chunk)
+			pending = append(pending, chunk[:n]...)
+			for len(pending) >= s.maxChunkSize {
+				if !s.send(ctx, outputType, string(pending[:s.maxChunkSize])) {
+					return
+				}
+				pending = pending[s.maxChunkSize:]
+			}
+			if notice != "" {
+				// The output that fit goes out before the notice. What the
+				// program writes until it is killed is dropped.
+				if len(pending) > 0 && !s.send(ctx, outputType, string(pending)) {
+					return
+				}
+				pending, flush = nil, nil
+				s.send(ctx, executor.OutputStatus, notice)
+				close(s.budget.exceeded)
+				continue
+			}
+			if len(pending) > 0 && flush == nil {
+				flush = time.After(s.flushWindow)
+			}
+		case <-flush:
+			flush = nil
+			if len(pending) == 0 {
+				continue
+			}
+			if !s.send(ctx, outputType, string(pending)) {
+				return
+			}
+			pending = nil
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readChunks copies everything read from r onto chunks, closing it at EOF
+func readChunks(ctx context.Context, r io.Reader, chunks chan<- []byte) {
+	defer close(chunks)
+
+	buf := make([]byte, readBufferSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send delivers one chunk of output, reporting false if ctx ended first
+func (s *outputStream) send(ctx context.Context, outputType executor.OutputType, data string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := executor.Output{Type: outputType, Data: data}
+	if outputType != executor.OutputStatus {
+		out.Elapsed = time.Since(s.start)
+	}
+	select {
+	case s.output <- out:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// outputBudget is what a program may still write to stdout and stderr
+// together, from the output limits of its phase. Zero limits are unlimited.
+type outputBudget struct {
+	maxBytes int64
+	maxLines int
+
+	mu    sync.Mutex
+	bytes int64
+	lines int
+	spent bool
+
+	// exceeded is closed once the program wrote more than its budget
+	exceeded chan struct{}
+}
+
+func newOutputBudget(limits language.Limits) *outputBudget {
+	return &outputBudget{
+		maxBytes: limits.OutputBytes,
+		maxLines: limits.OutputLines,
+		exceeded: make(chan struct{}),
+	}
+}
+
+// take returns how much of chunk fits the budget. The chunk that exceeds
+// it also gets the truncation notice to send; nothing fits afterwards.
+func (b *outputBudget) take(chunk []byte) (int, string) {
+	if b == nil {
+		return len(chunk), ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.spent {
+		return 0, ""
+	}
+
+	n, notice := len(chunk), ""
+	if b.maxBytes > 0 && b.bytes+int64(n) > b.maxBytes {
+		n = int(b.maxBytes - b.bytes)
+		notice = fmt.Sprintf("output truncated after %d bytes", b.maxBytes)
+	}
+	if b.maxLines > 0 {
+		lines := b.lines
+		for i, c := range chunk[:n] {
+			if lines == b.maxLines {
+				// Anything after the last line allowed exceeds it
+				n, notice = i, fmt.Sprintf("output truncated after %d lines", b.maxLines)
+				break
+			}
+			if c == '\n' {
+				lines++
+			}
+		}
+	}
+
+	b.bytes += int64(n)
+	b.lines += bytes.Count(chunk[:n], []byte{'\n'})
+	b.spent = notice != ""
+	return n, notice
+}
+
+// truncated reports whether the program wrote more than its budget
+func (b *outputBudget) truncated() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.spent
+}
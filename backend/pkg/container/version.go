@@ -0,0 +1,115 @@
+// toolchain version probes
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/language"
+)
+
+// versionTimeout bounds each version probe, image pull excluded
+const versionTimeout = 30 * time.Second
+
+// ProbeVersions runs the version command of every language in its image,
+// or in a sandbox, and returns the first line printed, keyed by language id. Languages
+// whose probe fails are left out and reported in the error.
+func (r *phaseRunner) ProbeVersions(ctx context.Context) (map[string]string, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		versions = make(map[string]string)
+		errs     []error
+	)
+
+	for _, lang := range r.languages.Languages() {
+		if len(lang.Version) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(lang language.Language) {
+			defer wg.Done()
+			version, err := r.probeVersion(ctx, &lang)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("language %s: %w", lang.ID, err))
+				return
+			}
+			versions[lang.ID] = version
+		}(lang)
+	}
+	wg.Wait()
+
+	return versions, errors.Join(errs...)
+}
+
+// probeVersion runs the version command of one language
+func (r *phaseRunner) probeVersion(ctx context.Context, lang *language.Language) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+
+	return r.backend.probeVersion(ctx, lang)
+}
+
+// probeVersionDirect runs the version command in image through the Engine
+// API or in a local sandbox, with the language's run limits
+func (r *phaseRunner) probeVersionDirect(ctx context.Context, lang *language.Language, image string) (string, error) {
+	runLimits, _ := r.limits.Phases(lang)
+	p := phase{
+		container: fmt.Sprintf("code-version-%s-%d", lang.ID, time.Now().UnixNano()),
+		spec:      containerSpec{image: image, command: lang.Version, limits: runLimits},
+	}
+	proc, err := r.backend.start(ctx, p)
+	if err != nil {
+		return "", fmt.Errorf("error probing version: %w", err)
+	}
+	defer r.backend.remove(p.container)
+	proc.stdin.Close()
+
+	out := &cappedBuffer{limit: maxCompileOutput}
+	done := make(chan error, 1)
+	go func() {
+		var readers sync.WaitGroup
+		readers.Add(2)
+		for _, stream := range []io.Reader{proc.stdout, proc.stderr} {
+			go func(stream io.Reader) {
+				defer readers.Done()
+				io.Copy(out, stream)
+			}(stream)
+		}
+		readers.Wait()
+		done <- proc.wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		r.backend.kill(p.container)
+		<-done
+		return "", fmt.Errorf("error probing version: %w", ctx.Err())
+	case err := <-done:
+		if err != nil {
+			return "", fmt.Errorf("error probing version: %w", err)
+		}
+	}
+	if result := proc.result(); result.ExitCode != 0 {
+		return "", fmt.Errorf("error probing version: exit status %d", result.ExitCode)
+	}
+	return firstLine(out.String())
+}
+
+// firstLine returns the first non-empty line printed by a version command
+func firstLine(out string) (string, error) {
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("version command printed nothing")
+}
@@ -0,0 +1,173 @@
+// Package engine is a small client for the Docker Engine HTTP API. It
+// covers what the runner needs to drive containers without the docker CLI:
+// create, attach, start, wait, inspect, kill, remove, exec and stats.
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// APIVersion is the Engine API version requested, supported by Docker 20.10
+// and later
+const APIVersion = "v1.41"
+
+// DefaultHost is the Engine socket used when DOCKER_HOST is not set
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Client talks to the Engine API over a unix socket
+type Client struct {
+	socket string
+	http   *http.Client
+}
+
+// NewClient creates a client for host, a unix:// address as in DOCKER_HOST
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = DefaultHost
+	}
+	socket, ok := strings.CutPrefix(host, "unix://")
+	if !ok || socket == "" {
+		return nil, fmt.Errorf("unsupported docker host %q: only unix sockets are supported", host)
+	}
+
+	c := &Client{socket: socket}
+	c.http = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return c.dial(ctx)
+			},
+		},
+	}
+	return c, nil
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", c.socket)
+}
+
+// Error is returned for responses with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("docker engine: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is a response for a missing object
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// do sends a request and decodes the JSON response into out, if not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker engine: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("docker engine: error decoding response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	u := "http://docker/" + APIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// checkResponse turns an error status into an *Error
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &body) != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(data))
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: body.Message}
+}
+
+// hijack sends a request that upgrades the connection to a raw stream, as
+// attach and exec start do
+func (c *Client) hijack(ctx context.Context, path string, query url.Values, body interface{}) (*Stream, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("docker engine: %w", err)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("docker engine: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("docker engine: %w", err)
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("docker engine: unexpected status %d for %s", resp.StatusCode, path)
+	}
+	return &Stream{conn: conn, reader: br}, nil
+}
@@ -0,0 +1,312 @@
+package engine_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tiakavousi/codeplayground/pkg/engine"
+	"github.com/tiakavousi/codeplayground/pkg/engine/enginetest"
+)
+
+// echoProgram upper-cases stdin to stdout, warns on stderr and exits with
+// the status named by its command, or is OOM killed
+func echoProgram(ctx context.Context, cmd []string, stdin io.Reader, stdout, stderr io.Writer) engine.State {
+	if len(cmd) > 0 && cmd[0] == "sleep" {
+		<-ctx.Done()
+		return engine.State{ExitCode: 137}
+	}
+	fmt.Fprintln(stderr, "warning")
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		fmt.Fprintln(stdout, strings.ToUpper(scanner.Text()))
+	}
+	if len(cmd) > 1 && cmd[0] == "oom" {
+		return engine.State{ExitCode: 137, OOMKilled: true}
+	}
+	if len(cmd) > 1 && cmd[0] == "exit" {
+		var code int
+		fmt.Sscan(cmd[1], &code)
+		return engine.State{ExitCode: code}
+	}
+	return engine.State{}
+}
+
+func newClient(t *testing.T) (*engine.Client, *enginetest.Server) {
+	t.Helper()
+	server := enginetest.NewServer(t)
+	server.Program = echoProgram
+	client, err := engine.NewClient(server.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{host: ""},
+		{host: "unix:///var/run/docker.sock"},
+		{host: "tcp://127.0.0.1:2375", wantErr: true},
+		{host: "unix://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if _, err := engine.NewClient(tt.host); (err != nil) != tt.wantErr {
+			t.Errorf("NewClient(%q) error = %v, wantErr %v", tt.host, err, tt.wantErr)
+		}
+	}
+}
+
+// runAttached creates, attaches to and starts a container running cmd,
+// writes input and returns its output and exit code
+func runAttached(t *testing.T, client *engine.Client, name string, cmd []string, input string) (string, string, int) {
+	t.Helper()
+	ctx := context.Background()
+
+	cfg := engine.ContainerConfig{Image: "tayebe/repl", Cmd: cmd, AttachStdin: true, AttachStdout: true, AttachStderr: true, OpenStdin: true}
+	if _, err := client.CreateContainer(ctx, name, cfg); err != nil {
+		t.Fatalf("CreateContainer() error = %v", err)
+	}
+	stream, err := client.AttachContainer(ctx, name)
+	if err != nil {
+		t.Fatalf("AttachContainer() error = %v", err)
+	}
+	defer stream.Close()
+	if err := client.StartContainer(ctx, name); err != nil {
+		t.Fatalf("StartContainer() error = %v", err)
+	}
+
+	io.WriteString(stream, input)
+	stream.CloseWrite()
+	var stdout, stderr bytes.Buffer
+	if err := stream.Demux(&stdout, &stderr); err != nil {
+		t.Fatalf("Demux() error = %v", err)
+	}
+
+	code, err := client.WaitContainer(ctx, name)
+	if err != nil {
+		t.Fatalf("WaitContainer() error = %v", err)
+	}
+	return stdout.String(), stderr.String(), code
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	client, server := newClient(t)
+	ctx := context.Background()
+
+	stdout, stderr, code := runAttached(t, client, "code-exec-1", []string{"exit", "3"}, "hello\nworld\n")
+	if stdout != "HELLO\nWORLD\n" || stderr != "warning\n" {
+		t.Errorf("output = %q, %q", stdout, stderr)
+	}
+	if code != 3 {
+		t.Errorf("WaitContainer() = %d, want 3", code)
+	}
+
+	state, err := client.InspectContainer(ctx, "code-exec-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != (engine.State{ExitCode: 3}) {
+		t.Errorf("InspectContainer() = %+v", state)
+	}
+
+	if err := client.RemoveContainer(ctx, "code-exec-1"); err != nil {
+		t.Fatalf("RemoveContainer() error = %v", err)
+	}
+	if _, err := client.InspectContainer(ctx, "code-exec-1"); !engine.IsNotFound(err) {
+		t.Errorf("InspectContainer() after remove error = %v, want not found", err)
+	}
+
+	want := []string{
+		"POST /containers/create",
+		"POST /containers/code-exec-1/attach",
+		"POST /containers/code-exec-1/start",
+		"POST /containers/code-exec-1/wait",
+		"GET /containers/code-exec-1/json",
+		"DELETE /containers/code-exec-1",
+		"GET /containers/code-exec-1/json",
+	}
+	if got := server.Requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestContainerOOMKilled(t *testing.T) {
+	client, _ := newClient(t)
+
+	_, _, code := runAttached(t, client, "code-exec-oom", []string{"oom", "now"}, "")
+	state, err := client.InspectContainer(context.Background(), "code-exec-oom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 137 || !state.OOMKilled {
+		t.Errorf("exit = %d, state = %+v, want OOM kill", code, state)
+	}
+}
+
+func TestKillContainer(t *testing.T) {
+	client, server := newClient(t)
+	ctx := context.Background()
+
+	if _, err := client.CreateContainer(ctx, "code-exec-2", engine.ContainerConfig{Cmd: []string{"sleep", "infinity"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StartContainer(ctx, "code-exec-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.KillContainer(ctx, "code-exec-2", "SIGTERM"); err != nil {
+		t.Fatalf("KillContainer() error = %v", err)
+	}
+
+	code, err := client.WaitContainer(ctx, "code-exec-2")
+	if err != nil || code != 143 {
+		t.Errorf("WaitContainer() = %d, %v, want 143", code, err)
+	}
+	if c, _ := server.Container("code-exec-2"); !reflect.DeepEqual(c.Signals, []string{"SIGTERM"}) {
+		t.Errorf("signals = %v", c.Signals)
+	}
+
+	var apiErr *engine.Error
+	err = client.KillContainer(ctx, "code-exec-2", "SIGKILL")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("KillContainer() on stopped container error = %v, want conflict", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	if _, err := client.CreateContainer(ctx, "code-pool-1", engine.ContainerConfig{Cmd: []string{"sleep", "infinity"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StartContainer(ctx, "code-pool-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := client.CreateExec(ctx, "code-pool-1", engine.ExecConfig{Cmd: []string{"exit", "5"}, AttachStdin: true, AttachStdout: true, AttachStderr: true})
+	if err != nil {
+		t.Fatalf("CreateExec() error = %v", err)
+	}
+	stream, err := client.StartExec(ctx, id)
+	if err != nil {
+		t.Fatalf("StartExec() error = %v", err)
+	}
+	io.WriteString(stream, "pooled\n")
+	stream.CloseWrite()
+	var stdout, stderr bytes.Buffer
+	if err := stream.Demux(&stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if stdout.String() != "POOLED\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+
+	state, err := client.InspectExec(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Running || state.ExitCode != 5 {
+		t.Errorf("InspectExec() = %+v, want exit code 5", state)
+	}
+
+	// The container outlives the exec instance
+	if state, err := client.InspectContainer(ctx, "code-pool-1"); err != nil || !state.Running {
+		t.Errorf("InspectContainer() = %+v, %v, want running", state, err)
+	}
+}
+
+func TestContainerStats(t *testing.T) {
+	client, server := newClient(t)
+	ctx := context.Background()
+	server.Stats = engine.Stats{MemoryUsage: 1 << 20, MemoryMaxUsage: 2 << 20, CPUUsage: 5e6, Pids: 2}
+
+	client.CreateContainer(ctx, "code-exec-3", engine.ContainerConfig{Cmd: []string{"sleep", "infinity"}})
+	client.StartContainer(ctx, "code-exec-3")
+
+	stats, err := client.ContainerStats(ctx, "code-exec-3")
+	if err != nil {
+		t.Fatalf("ContainerStats() error = %v", err)
+	}
+	if stats != server.Stats {
+		t.Errorf("ContainerStats() = %+v, want %+v", stats, server.Stats)
+	}
+}
+
+func TestListAndRemove(t *testing.T) {
+	client, server := newClient(t)
+	ctx := context.Background()
+
+	client.CreateContainer(ctx, "code-pool-python-1", engine.ContainerConfig{HostConfig: engine.HostConfig{Binds: []string{"code-pool-python-1-src:/sandbox/tmp"}}})
+	client.CreateContainer(ctx, "code-exec-4", engine.ContainerConfig{})
+
+	ids, err := client.ListContainers(ctx, "code-pool-")
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("ListContainers() = %v, %v, want one container", ids, err)
+	}
+	volumes, err := client.ListVolumes(ctx, "code-pool-")
+	if err != nil || !reflect.DeepEqual(volumes, []string{"code-pool-python-1-src"}) {
+		t.Fatalf("ListVolumes() = %v, %v", volumes, err)
+	}
+
+	if err := client.RemoveContainer(ctx, ids[0]); err != nil {
+		t.Errorf("RemoveContainer() error = %v", err)
+	}
+	if err := client.RemoveVolume(ctx, volumes[0]); err != nil {
+		t.Errorf("RemoveVolume() error = %v", err)
+	}
+	if got := server.Containers(); !reflect.DeepEqual(got, []string{"code-exec-4"}) {
+		t.Errorf("containers = %v", got)
+	}
+	if got := server.Volumes(); len(got) != 0 {
+		t.Errorf("volumes = %v", got)
+	}
+}
+
+func TestCreateVolume(t *testing.T) {
+	client, server := newClient(t)
+	ctx := context.Background()
+
+	cfg := engine.VolumeConfig{
+		Name:       "code-exec-5-src",
+		Driver:     "local",
+		DriverOpts: map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=16m"},
+	}
+	if err := client.CreateVolume(ctx, cfg); err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if got, ok := server.Volume(cfg.Name); !ok || !reflect.DeepEqual(got, cfg) {
+		t.Errorf("Volume() = %+v, %v, want %+v", got, ok, cfg)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	client.CreateContainer(ctx, "code-exec-5", engine.ContainerConfig{})
+	_, err := client.CreateContainer(ctx, "code-exec-5", engine.ContainerConfig{})
+
+	var apiErr *engine.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("CreateContainer() error = %v, want *engine.Error", err)
+	}
+	if apiErr.StatusCode != http.StatusConflict || !strings.Contains(apiErr.Message, "already in use") {
+		t.Errorf("error = %+v", apiErr)
+	}
+	if _, err := client.AttachContainer(ctx, "missing"); !engine.IsNotFound(err) {
+		t.Errorf("AttachContainer() error = %v, want not found", err)
+	}
+}
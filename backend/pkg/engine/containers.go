@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// ContainerConfig is the body of a container create request
+type ContainerConfig struct {
+	Image        string     `json:"Image"`
+	Cmd          []string   `json:"Cmd,omitempty"`
+	Env          []string   `json:"Env,omitempty"`
+	User         string     `json:"User,omitempty"`
+	AttachStdin  bool       `json:"AttachStdin"`
+	AttachStdout bool       `json:"AttachStdout"`
+	AttachStderr bool       `json:"AttachStderr"`
+	OpenStdin    bool       `json:"OpenStdin"`
+	StdinOnce    bool       `json:"StdinOnce"`
+	HostConfig   HostConfig `json:"HostConfig"`
+}
+
+// HostConfig holds the resource limits and isolation of a container
+type HostConfig struct {
+	NanoCPUs    int64    `json:"NanoCpus,omitempty"`
+	Memory      int64    `json:"Memory,omitempty"`
+	PidsLimit   *int64   `json:"PidsLimit,omitempty"`
+	NetworkMode string   `json:"NetworkMode,omitempty"`
+	CapDrop     []string `json:"CapDrop,omitempty"`
+	Ulimits     []Ulimit `json:"Ulimits,omitempty"`
+	Binds       []string `json:"Binds,omitempty"`
+
+	ReadonlyRootfs bool              `json:"ReadonlyRootfs,omitempty"`
+	Tmpfs          map[string]string `json:"Tmpfs,omitempty"`
+	SecurityOpt    []string          `json:"SecurityOpt,omitempty"`
+	Runtime        string            `json:"Runtime,omitempty"`
+}
+
+// VolumeConfig is the body of a volume create request
+type VolumeConfig struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver,omitempty"`
+	DriverOpts map[string]string `json:"DriverOpts,omitempty"`
+}
+
+// Ulimit is a resource limit set in the container
+type Ulimit struct {
+	Name string `json:"Name"`
+	Soft int64  `json:"Soft"`
+	Hard int64  `json:"Hard"`
+}
+
+// State is the state of a container reported by inspect
+type State struct {
+	Running   bool   `json:"Running"`
+	ExitCode  int    `json:"ExitCode"`
+	OOMKilled bool   `json:"OOMKilled"`
+	Error     string `json:"Error"`
+}
+
+// Stats is a sample of a container's resource usage
+type Stats struct {
+	MemoryUsage    uint64 `json:"memory_usage"`
+	MemoryMaxUsage uint64 `json:"memory_max_usage"`
+	CPUUsage       uint64 `json:"cpu_usage_ns"`
+	Pids           uint64 `json:"pids"`
+}
+
+// CreateContainer creates a container named name and returns its id
+func (c *Client) CreateContainer(ctx context.Context, name string, cfg ContainerConfig) (string, error) {
+	var resp struct {
+		ID string `json:"Id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, cfg, &resp)
+	return resp.ID, err
+}
+
+// AttachContainer attaches to the stdin, stdout and stderr of a container.
+// Attach before starting the container so no output is missed.
+func (c *Client) AttachContainer(ctx context.Context, id string) (*Stream, error) {
+	query := url.Values{"stream": {"1"}, "stdin": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	return c.hijack(ctx, "/containers/"+id+"/attach", query, nil)
+}
+
+// StartContainer starts a created container
+func (c *Client) StartContainer(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+// WaitContainer blocks until the container stops and returns its exit code
+func (c *Client) WaitContainer(ctx context.Context, id string) (int, error) {
+	var resp struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	err := c.do(ctx, http.MethodPost, "/containers/"+id+"/wait", nil, nil, &resp)
+	return resp.StatusCode, err
+}
+
+// InspectContainer returns the state of a container
+func (c *Client) InspectContainer(ctx context.Context, id string) (State, error) {
+	var resp struct {
+		State State `json:"State"`
+	}
+	err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, &resp)
+	return resp.State, err
+}
+
+// KillContainer sends signal, such as "SIGKILL", to the container's main
+// process
+func (c *Client) KillContainer(ctx context.Context, id, signal string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/kill", url.Values{"signal": {signal}}, nil, nil)
+}
+
+// RemoveContainer removes a container, killing it if it is running
+func (c *Client) RemoveContainer(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}}, nil, nil)
+}
+
+// ListContainers returns the ids of all containers whose name contains
+// name
+func (c *Client) ListContainers(ctx context.Context, name string) ([]string, error) {
+	var resp []struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/containers/json", listQuery(name, true), nil, &resp); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(resp))
+	for i, ct := range resp {
+		ids[i] = ct.ID
+	}
+	return ids, nil
+}
+
+// ContainerStats samples the resource usage of a running container
+func (c *Client) ContainerStats(ctx context.Context, id string) (Stats, error) {
+	var resp struct {
+		MemoryStats struct {
+			Usage    uint64 `json:"usage"`
+			MaxUsage uint64 `json:"max_usage"`
+		} `json:"memory_stats"`
+		CPUStats struct {
+			CPUUsage struct {
+				TotalUsage uint64 `json:"total_usage"`
+			} `json:"cpu_usage"`
+		} `json:"cpu_stats"`
+		PidsStats struct {
+			Current uint64 `json:"current"`
+		} `json:"pids_stats"`
+	}
+	query := url.Values{"stream": {"0"}, "one-shot": {"1"}}
+	if err := c.do(ctx, http.MethodGet, "/containers/"+id+"/stats", query, nil, &resp); err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		MemoryUsage:    resp.MemoryStats.Usage,
+		MemoryMaxUsage: resp.MemoryStats.MaxUsage,
+		CPUUsage:       resp.CPUStats.CPUUsage.TotalUsage,
+		Pids:           resp.PidsStats.Current,
+	}, nil
+}
+
+// CreateVolume creates a volume
+func (c *Client) CreateVolume(ctx context.Context, cfg VolumeConfig) error {
+	return c.do(ctx, http.MethodPost, "/volumes/create", nil, cfg, nil)
+}
+
+// RemoveVolume removes a volume
+func (c *Client) RemoveVolume(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/volumes/"+name, url.Values{"force": {"1"}}, nil, nil)
+}
+
+// ListVolumes returns the names of the volumes whose name contains name
+func (c *Client) ListVolumes(ctx context.Context, name string) ([]string, error) {
+	var resp struct {
+		Volumes []struct {
+			Name string `json:"Name"`
+		} `json:"Volumes"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/volumes", listQuery(name, false), nil, &resp); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(resp.Volumes))
+	for i, v := range resp.Volumes {
+		names[i] = v.Name
+	}
+	return names, nil
+}
+
+// listQuery filters a list request by name
+func listQuery(name string, all bool) url.Values {
+	filters, _ := json.Marshal(map[string][]string{"name": {name}})
+	query := url.Values{"filters": {string(filters)}}
+	if all {
+		query.Set("all", "1")
+	}
+	return query
+}
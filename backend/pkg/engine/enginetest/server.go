@@ -0,0 +1,618 @@
+// Package enginetest provides an in-process fake of the Docker Engine API
+// for tests. Containers and exec instances run a Program in a goroutine
+// instead of a process, over the same attach protocol as the Engine.
+package enginetest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tiakavousi/codeplayground/pkg/engine"
+)
+
+// Program runs a command of a container or exec instance. It reads the
+// attached stdin and must return once ctx is done, when the container is
+// killed. The returned state sets the exit code and OOM flag.
+type Program func(ctx context.Context, cmd []string, stdin io.Reader, stdout, stderr io.Writer) engine.State
+
+// Server is a fake Engine listening on a unix socket
+type Server struct {
+	// Host is the address to pass to engine.NewClient
+	Host string
+
+	// Program runs every container and exec instance. The default prints
+	// nothing and exits with 0, or blocks until killed for "sleep".
+	Program Program
+
+	// Stats is reported for every running container
+	Stats engine.Stats
+
+	srv *httptest.Server
+	dir string
+
+	mu         sync.Mutex
+	nextID     int
+	containers map[string]*Container
+	execs      map[string]*execInstance
+	volumes    map[string]engine.VolumeConfig
+	requests   []string
+}
+
+// Container is a container created on the fake Engine
+type Container struct {
+	ID     string
+	Name   string
+	Config engine.ContainerConfig
+
+	// Signals lists the signals sent with kill, in order
+	Signals []string
+
+	state    engine.State
+	started  bool
+	killedBy int
+	stream   net.Conn
+	reader   *bufio.Reader
+	cancel   context.CancelFunc
+	exited   chan struct{}
+	ctx      context.Context
+	removed  bool
+}
+
+type execInstance struct {
+	id        string
+	container *Container
+	config    engine.ExecConfig
+	state     engine.ExecState
+}
+
+// NewServer starts a fake Engine. It is stopped when the test ends.
+func NewServer(t testing.TB) *Server {
+	// Unix socket paths are short, so the socket does not go in TempDir
+	dir, err := os.MkdirTemp("", "engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Host:       "unix://" + socket,
+		dir:        dir,
+		containers: make(map[string]*Container),
+		execs:      make(map[string]*execInstance),
+		volumes:    make(map[string]engine.VolumeConfig),
+	}
+	s.srv = httptest.NewUnstartedServer(s.routes())
+	s.srv.Listener.Close()
+	s.srv.Listener = l
+	s.srv.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close kills every container and stops the server
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, c := range s.containers {
+		if c.cancel != nil {
+			c.cancel()
+		}
+	}
+	s.mu.Unlock()
+	s.srv.Close()
+	os.RemoveAll(s.dir)
+}
+
+// Container returns a container by name or id
+func (s *Server) Container(name string) (*Container, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.lookup(name)
+	return c, ok
+}
+
+// Containers returns the names of the containers that were not removed
+func (s *Server) Containers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for _, c := range s.containers {
+		if !c.removed {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// Volumes returns the names of the volumes that were not removed
+func (s *Server) Volumes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.volumes {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Volume returns a volume that was not removed. Volumes created by
+// binding them to a container have no driver.
+func (s *Server) Volume(name string) (engine.VolumeConfig, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.volumes[name]
+	return v, ok
+}
+
+// Requests returns the requests served, as "METHOD /path" without the API
+// version
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) lookup(name string) (*Container, bool) {
+	for _, c := range s.containers {
+		if !c.removed && (c.ID == name || c.Name == name) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	prefix := "/" + engine.APIVersion
+	handle := func(pattern string, h http.HandlerFunc) {
+		method, path, _ := strings.Cut(pattern, " ")
+		mux.HandleFunc(method+" "+prefix+path, func(w http.ResponseWriter, r *http.Request) {
+			s.mu.Lock()
+			s.requests = append(s.requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, prefix))
+			s.mu.Unlock()
+			h(w, r)
+		})
+	}
+
+	handle("POST /containers/create", s.create)
+	handle("GET /containers/json", s.list)
+	handle("POST /containers/{id}/attach", s.attach)
+	handle("POST /containers/{id}/start", s.start)
+	handle("POST /containers/{id}/wait", s.wait)
+	handle("GET /containers/{id}/json", s.inspect)
+	handle("POST /containers/{id}/kill", s.kill)
+	handle("GET /containers/{id}/stats", s.stats)
+	handle("DELETE /containers/{id}", s.remove)
+	handle("POST /containers/{id}/exec", s.createExec)
+	handle("POST /exec/{id}/start", s.startExec)
+	handle("GET /exec/{id}/json", s.inspectExec)
+	handle("POST /volumes/create", s.createVolume)
+	handle("GET /volumes", s.listVolumes)
+	handle("DELETE /volumes/{name}", s.removeVolume)
+	return mux
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	var cfg engine.ContainerConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := r.URL.Query().Get("name")
+	if _, exists := s.lookup(name); exists && name != "" {
+		writeError(w, http.StatusConflict, fmt.Sprintf("container name %s is already in use", name))
+		return
+	}
+	s.nextID++
+	c := &Container{
+		ID:     fmt.Sprintf("%064x", s.nextID),
+		Name:   name,
+		Config: cfg,
+		exited: make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	s.containers[c.ID] = c
+	for _, bind := range cfg.HostConfig.Binds {
+		volume, _, _ := strings.Cut(bind, ":")
+		if _, exists := s.volumes[volume]; !exists {
+			s.volumes[volume] = engine.VolumeConfig{Name: volume}
+		}
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"Id": c.ID})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	name := nameFilter(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []map[string]interface{}{}
+	for _, c := range s.containers {
+		if !c.removed && strings.Contains(c.Name, name) {
+			list = append(list, map[string]interface{}{"Id": c.ID, "Names": []string{"/" + c.Name}})
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) attach(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.Container(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+	// Held until the stream is stored, as the client may start the
+	// container as soon as it reads the response
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, reader, ok := hijack(w, r)
+	if !ok {
+		return
+	}
+	c.stream, c.reader = conn, reader
+}
+
+func (s *Server) start(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	c, ok := s.lookup(r.PathValue("id"))
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+	if c.started {
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	c.started = true
+	c.state.Running = true
+	conn, reader := c.stream, c.reader
+	s.mu.Unlock()
+
+	go func() {
+		state := s.run(c.ctx, c.Config.Cmd, conn, reader)
+		s.mu.Lock()
+		if c.killedBy != 0 {
+			state = engine.State{ExitCode: 128 + c.killedBy}
+		}
+		c.state = state
+		s.mu.Unlock()
+		close(c.exited)
+	}()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// run runs the program attached to conn, if any, and closes conn after
+func (s *Server) run(ctx context.Context, cmd []string, conn net.Conn, reader *bufio.Reader) engine.State {
+	program := s.Program
+	if program == nil {
+		program = defaultProgram
+	}
+
+	var stdin io.Reader = strings.NewReader("")
+	stdout, stderr := io.Discard, io.Discard
+	if conn != nil {
+		defer conn.Close()
+		stdin = reader
+		frames := &frameWriter{w: conn}
+		stdout, stderr = frames.stream(1), frames.stream(2)
+	}
+
+	done := make(chan engine.State, 1)
+	go func() {
+		done <- program(ctx, cmd, stdin, stdout, stderr)
+	}()
+	select {
+	case state := <-done:
+		return state
+	case <-ctx.Done():
+		// Killed programs that ignore ctx are abandoned
+		return engine.State{ExitCode: 137}
+	}
+}
+
+func (s *Server) wait(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.Container(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+	select {
+	case <-c.exited:
+	case <-r.Context().Done():
+		return
+	}
+	s.mu.Lock()
+	code := c.state.ExitCode
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]int{"StatusCode": code})
+}
+
+func (s *Server) inspect(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.lookup(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Id": c.ID, "Name": "/" + c.Name, "State": c.state})
+}
+
+// signalNumbers are the signals the fake Engine can deliver
+var signalNumbers = map[string]int{
+	"SIGHUP":  1,
+	"SIGINT":  2,
+	"SIGQUIT": 3,
+	"SIGKILL": 9,
+	"SIGUSR1": 10,
+	"SIGUSR2": 12,
+	"SIGTERM": 15,
+}
+
+// kill records the signal and ends the program as if it did not handle it
+func (s *Server) kill(w http.ResponseWriter, r *http.Request) {
+	signal := r.URL.Query().Get("signal")
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+	number, known := signalNumbers[signal]
+	if !known {
+		writeError(w, http.StatusBadRequest, "invalid signal: "+signal)
+		return
+	}
+
+	s.mu.Lock()
+	c, ok := s.lookup(r.PathValue("id"))
+	if !ok || !c.state.Running {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "container is not running")
+		return
+	}
+	c.Signals = append(c.Signals, signal)
+	c.killedBy = number
+	s.mu.Unlock()
+
+	c.cancel()
+	<-c.exited
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.lookup(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+	var stats engine.Stats
+	if c.state.Running {
+		stats = s.Stats
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"memory_stats": map[string]uint64{"usage": stats.MemoryUsage, "max_usage": stats.MemoryMaxUsage},
+		"cpu_stats":    map[string]interface{}{"cpu_usage": map[string]uint64{"total_usage": stats.CPUUsage}},
+		"pids_stats":   map[string]uint64{"current": stats.Pids},
+	})
+}
+
+func (s *Server) remove(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	c, ok := s.lookup(r.PathValue("id"))
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+	c.removed = true
+	started := c.started
+	s.mu.Unlock()
+
+	c.cancel()
+	if started {
+		<-c.exited
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createExec(w http.ResponseWriter, r *http.Request) {
+	var cfg engine.ExecConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.lookup(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+	if !c.state.Running {
+		writeError(w, http.StatusConflict, "container is not running")
+		return
+	}
+	s.nextID++
+	e := &execInstance{id: fmt.Sprintf("exec%060x", s.nextID), container: c, config: cfg}
+	s.execs[e.id] = e
+	writeJSON(w, http.StatusCreated, map[string]string{"Id": e.id})
+}
+
+func (s *Server) startExec(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	e, ok := s.execs[r.PathValue("id")]
+	if ok {
+		e.state.Running = true
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such exec instance: "+r.PathValue("id"))
+		return
+	}
+
+	conn, reader, ok := hijack(w, r)
+	if !ok {
+		return
+	}
+	// The stream ends when the program exits, after its state is set
+	state := s.run(e.container.ctx, e.config.Cmd, &deferredClose{Conn: conn}, reader)
+	s.mu.Lock()
+	e.state = engine.ExecState{ExitCode: state.ExitCode}
+	if state.OOMKilled {
+		e.container.state.OOMKilled = true
+	}
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *Server) inspectExec(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.execs[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such exec instance: "+r.PathValue("id"))
+		return
+	}
+	writeJSON(w, http.StatusOK, e.state)
+}
+
+func (s *Server) createVolume(w http.ResponseWriter, r *http.Request) {
+	var cfg engine.VolumeConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volumes[cfg.Name] = cfg
+	writeJSON(w, http.StatusCreated, cfg)
+}
+
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) {
+	name := nameFilter(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	volumes := []map[string]string{}
+	for v := range s.volumes {
+		if strings.Contains(v, name) {
+			volumes = append(volumes, map[string]string{"Name": v})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Volumes": volumes})
+}
+
+func (s *Server) removeVolume(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := r.PathValue("name")
+	if _, ok := s.volumes[name]; !ok {
+		writeError(w, http.StatusNotFound, "no such volume: "+name)
+		return
+	}
+	delete(s.volumes, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// defaultProgram exits at once, or sleeps until killed
+func defaultProgram(ctx context.Context, cmd []string, stdin io.Reader, stdout, stderr io.Writer) engine.State {
+	if len(cmd) > 0 && cmd[0] == "sleep" {
+		<-ctx.Done()
+		return engine.State{ExitCode: 137}
+	}
+	return engine.State{}
+}
+
+// nameFilter reads the name filter of a list request
+func nameFilter(r *http.Request) string {
+	var filters map[string][]string
+	json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+	if names := filters["name"]; len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// hijack takes over the connection as the Engine does for attach
+func hijack(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.Reader, bool) {
+	// The body must not be left in the buffer that becomes stdin
+	io.Copy(io.Discard, r.Body)
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "connection cannot be hijacked")
+		return nil, nil, false
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, false
+	}
+	fmt.Fprint(conn, "HTTP/1.1 101 UPGRADED\r\n"+
+		"Content-Type: application/vnd.docker.raw-stream\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: tcp\r\n\r\n")
+	return conn, rw.Reader, true
+}
+
+// deferredClose keeps the connection open when run returns, so the exec
+// state is set before the client sees the stream end
+type deferredClose struct {
+	net.Conn
+}
+
+func (deferredClose) Close() error {
+	return nil
+}
+
+// frameWriter multiplexes stdout and stderr onto the attach connection
+type frameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (f *frameWriter) stream(id byte) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if err := engine.WriteFrame(f.w, id, p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
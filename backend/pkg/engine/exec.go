@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+)
+
+// ExecConfig is the body of an exec create request
+type ExecConfig struct {
+	Cmd          []string `json:"Cmd"`
+	Env          []string `json:"Env,omitempty"`
+	AttachStdin  bool     `json:"AttachStdin"`
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+}
+
+// ExecState is the state of an exec instance reported by inspect
+type ExecState struct {
+	Running  bool `json:"Running"`
+	ExitCode int  `json:"ExitCode"`
+	Pid      int  `json:"Pid"`
+}
+
+// CreateExec prepares a command in a running container and returns the
+// exec id
+func (c *Client) CreateExec(ctx context.Context, container string, cfg ExecConfig) (string, error) {
+	var resp struct {
+		ID string `json:"Id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/containers/"+container+"/exec", nil, cfg, &resp)
+	return resp.ID, err
+}
+
+// StartExec starts an exec instance attached to its stdin and output
+func (c *Client) StartExec(ctx context.Context, id string) (*Stream, error) {
+	body := map[string]bool{"Detach": false, "Tty": false}
+	return c.hijack(ctx, "/exec/"+id+"/start", nil, body)
+}
+
+// InspectExec returns the state of an exec instance
+func (c *Client) InspectExec(ctx context.Context, id string) (ExecState, error) {
+	var state ExecState
+	err := c.do(ctx, http.MethodGet, "/exec/"+id+"/json", nil, nil, &state)
+	return state, err
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Stream types of the multiplexed attach protocol
+const (
+	streamStdin  = 0
+	streamStdout = 1
+	streamStderr = 2
+)
+
+// Stream is a hijacked attach or exec connection. Writes go to the
+// program's stdin; its output arrives multiplexed and is split by Demux.
+type Stream struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Write sends data to the program's stdin
+func (s *Stream) Write(p []byte) (int, error) {
+	return s.conn.Write(p)
+}
+
+// CloseWrite closes the program's stdin and keeps its output open
+func (s *Stream) CloseWrite() error {
+	if cw, ok := s.conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// Close closes the connection
+func (s *Stream) Close() error {
+	return s.conn.Close()
+}
+
+// Demux copies the program's output to stdout and stderr until the stream
+// ends. Each frame has an 8-byte header: the stream type, three unused
+// bytes and the big-endian payload size.
+func (s *Stream) Demux(stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(s.reader, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case streamStdin, streamStdout:
+			w = stdout
+		case streamStderr:
+			w = stderr
+		default:
+			return fmt.Errorf("docker engine: unknown stream type %d", header[0])
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, s.reader, size); err != nil {
+			return err
+		}
+	}
+}
+
+// WriteFrame writes one multiplexed frame, as the Engine does. It is used
+// by fake engines in tests.
+func WriteFrame(w io.Writer, stream byte, p []byte) error {
+	var header [8]byte
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(p)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(p)
+	return err
+}
@@ -0,0 +1,103 @@
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Limits on the arguments, environment and stdin of a request
+const (
+	MaxArgs      = 100
+	MaxArgLength = 4096
+	MaxEnv       = 50
+	MaxStdinSize = 1 << 20
+)
+
+// envNamePattern matches portable environment variable names
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// deniedEnv are variables that would change how the sandbox, the shell or
+// a language runtime loads code instead of being seen by the program
+var deniedEnv = map[string]bool{
+	"PATH":              true,
+	"HOME":              true,
+	"HOSTNAME":          true,
+	"USER":              true,
+	"SHELL":             true,
+	"IFS":               true,
+	"ENV":               true,
+	"BASH_ENV":          true,
+	"SHELLOPTS":         true,
+	"BASHOPTS":          true,
+	"CDPATH":            true,
+	"GLOBIGNORE":        true,
+	"PS4":               true,
+	"GCONV_PATH":        true,
+	"TMPDIR":            true,
+	"PYTHONPATH":        true,
+	"PYTHONHOME":        true,
+	"PYTHONSTARTUP":     true,
+	"PYTHONINSPECT":     true,
+	"NODE_OPTIONS":      true,
+	"NODE_PATH":         true,
+	"JAVA_TOOL_OPTIONS": true,
+	"_JAVA_OPTIONS":     true,
+	"JDK_JAVA_OPTIONS":  true,
+	"CLASSPATH":         true,
+}
+
+// deniedEnvPrefixes deny whole families of variables
+var deniedEnvPrefixes = []string{"LD_", "BASH_FUNC_", "DOCKER_", "GCC_", "COMPILER_PATH"}
+
+// ValidateArgs checks the program arguments
+func ValidateArgs(args []string) error {
+	if len(args) > MaxArgs {
+		return fmt.Errorf("too many arguments: %d (max %d)", len(args), MaxArgs)
+	}
+	for _, arg := range args {
+		if len(arg) > MaxArgLength {
+			return fmt.Errorf("argument is longer than %d bytes", MaxArgLength)
+		}
+		if strings.ContainsRune(arg, 0) {
+			return fmt.Errorf("argument contains a NUL byte")
+		}
+	}
+	return nil
+}
+
+// ValidateEnv checks the environment variables against the deny-list
+func ValidateEnv(env map[string]string) error {
+	if len(env) > MaxEnv {
+		return fmt.Errorf("too many environment variables: %d (max %d)", len(env), MaxEnv)
+	}
+	for name, value := range env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if EnvDenied(name) {
+			return fmt.Errorf("environment variable %s is not allowed", name)
+		}
+		if len(value) > MaxArgLength {
+			return fmt.Errorf("environment variable %s is longer than %d bytes", name, MaxArgLength)
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("environment variable %s contains a NUL byte", name)
+		}
+	}
+	return nil
+}
+
+// EnvDenied reports whether the variable may not be set by a request
+func EnvDenied(name string) bool {
+	upper := strings.ToUpper(name)
+	if deniedEnv[upper] {
+		return true
+	}
+	for _, prefix := range deniedEnvPrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,64 +6,206 @@ import (
 	"log"
 	"strings"
 	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/language"
 )
 
-// ExecRequest defines the input for code execution
+// ExecRequest defines the input for code execution.
+// Code is the content of the language's main source file. Files adds more
+// source files, and Entrypoint names the file to run when it is not the
+// main source file. Standard, Optimization and Flags choose compiler or
+// interpreter options from the language's allow-list. Args and Env are
+// passed to the program, and Stdin is written to its standard input before
+// any client input. Limits raises or lowers the run limits within the
+// bounds the server allows.
 type ExecRequest struct {
-	Language string `json:"language" binding:"required"`
-	Code     string `json:"code" binding:"required"`
+	Language     string            `json:"language" binding:"required"`
+	Code         string            `json:"code"`
+	Files        []File            `json:"files,omitempty"`
+	Entrypoint   string            `json:"entrypoint,omitempty"`
+	Standard     string            `json:"standard,omitempty"`
+	Optimization string            `json:"optimization,omitempty"`
+	Flags        []string          `json:"flags,omitempty"`
+	Args         []string          `json:"args,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	Stdin        string            `json:"stdin,omitempty"`
+	Limits       language.Limits   `json:"limits,omitempty"`
 }
 
-// CodeRunner interface defines methods that must be implemented by any code execution backend
+// MaxExecutionTime bounds a whole execution, compile and run phases
+// included, unless the service is given another timeout
+const MaxExecutionTime = 60 * time.Second
+
+// CodeRunner interface defines methods that must be implemented by any code execution backend.
+// RunInteractive reports how the program terminated even when it returns an error.
 type CodeRunner interface {
-	RunInteractive(ctx context.Context, req ExecRequest, input <-chan string, output chan<- string) error
+	RunInteractive(ctx context.Context, req ExecRequest, input <-chan Input, output chan<- Output) (ExecutionResult, error)
 }
 
 // Service represents the code execution service
 type Service struct {
-	runner CodeRunner
+	runner    CodeRunner
+	languages *language.Registry
+	timeout   time.Duration
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithTimeout sets how long a whole execution may take
+func WithTimeout(timeout time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.timeout = timeout
+	}
+}
+
+// WithLanguages sets the languages requests are validated against. It
+// should be the registry the runner uses.
+func WithLanguages(registry *language.Registry) ServiceOption {
+	return func(s *Service) {
+		s.languages = registry
+	}
 }
 
 // NewService creates a new executor service with the specified runner
-func NewService(runner CodeRunner) *Service {
-	return &Service{
-		runner: runner,
+func NewService(runner CodeRunner, opts ...ServiceOption) *Service {
+	s := &Service{
+		runner:    runner,
+		languages: language.Default(),
+		timeout:   MaxExecutionTime,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // ExecuteInteractive runs code with interactive I/O
 func (s *Service) ExecuteInteractive(
-	ctx context.Context, req ExecRequest, 
-	input <-chan string, output chan<- string) error {
+	ctx context.Context, req ExecRequest,
+	input <-chan Input, output chan<- Output) (ExecutionResult, error) {
 	// Validate request
-	if err := validateRequest(req); err != nil {
-		return fmt.Errorf("invalid request: %w", err)
+	if err := validateRequest(req, s.languages); err != nil {
+		return ExecutionResult{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
 
-	// Create execution context
-	execCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	// The runner enforces the limit of each phase; this bounds them all
+	execCtx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
+	// Prepared stdin is written before anything the client sends
+	if req.Stdin != "" {
+		input = withStdin(execCtx, req.Stdin, input)
+	}
+
 	// Run the code
-	err := s.runner.RunInteractive(execCtx, req, input, output)
+	start := time.Now()
+	result, err := s.runner.RunInteractive(execCtx, req, input, output)
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.TimedOut = result.TimedOut || execCtx.Err() == context.DeadlineExceeded
+	result.Cancelled = ctx.Err() == context.Canceled
+	result.Reason = result.terminationReason()
 	if err != nil {
 		log.Printf("Execution error for language %s: %v", req.Language, err)
-		if execCtx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("execution timed out after 10 seconds")
+		if result.TimedOut {
+			err = ErrExecutionTimeout
+		} else {
+			err = fmt.Errorf("execution error: %w", err)
 		}
-		return fmt.Errorf("execution error: %w", err)
+		result.Error = err.Error()
+		return result, err
 	}
 
-	return nil
+	return result, nil
 }
 
-// validateRequest checks if the request is valid
-func validateRequest(req ExecRequest) error {
+// Execute runs code to completion and returns its collected output. The
+// program's stdin is closed after the request's Stdin has been written.
+func (s *Service) Execute(ctx context.Context, req ExecRequest) (ExecutionResult, error) {
+	input := make(chan Input, 1)
+	input <- Input{Type: InputEOF}
+	close(input)
+
+	output := make(chan Output)
+	var stdout, stderr strings.Builder
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for out := range output {
+			switch out.Type {
+			case OutputStdout:
+				stdout.WriteString(out.Data)
+			case OutputStderr:
+				stderr.WriteString(out.Data)
+			}
+		}
+	}()
+
+	result, err := s.ExecuteInteractive(ctx, req, input, output)
+	close(output)
+	<-collected
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, err
+}
+
+// validateRequest checks if the request is valid. Only registered
+// languages are accepted, so nothing else in the image can be run.
+func validateRequest(req ExecRequest, languages *language.Registry) error {
 	if strings.TrimSpace(req.Language) == "" {
 		return fmt.Errorf("language cannot be empty")
 	}
-	if strings.TrimSpace(req.Code) == "" {
+	if _, ok := languages.Lookup(req.Language); !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidLanguage, req.Language)
+	}
+	if strings.TrimSpace(req.Code) == "" && len(req.Files) == 0 {
 		return fmt.Errorf("code cannot be empty")
 	}
-	return nil
+	if req.Entrypoint != "" {
+		if err := validatePath(req.Entrypoint); err != nil {
+			return err
+		}
+	}
+	if len(req.Stdin) > MaxStdinSize {
+		return fmt.Errorf("stdin is larger than %d bytes", MaxStdinSize)
+	}
+	if err := ValidateArgs(req.Args); err != nil {
+		return err
+	}
+	if err := ValidateEnv(req.Env); err != nil {
+		return err
+	}
+	if err := req.Limits.Validate(); err != nil {
+		return err
+	}
+	return ValidateFiles(req.Files)
+}
+
+// withStdin returns a channel that delivers stdin ahead of the messages
+// read from input
+func withStdin(ctx context.Context, stdin string, input <-chan Input) <-chan Input {
+	merged := make(chan Input)
+	go func() {
+		defer close(merged)
+		msg := Input{Type: InputStdin, Data: stdin}
+		for {
+			select {
+			case merged <- msg:
+			case <-ctx.Done():
+				return
+			}
+
+			var ok bool
+			select {
+			case msg, ok = <-input:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return merged
 }
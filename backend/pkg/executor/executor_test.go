@@ -2,10 +2,13 @@ package executor
 
 import (
     "context"
+    "errors"
     "fmt"
     "strings"
     "testing"
     "time"
+
+    "github.com/tiakavousi/codeplayground/pkg/language"
 )
 
 // MockRunner implements CodeRunner interface for testing
@@ -57,24 +60,24 @@ func NewMockRunner() *MockRunner {
     }
 }
 
-func (m *MockRunner) RunInteractive(ctx context.Context, req ExecRequest, input <-chan string, output chan<- string) error {
+func (m *MockRunner) RunInteractive(ctx context.Context, req ExecRequest, input <-chan Input, output chan<- Output) (ExecutionResult, error) {
     // Special case for infinite loop
     if req.Language == "python" && strings.Contains(req.Code, "while True: pass") {
-        return m.responses["python-infinite"].err
+        return ExecutionResult{}, m.responses["python-infinite"].err
     }
 
     // Get the response based on language
     response, exists := m.responses[req.Language]
     if !exists {
-        return fmt.Errorf("unexpected language: %s", req.Language)
+        return ExecutionResult{}, fmt.Errorf("unexpected language: %s", req.Language)
     }
 
     if response.err != nil {
-        return response.err
+        return ExecutionResult{}, response.err
     }
 
-    output <- response.output
-    return nil
+    output <- Output{Type: OutputStdout, Data: response.output}
+    return ExecutionResult{}, nil
 }
 
 func TestExecuteCode(t *testing.T) {
@@ -173,8 +176,8 @@ int main() {
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
             // Create channels for communication
-            input := make(chan string)
-            output := make(chan string)
+            input := make(chan Input)
+            output := make(chan Output)
             
             // Create context with timeout
             ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -185,7 +188,8 @@ int main() {
             
             // Run ExecuteInteractive in goroutine
             go func() {
-                errCh <- service.ExecuteInteractive(ctx, tt.req, input, output)
+                _, err := service.ExecuteInteractive(ctx, tt.req, input, output)
+                errCh <- err
                 close(input)
             }()
 
@@ -193,7 +197,7 @@ int main() {
             var got string
             select {
             case line := <-output:
-                got = line
+                got = line.Data
             case err := <-errCh:
                 if (err != nil) != tt.wantErr {
                     t.Errorf("ExecuteInteractive() error = %v, wantErr %v", err, tt.wantErr)
@@ -250,19 +254,19 @@ func TestExecuteInteractive(t *testing.T) {
             ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
             defer cancel()
 
-            input := make(chan string, len(tt.input))
-            output := make(chan string, len(tt.expected))
+            input := make(chan Input, len(tt.input))
+            output := make(chan Output, len(tt.expected))
 
             // Send test input
             go func() {
                 for _, in := range tt.input {
-                    input <- in
+                    input <- Input{Type: InputStdin, Data: in}
                 }
                 close(input)
             }()
 
             // Run interactive execution
-            err := service.ExecuteInteractive(ctx, tt.req, input, output)
+            _, err := service.ExecuteInteractive(ctx, tt.req, input, output)
             if (err != nil) != tt.wantErr {
                 t.Errorf("ExecuteInteractive() error = %v, wantErr %v", err, tt.wantErr)
                 return
@@ -273,7 +277,7 @@ func TestExecuteInteractive(t *testing.T) {
                 for _, expected := range tt.expected {
                     select {
                     case got := <-output:
-                        if !strings.Contains(got, strings.TrimSpace(expected)) {
+                        if !strings.Contains(got.Data, strings.TrimSpace(expected)) {
                             t.Errorf("ExecuteInteractive() output = %v, want %v", got, expected)
                         }
                     case <-time.After(time.Second):
@@ -285,6 +289,176 @@ func TestExecuteInteractive(t *testing.T) {
     }
 }
 
+// blockingRunner runs until its context ends
+type blockingRunner struct{}
+
+func (blockingRunner) RunInteractive(ctx context.Context, req ExecRequest, input <-chan Input, output chan<- Output) (ExecutionResult, error) {
+    <-ctx.Done()
+    return ExecutionResult{ExitCode: 137, Signal: "SIGKILL"}, ctx.Err()
+}
+
+// TestExecuteInteractiveTermination tests how timeouts and cancellation are reported
+func TestExecuteInteractiveTermination(t *testing.T) {
+    service := NewService(blockingRunner{})
+    req := ExecRequest{Language: "python", Code: "while True: pass"}
+
+    t.Run("Timed Out", func(t *testing.T) {
+        ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+        defer cancel()
+
+        result, err := service.ExecuteInteractive(ctx, req, nil, nil)
+        if !errors.Is(err, ErrExecutionTimeout) {
+            t.Errorf("ExecuteInteractive() error = %v, want %v", err, ErrExecutionTimeout)
+        }
+        if !result.TimedOut || result.Cancelled || result.Reason != ReasonTimedOut {
+            t.Errorf("ExecuteInteractive() result = %+v, want timed out", result)
+        }
+    })
+
+    t.Run("Cancelled", func(t *testing.T) {
+        ctx, cancel := context.WithCancel(context.Background())
+        time.AfterFunc(50*time.Millisecond, cancel)
+
+        result, err := service.ExecuteInteractive(ctx, req, nil, nil)
+        if err == nil {
+            t.Error("ExecuteInteractive() expected error")
+        }
+        if result.TimedOut || !result.Cancelled || result.Reason != ReasonCancelled {
+            t.Errorf("ExecuteInteractive() result = %+v, want cancelled", result)
+        }
+    })
+}
+
+// compileErrorRunner fails to build every program
+type compileErrorRunner struct{}
+
+func (compileErrorRunner) RunInteractive(ctx context.Context, req ExecRequest, input <-chan Input, output chan<- Output) (ExecutionResult, error) {
+    output <- Output{Type: OutputPhase, Data: PhaseCompiling}
+    return ExecutionResult{ExitCode: 1, Compile: &CompileResult{Output: "error: expected ';'\n", ExitCode: 1}}, nil
+}
+
+// TestExecuteCompileError tests that compiler output stays out of stdout and stderr
+func TestExecuteCompileError(t *testing.T) {
+    service := NewService(compileErrorRunner{})
+
+    result, err := service.Execute(context.Background(), ExecRequest{Language: "c", Code: "int main() {}"})
+    if err != nil {
+        t.Fatalf("Execute() error = %v", err)
+    }
+    if result.Reason != ReasonCompileError || result.Stdout != "" || result.Stderr != "" {
+        t.Errorf("Execute() result = %+v, want compile error", result)
+    }
+    if result.Compile == nil || result.Compile.Output != "error: expected ';'\n" {
+        t.Errorf("Execute() compile = %+v", result.Compile)
+    }
+}
+
+// truncatedRunner reports a program killed at its output limit
+type truncatedRunner struct{}
+
+func (truncatedRunner) RunInteractive(ctx context.Context, req ExecRequest, input <-chan Input, output chan<- Output) (ExecutionResult, error) {
+    output <- Output{Type: OutputStdout, Data: "x\n"}
+    output <- Output{Type: OutputStatus, Data: "output truncated after 2 bytes"}
+    return ExecutionResult{ExitCode: 137, Signal: "SIGKILL", OutputTruncated: true}, nil
+}
+
+// TestExecuteOutputLimit tests that a kill at the output limit is reported as such
+func TestExecuteOutputLimit(t *testing.T) {
+    service := NewService(truncatedRunner{})
+
+    result, err := service.Execute(context.Background(), ExecRequest{Language: "python", Code: "while True: print('x')"})
+    if err != nil {
+        t.Fatalf("Execute() error = %v", err)
+    }
+    if result.Reason != ReasonOutputLimit || result.Stdout != "x\n" {
+        t.Errorf("Execute() result = %+v, want output limit", result)
+    }
+}
+
+// echoRunner copies stdin to stdout until EOF and reports on stderr
+type echoRunner struct{}
+
+func (echoRunner) RunInteractive(ctx context.Context, req ExecRequest, input <-chan Input, output chan<- Output) (ExecutionResult, error) {
+    for msg := range input {
+        switch msg.Type {
+        case InputStdin:
+            output <- Output{Type: OutputStdout, Data: msg.Data}
+        case InputEOF:
+            output <- Output{Type: OutputStatus, Data: "stdin closed"}
+            output <- Output{Type: OutputStderr, Data: "done\n"}
+            return ExecutionResult{ExitCode: 1}, nil
+        }
+    }
+    return ExecutionResult{}, fmt.Errorf("input closed before EOF")
+}
+
+// TestExecute tests non-interactive execution with prepared stdin
+func TestExecute(t *testing.T) {
+    service := NewService(echoRunner{})
+
+    result, err := service.Execute(context.Background(), ExecRequest{Language: "python", Code: "print(input())", Stdin: "a\nb\n"})
+    if err != nil {
+        t.Fatalf("Execute() error = %v", err)
+    }
+    if result.Stdout != "a\nb\n" || result.Stderr != "done\n" || result.ExitCode != 1 {
+        t.Errorf("Execute() result = %+v", result)
+    }
+
+    _, err = service.Execute(context.Background(), ExecRequest{Language: "python", Code: ""})
+    if !errors.Is(err, ErrInvalidRequest) {
+        t.Errorf("Execute() error = %v, want %v", err, ErrInvalidRequest)
+    }
+}
+
+// TestExecuteInteractivePreparedStdin tests that prepared stdin comes first
+func TestExecuteInteractivePreparedStdin(t *testing.T) {
+    service := NewService(echoRunner{})
+
+    input := make(chan Input, 2)
+    input <- Input{Type: InputStdin, Data: "typed\n"}
+    input <- Input{Type: InputEOF}
+    close(input)
+    output := make(chan Output, 10)
+
+    req := ExecRequest{Language: "python", Code: "print(input())", Stdin: "canned\n"}
+    if _, err := service.ExecuteInteractive(context.Background(), req, input, output); err != nil {
+        t.Fatalf("ExecuteInteractive() error = %v", err)
+    }
+    close(output)
+
+    var stdout strings.Builder
+    for out := range output {
+        if out.Type == OutputStdout {
+            stdout.WriteString(out.Data)
+        }
+    }
+    if stdout.String() != "canned\ntyped\n" {
+        t.Errorf("ExecuteInteractive() stdout = %q, want prepared stdin first", stdout.String())
+    }
+}
+
+// TestExecuteUnregisteredLanguage checks that the runner never sees a
+// language missing from the service's registry
+func TestExecuteUnregisteredLanguage(t *testing.T) {
+    registry, err := language.NewRegistry([]language.Language{
+        {ID: "python", SourceFile: "main.py", Run: []string{"python3", "main.py"}},
+    })
+    if err != nil {
+        t.Fatalf("NewRegistry() error = %v", err)
+    }
+    service := NewService(echoRunner{}, WithLanguages(registry))
+
+    for _, name := range []string{"bash", "perl", "sh -c"} {
+        _, err := service.Execute(context.Background(), ExecRequest{Language: name, Code: "echo hi"})
+        if !errors.Is(err, ErrInvalidLanguage) {
+            t.Errorf("Execute(%q) error = %v, want %v", name, err, ErrInvalidLanguage)
+        }
+    }
+    if _, err := service.Execute(context.Background(), ExecRequest{Language: "Python", Code: "print(1)"}); err != nil {
+        t.Errorf("Execute(\"Python\") error = %v", err)
+    }
+}
+
 // TestValidateRequest tests the request validation function
 func TestValidateRequest(t *testing.T) {
     tests := []struct {
@@ -324,11 +498,149 @@ func TestValidateRequest(t *testing.T) {
             },
             wantErr: true,
         },
+        {
+            name: "Files Without Code",
+            req: ExecRequest{
+                Language:   "python",
+                Files:      []File{{Path: "pkg/util.py", Content: "x = 1"}, {Path: "run.py", Content: "import pkg.util"}},
+                Entrypoint: "run.py",
+            },
+            wantErr: false,
+        },
+        {
+            name: "Duplicate File Paths",
+            req: ExecRequest{
+                Language: "c",
+                Files:    []File{{Path: "main.c"}, {Path: "main.c"}},
+            },
+            wantErr: true,
+        },
+        {
+            name: "Path Escapes Working Directory",
+            req: ExecRequest{
+                Language: "c",
+                Files:    []File{{Path: "../etc/passwd"}},
+            },
+            wantErr: true,
+        },
+        {
+            name: "Path Read As Flag",
+            req: ExecRequest{
+                Language: "c",
+                Files:    []File{{Path: "main.c"}, {Path: "-fplugin=x.so.c"}},
+            },
+            wantErr: true,
+        },
+        {
+            name: "Directory Read As Flag",
+            req: ExecRequest{
+                Language: "c",
+                Files:    []File{{Path: "main.c"}, {Path: "src/-specs=evil.c"}},
+            },
+            wantErr: true,
+        },
+        {
+            name: "Absolute Path",
+            req: ExecRequest{
+                Language: "c",
+                Files:    []File{{Path: "/main.c"}},
+            },
+            wantErr: true,
+        },
+        {
+            name: "Args And Env",
+            req: ExecRequest{
+                Language: "python",
+                Code:     "import sys; print(sys.argv)",
+                Args:     []string{"--verbose", "input file.txt"},
+                Env:      map[string]string{"GREETING": "hello", "lang": "en"},
+            },
+            wantErr: false,
+        },
+        {
+            name: "Denied Env",
+            req: ExecRequest{
+                Language: "python",
+                Code:     "print('test')",
+                Env:      map[string]string{"LD_PRELOAD": "/tmp/evil.so"},
+            },
+            wantErr: true,
+        },
+        {
+            name: "Denied Env Any Case",
+            req: ExecRequest{
+                Language: "python",
+                Code:     "print('test')",
+                Env:      map[string]string{"Path": "/tmp"},
+            },
+            wantErr: true,
+        },
+        {
+            name: "Invalid Env Name",
+            req: ExecRequest{
+                Language: "python",
+                Code:     "print('test')",
+                Env:      map[string]string{"A=B": "c"},
+            },
+            wantErr: true,
+        },
+        {
+            name: "NUL In Argument",
+            req: ExecRequest{
+                Language: "python",
+                Code:     "print('test')",
+                Args:     []string{"a\x00b"},
+            },
+            wantErr: true,
+        },
+        {
+            name: "Stdin Too Large",
+            req: ExecRequest{
+                Language: "python",
+                Code:     "print('test')",
+                Stdin:    strings.Repeat("x", MaxStdinSize+1),
+            },
+            wantErr: true,
+        },
+        {
+            name: "Unregistered Language",
+            req: ExecRequest{
+                Language: "perl",
+                Code:     "print 'test'",
+            },
+            wantErr: true,
+        },
+        {
+            name: "Bash",
+            req: ExecRequest{
+                Language: "bash",
+                Code:     "echo test",
+            },
+            wantErr: false,
+        },
+        {
+            name: "Unclean Entrypoint",
+            req: ExecRequest{
+                Language:   "python",
+                Code:       "print('test')",
+                Entrypoint: "./main.py",
+            },
+            wantErr: true,
+        },
+        {
+            name: "Invalid Limits",
+            req: ExecRequest{
+                Language: "python",
+                Code:     "print('test')",
+                Limits:   language.Limits{CPUs: "-1"},
+            },
+            wantErr: true,
+        },
     }
 
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
-            err := validateRequest(tt.req)
+            err := validateRequest(tt.req, language.Default())
             if (err != nil) != tt.wantErr {
                 t.Errorf("validateRequest() error = %v, wantErr %v", err, tt.wantErr)
             }
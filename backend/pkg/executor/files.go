@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Limits on the files of a multi-file request
+const (
+	MaxFiles      = 50
+	MaxPathLength = 255
+)
+
+// File is one source file of a multi-file request. Path is relative to the
+// program's working directory and uses forward slashes.
+type File struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// ValidateFiles checks that every path is a unique, clean relative path
+// that stays inside the working directory. Paths are passed to compilers
+// as arguments, so no segment may start with a dash and read as a flag.
+func ValidateFiles(files []File) error {
+	if len(files) > MaxFiles {
+		return fmt.Errorf("too many files: %d (max %d)", len(files), MaxFiles)
+	}
+
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		if err := validatePath(f.Path); err != nil {
+			return err
+		}
+		if seen[f.Path] {
+			return fmt.Errorf("duplicate file path %q", f.Path)
+		}
+		seen[f.Path] = true
+	}
+	return nil
+}
+
+func validatePath(p string) error {
+	switch {
+	case p == "":
+		return fmt.Errorf("file path cannot be empty")
+	case len(p) > MaxPathLength:
+		return fmt.Errorf("file path %q is longer than %d bytes", p, MaxPathLength)
+	case strings.ContainsAny(p, "\\\x00"):
+		return fmt.Errorf("file path %q contains invalid characters", p)
+	case path.IsAbs(p), path.Clean(p) != p, p == "..", strings.HasPrefix(p, "../"):
+		return fmt.Errorf("file path %q must be a clean relative path", p)
+	case strings.HasPrefix(p, "-"), strings.Contains(p, "/-"):
+		return fmt.Errorf("file path %q cannot start a name with a dash", p)
+	}
+	return nil
+}
@@ -1,8 +1,14 @@
 package executor
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
+	// ErrInvalidRequest is returned when the request fails validation
+	ErrInvalidRequest = errors.New("invalid request")
+
 	// ErrInvalidLanguage is returned when the requested language is not supported
 	ErrInvalidLanguage = errors.New("invalid or unsupported language")
 
@@ -13,9 +19,173 @@ var (
 	ErrExecutionTimeout = errors.New("execution timed out")
 )
 
+// Termination reasons reported in ExecutionResult
+const (
+	ReasonExited    = "exited"
+	ReasonSignaled  = "signaled"
+	ReasonOOMKilled = "oom_killed"
+	ReasonTimedOut  = "timed_out"
+	ReasonCancelled = "cancelled"
+
+	// ReasonCompileError means the program failed to compile and never ran
+	ReasonCompileError = "compile_error"
+
+	// ReasonOutputLimit means the program was killed for writing more
+	// output than its limits allow
+	ReasonOutputLimit = "output_limit"
+)
+
+// Phases of a run reported in status messages
+const (
+	PhaseCompiling = "compiling"
+	PhaseRunning   = "running"
+)
+
 // ExecutionResult represents the result of code execution
 type ExecutionResult struct {
-	Output   string `json:"output"`
-	ExitCode int    `json:"exit_code"`
-	Error    string `json:"error,omitempty"`
+	// Stdout and Stderr are only collected by Service.Execute
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+
+	ExitCode  int    `json:"exit_code"`
+	Signal    string `json:"signal,omitempty"`
+	OOMKilled bool   `json:"oom_killed"`
+	TimedOut  bool   `json:"timed_out"`
+	Cancelled bool   `json:"cancelled"`
+	Reason    string `json:"reason"`
+	Error     string `json:"error,omitempty"`
+
+	// OutputTruncated is set when the program's output was cut at its
+	// output limits and the program killed
+	OutputTruncated bool `json:"output_truncated,omitempty"`
+
+	// Compile is set for languages with a compile step. When compiling
+	// fails the exit code is the compiler's and the program does not run.
+	Compile *CompileResult `json:"compile,omitempty"`
+
+	// Diagnostics are the errors located in the source, parsed from the
+	// compiler output or from an interpreter's stack trace
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+
+	// Usage is sampled while the program runs, by runners that can
+	Usage *ResourceUsage `json:"usage,omitempty"`
+}
+
+// ResourceUsage is the peak memory and the CPU time used by a program
+type ResourceUsage struct {
+	MemoryPeakBytes uint64 `json:"memory_peak_bytes"`
+	CPUTimeMs       int64  `json:"cpu_time_ms"`
+}
+
+// Diagnostic severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNote    = "note"
+)
+
+// Diagnostic is one compiler or runtime message tied to a source location.
+// File is relative to the program's working directory; Line and Column
+// start at 1 and are 0 when unknown.
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// CompileResult reports the compile phase. Output holds the compiler's
+// diagnostics, kept apart from the program's output.
+type CompileResult struct {
+	Output     string `json:"output"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	ExitCode   int    `json:"exit_code"`
+	OOMKilled  bool   `json:"oom_killed"`
+	TimedOut   bool   `json:"timed_out"`
+}
+
+// Failed reports whether the program could not be built
+func (c *CompileResult) Failed() bool {
+	return c != nil && (c.ExitCode != 0 || c.OOMKilled || c.TimedOut)
+}
+
+// terminationReason names the most specific cause of termination
+func (r ExecutionResult) terminationReason() string {
+	switch {
+	case r.TimedOut:
+		return ReasonTimedOut
+	case r.Cancelled:
+		return ReasonCancelled
+	case r.Compile.Failed():
+		return ReasonCompileError
+	case r.OutputTruncated:
+		return ReasonOutputLimit
+	case r.OOMKilled:
+		return ReasonOOMKilled
+	case r.Signal != "":
+		return ReasonSignaled
+	default:
+		return ReasonExited
+	}
+}
+
+// OutputType identifies what a runner output message carries
+type OutputType string
+
+const (
+	// OutputStdout is data the program wrote to standard output
+	OutputStdout OutputType = "stdout"
+
+	// OutputStderr is data the program wrote to standard error
+	OutputStderr OutputType = "stderr"
+
+	// OutputStatus is a message from the runner itself, not the program
+	OutputStatus OutputType = "status"
+
+	// OutputPhase reports that the run entered the phase named in Data
+	OutputPhase OutputType = "phase"
+
+	// OutputDiagnostics carries the diagnostics parsed from a phase
+	OutputDiagnostics OutputType = "diagnostics"
+)
+
+// Output is a message produced while code is running
+type Output struct {
+	Type OutputType
+	Data string
+
+	// Elapsed is the time since the program started, read from the
+	// monotonic clock. It is set for stdout and stderr only.
+	Elapsed time.Duration
+
+	// Diagnostics is set for diagnostics messages only
+	Diagnostics []Diagnostic
+}
+
+// InputType identifies what a client input message asks the runner to do
+type InputType string
+
+const (
+	// InputStdin writes Data to the program's standard input
+	InputStdin InputType = "stdin"
+
+	// InputEOF closes the program's standard input
+	InputEOF InputType = "eof"
+
+	// InputSignal sends the signal named in Data to the program
+	InputSignal InputType = "signal"
+
+	// InputResize changes the terminal size to Cols x Rows
+	InputResize InputType = "resize"
+)
+
+// Input is a message sent to a running program
+type Input struct {
+	Type InputType
+	Data string
+	Cols int
+	Rows int
 }
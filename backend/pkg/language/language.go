@@ -0,0 +1,219 @@
+// Package language describes the languages the playground can execute and
+// provides a registry to look them up by id or alias.
+package language
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Placeholders expanded in compile and run steps
+const (
+	// CodePlaceholder is replaced by the submitted source in the run step
+	// of languages that take their program inline instead of from a file
+	CodePlaceholder = "{code}"
+
+	// SourcesPlaceholder is replaced by one argument per staged file with
+	// one of the language's extensions
+	SourcesPlaceholder = "{sources}"
+
+	// EntrypointPlaceholder is replaced by the path of the file to run
+	EntrypointPlaceholder = "{entrypoint}"
+
+	// MainPlaceholder is replaced by the entrypoint without its extension,
+	// such as the class name of a Java program
+	MainPlaceholder = "{main}"
+
+	// OptionsPlaceholder is replaced by one argument per option chosen in
+	// the request
+	OptionsPlaceholder = "{options}"
+)
+
+// Diagnostic formats the runner can parse. Compiler formats are read from
+// the compile output, interpreter formats from the program's stderr.
+const (
+	DiagnosticsGCC    = "gcc"
+	DiagnosticsJavac  = "javac"
+	DiagnosticsPython = "python"
+	DiagnosticsNode   = "node"
+)
+
+// Options lists the compiler or interpreter options a request may choose.
+// Anything not listed is rejected.
+type Options struct {
+	// Standards are the accepted values of -std=
+	Standards []string `json:"standards,omitempty" yaml:"standards,omitempty"`
+
+	// Optimizations are the accepted optimization levels such as O2,
+	// passed with a leading dash
+	Optimizations []string `json:"optimizations,omitempty" yaml:"optimizations,omitempty"`
+
+	// Flags are the other accepted arguments
+	Flags []string `json:"flags,omitempty" yaml:"flags,omitempty"`
+}
+
+// empty reports whether no option is accepted
+func (o Options) empty() bool {
+	return len(o.Standards) == 0 && len(o.Optimizations) == 0 && len(o.Flags) == 0
+}
+
+// Language describes how to build and run programs written in one language
+type Language struct {
+	ID         string   `json:"id" yaml:"id"`
+	Name       string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Aliases    []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	SourceFile string   `json:"source_file,omitempty" yaml:"source_file,omitempty"`
+	Extensions []string `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+	Compile    []string `json:"compile,omitempty" yaml:"compile,omitempty"`
+	Run        []string `json:"run" yaml:"run"`
+	Image      string   `json:"image,omitempty" yaml:"image,omitempty"`
+	Options    Options  `json:"options,omitempty" yaml:"options,omitempty"`
+
+	// Diagnostics names the format of the language's error output
+	Diagnostics string `json:"diagnostics,omitempty" yaml:"diagnostics,omitempty"`
+
+	// Version is a command that prints the version of the toolchain in
+	// the image, and Template is the code new programs start from
+	Version  []string `json:"version,omitempty" yaml:"version,omitempty"`
+	Template string   `json:"template,omitempty" yaml:"template,omitempty"`
+
+	// Limits apply to running the program, CompileLimits to compiling it
+	Limits        Limits `json:"limits,omitempty" yaml:"limits,omitempty"`
+	CompileLimits Limits `json:"compile_limits,omitempty" yaml:"compile_limits,omitempty"`
+}
+
+// DisplayName returns the name shown to users, the id if none is set
+func (l *Language) DisplayName() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return l.ID
+}
+
+// Extension returns the main file extension of the language, taken from
+// the extensions or else from the source file
+func (l *Language) Extension() string {
+	if len(l.Extensions) > 0 {
+		return l.Extensions[0]
+	}
+	return path.Ext(l.SourceFile)
+}
+
+// Names returns the id followed by every alias of the language
+func (l *Language) Names() []string {
+	return append([]string{l.ID}, l.Aliases...)
+}
+
+// validate checks that the language definition can be executed
+func (l *Language) validate() error {
+	if strings.TrimSpace(l.ID) == "" {
+		return fmt.Errorf("language id cannot be empty")
+	}
+	if len(l.Run) == 0 {
+		return fmt.Errorf("language %q: run step cannot be empty", l.ID)
+	}
+	if len(l.Compile) > 0 && l.SourceFile == "" {
+		return fmt.Errorf("language %q: compile step requires a source file", l.ID)
+	}
+	if strings.ContainsAny(l.SourceFile, "/\\") {
+		return fmt.Errorf("language %q: source file must be a plain file name", l.ID)
+	}
+	switch l.Diagnostics {
+	case "", DiagnosticsGCC, DiagnosticsJavac, DiagnosticsPython, DiagnosticsNode:
+	default:
+		return fmt.Errorf("language %q: unknown diagnostics format %q", l.ID, l.Diagnostics)
+	}
+	if err := l.Limits.Validate(); err != nil {
+		return fmt.Errorf("language %q: %w", l.ID, err)
+	}
+	if err := l.CompileLimits.Validate(); err != nil {
+		return fmt.Errorf("language %q: compile %w", l.ID, err)
+	}
+	if !l.Options.empty() && !contains(l.Compile, OptionsPlaceholder) && !contains(l.Run, OptionsPlaceholder) {
+		return fmt.Errorf("language %q: options require an %s placeholder", l.ID, OptionsPlaceholder)
+	}
+	return nil
+}
+
+// OptionArgs checks the requested options against the allow-list and
+// returns them as arguments: the standard, then the optimization level,
+// then the flags in request order
+func (l *Language) OptionArgs(standard, optimization string, flags []string) ([]string, error) {
+	var args []string
+	if standard != "" {
+		if !contains(l.Options.Standards, standard) {
+			return nil, fmt.Errorf("language %s does not support standard %q", l.ID, standard)
+		}
+		args = append(args, "-std="+standard)
+	}
+	if optimization != "" {
+		if !contains(l.Options.Optimizations, optimization) {
+			return nil, fmt.Errorf("language %s does not support optimization %q", l.ID, optimization)
+		}
+		args = append(args, "-"+optimization)
+	}
+
+	seen := make(map[string]bool, len(flags))
+	for _, flag := range flags {
+		if !contains(l.Options.Flags, flag) {
+			return nil, fmt.Errorf("language %s does not support flag %q", l.ID, flag)
+		}
+		if seen[flag] {
+			return nil, fmt.Errorf("duplicate flag %q", flag)
+		}
+		seen[flag] = true
+		args = append(args, flag)
+	}
+	return args, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources returns the paths with one of the language's extensions, sorted.
+// Without extensions only the entrypoint is a source.
+func (l *Language) Sources(paths []string, entrypoint string) []string {
+	if len(l.Extensions) == 0 {
+		return []string{entrypoint}
+	}
+
+	var sources []string
+	for _, p := range paths {
+		for _, ext := range l.Extensions {
+			if path.Ext(p) == ext {
+				sources = append(sources, p)
+				break
+			}
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// ExpandArgs replaces the placeholders in a compile or run step
+func ExpandArgs(args []string, entrypoint string, sources, options []string) []string {
+	main := strings.TrimSuffix(entrypoint, path.Ext(entrypoint))
+
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case SourcesPlaceholder:
+			expanded = append(expanded, sources...)
+		case OptionsPlaceholder:
+			expanded = append(expanded, options...)
+		default:
+			arg = strings.ReplaceAll(arg, EntrypointPlaceholder, entrypoint)
+			arg = strings.ReplaceAll(arg, MainPlaceholder, main)
+			expanded = append(expanded, arg)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,191 @@
+package language
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits defines the resources one phase of an execution may use.
+// Empty fields fall back to the runner defaults. CPUs and Memory use the
+// formats of docker run, such as "0.5" and "100m".
+type Limits struct {
+	CPUs    string        `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	Memory  string        `json:"memory,omitempty" yaml:"memory,omitempty"`
+	Pids    int           `json:"pids,omitempty" yaml:"pids,omitempty"`
+	Timeout time.Duration `json:"-" yaml:"timeout,omitempty"`
+
+	// OutputBytes and OutputLines bound what the program writes to stdout
+	// and stderr together. It is killed once it writes more.
+	OutputBytes int64 `json:"output_bytes,omitempty" yaml:"output_bytes,omitempty"`
+	OutputLines int   `json:"output_lines,omitempty" yaml:"output_lines,omitempty"`
+}
+
+// Validate checks that every field set is well formed
+func (l Limits) Validate() error {
+	if l.CPUs != "" {
+		if _, err := parseCPUs(l.CPUs); err != nil {
+			return err
+		}
+	}
+	if l.Memory != "" {
+		if _, err := parseMemory(l.Memory); err != nil {
+			return err
+		}
+	}
+	if l.Pids < 0 {
+		return fmt.Errorf("pids cannot be negative")
+	}
+	if l.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	if l.OutputBytes < 0 || l.OutputLines < 0 {
+		return fmt.Errorf("output limits cannot be negative")
+	}
+	return nil
+}
+
+// Merge returns the limits with every field set in override replaced
+func (l Limits) Merge(override Limits) Limits {
+	if override.CPUs != "" {
+		l.CPUs = override.CPUs
+	}
+	if override.Memory != "" {
+		l.Memory = override.Memory
+	}
+	if override.Pids > 0 {
+		l.Pids = override.Pids
+	}
+	if override.Timeout > 0 {
+		l.Timeout = override.Timeout
+	}
+	if override.OutputBytes > 0 {
+		l.OutputBytes = override.OutputBytes
+	}
+	if override.OutputLines > 0 {
+		l.OutputLines = override.OutputLines
+	}
+	return l
+}
+
+// Within reports an error for the first field that exceeds max. Fields
+// left empty in either are not compared.
+func (l Limits) Within(max Limits) error {
+	if l.CPUs != "" && max.CPUs != "" {
+		cpus, err := parseCPUs(l.CPUs)
+		if err != nil {
+			return err
+		}
+		maxCPUs, err := parseCPUs(max.CPUs)
+		if err != nil {
+			return err
+		}
+		if cpus > maxCPUs {
+			return fmt.Errorf("cpus %s exceeds the limit of %s", l.CPUs, max.CPUs)
+		}
+	}
+	if l.Memory != "" && max.Memory != "" {
+		memory, err := parseMemory(l.Memory)
+		if err != nil {
+			return err
+		}
+		maxMemory, err := parseMemory(max.Memory)
+		if err != nil {
+			return err
+		}
+		if memory > maxMemory {
+			return fmt.Errorf("memory %s exceeds the limit of %s", l.Memory, max.Memory)
+		}
+	}
+	if max.Pids > 0 && l.Pids > max.Pids {
+		return fmt.Errorf("pids %d exceeds the limit of %d", l.Pids, max.Pids)
+	}
+	if max.Timeout > 0 && l.Timeout > max.Timeout {
+		return fmt.Errorf("timeout %v exceeds the limit of %v", l.Timeout, max.Timeout)
+	}
+	if max.OutputBytes > 0 && l.OutputBytes > max.OutputBytes {
+		return fmt.Errorf("output of %d bytes exceeds the limit of %d", l.OutputBytes, max.OutputBytes)
+	}
+	if max.OutputLines > 0 && l.OutputLines > max.OutputLines {
+		return fmt.Errorf("output of %d lines exceeds the limit of %d", l.OutputLines, max.OutputLines)
+	}
+	return nil
+}
+
+// MarshalJSON reports the timeout in milliseconds like the other durations
+// of the API
+func (l Limits) MarshalJSON() ([]byte, error) {
+	type limits Limits
+	return json.Marshal(struct {
+		limits
+		TimeoutMs int64 `json:"timeout_ms,omitempty"`
+	}{limits(l), l.Timeout.Milliseconds()})
+}
+
+// UnmarshalJSON reads the timeout in milliseconds
+func (l *Limits) UnmarshalJSON(data []byte) error {
+	type limits Limits
+	var v struct {
+		limits
+		TimeoutMs int64 `json:"timeout_ms"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*l = Limits(v.limits)
+	l.Timeout = time.Duration(v.TimeoutMs) * time.Millisecond
+	return nil
+}
+
+// NanoCPUs returns CPUs in billionths of a CPU, as the Engine API takes
+// them, or 0 when unset or invalid
+func (l Limits) NanoCPUs() int64 {
+	cpus, err := parseCPUs(l.CPUs)
+	if err != nil {
+		return 0
+	}
+	return int64(cpus * 1e9)
+}
+
+// MemoryBytes returns Memory in bytes, or 0 when unset or invalid
+func (l Limits) MemoryBytes() int64 {
+	size, err := parseMemory(l.Memory)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// parseCPUs reads a positive number of CPUs
+func parseCPUs(s string) (float64, error) {
+	cpus, err := strconv.ParseFloat(s, 64)
+	if err != nil || cpus <= 0 {
+		return 0, fmt.Errorf("invalid cpus %q", s)
+	}
+	return cpus, nil
+}
+
+// memoryUnits are the suffixes docker accepts for memory sizes
+var memoryUnits = map[byte]int64{
+	'b': 1,
+	'k': 1 << 10,
+	'm': 1 << 20,
+	'g': 1 << 30,
+}
+
+// parseMemory reads a positive memory size in bytes
+func parseMemory(s string) (int64, error) {
+	number, unit := strings.ToLower(s), int64(1)
+	if n := len(number); n > 0 {
+		if u, ok := memoryUnits[number[n-1]]; ok {
+			number, unit = number[:n-1], u
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 || size > (1<<62)/unit {
+		return 0, fmt.Errorf("invalid memory %q", s)
+	}
+	return size * unit, nil
+}
@@ -0,0 +1,99 @@
+package language
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed languages.yaml
+var defaultDefinitions []byte
+
+// Registry holds the supported languages indexed by id and alias
+type Registry struct {
+	languages []*Language
+	byName    map[string]*Language
+}
+
+// definitions is the on-disk layout of a language file
+type definitions struct {
+	Languages []Language `json:"languages" yaml:"languages"`
+}
+
+// NewRegistry creates a registry from the given definitions.
+// Ids and aliases are case-insensitive and must be unique.
+func NewRegistry(langs []Language) (*Registry, error) {
+	r := &Registry{
+		byName: make(map[string]*Language),
+	}
+
+	for i := range langs {
+		lang := langs[i]
+		if err := lang.validate(); err != nil {
+			return nil, err
+		}
+		for _, name := range lang.Names() {
+			key := normalize(name)
+			if _, exists := r.byName[key]; exists {
+				return nil, fmt.Errorf("duplicate language name %q", name)
+			}
+			r.byName[key] = &lang
+		}
+		r.languages = append(r.languages, &lang)
+	}
+
+	if len(r.languages) == 0 {
+		return nil, fmt.Errorf("no languages defined")
+	}
+
+	return r, nil
+}
+
+// Parse creates a registry from a YAML or JSON document
+func Parse(data []byte) (*Registry, error) {
+	var defs definitions
+	if err := yaml.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("error parsing language definitions: %w", err)
+	}
+	return NewRegistry(defs.Languages)
+}
+
+// LoadFile creates a registry from a YAML or JSON file
+func LoadFile(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading language definitions: %w", err)
+	}
+	return Parse(data)
+}
+
+// Default returns a registry with the built-in language definitions
+func Default() *Registry {
+	r, err := Parse(defaultDefinitions)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in language definitions: %v", err))
+	}
+	return r
+}
+
+// Lookup finds a language by id or alias
+func (r *Registry) Lookup(name string) (*Language, bool) {
+	lang, ok := r.byName[normalize(name)]
+	return lang, ok
+}
+
+// Languages returns every registered language in definition order
+func (r *Registry) Languages() []Language {
+	langs := make([]Language, 0, len(r.languages))
+	for _, lang := range r.languages {
+		langs = append(langs, *lang)
+	}
+	return langs
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -0,0 +1,255 @@
+package language
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDefaultRegistry(t *testing.T) {
+	registry := Default()
+
+	tests := []struct {
+		name   string
+		wantID string
+	}{
+		{name: "python", wantID: "python"},
+		{name: "python3", wantID: "python"},
+		{name: "JS", wantID: "javascript"},
+		{name: "c++", wantID: "cpp"},
+		{name: "cpp", wantID: "cpp"},
+		{name: "java", wantID: "java"},
+		{name: "c", wantID: "c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lang, ok := registry.Lookup(tt.name)
+			if !ok {
+				t.Fatalf("Lookup(%q) not found", tt.name)
+			}
+			if lang.ID != tt.wantID {
+				t.Errorf("Lookup(%q) = %v, want %v", tt.name, lang.ID, tt.wantID)
+			}
+		})
+	}
+
+	if _, ok := registry.Lookup("perl"); ok {
+		t.Error("Lookup(\"perl\") found an unregistered language")
+	}
+
+	// Every built-in language is presented to users
+	for _, lang := range registry.Languages() {
+		if lang.Name == "" || lang.Extension() == "" || lang.Template == "" || len(lang.Version) == 0 {
+			t.Errorf("language %s lacks a name, extension, template or version command", lang.ID)
+		}
+	}
+}
+
+func TestLanguagePresentation(t *testing.T) {
+	lang := Language{ID: "ruby", SourceFile: "main.rb"}
+	if lang.DisplayName() != "ruby" || lang.Extension() != ".rb" {
+		t.Errorf("DisplayName(), Extension() = %q, %q, want id and source file extension", lang.DisplayName(), lang.Extension())
+	}
+
+	lang = Language{ID: "cpp", Name: "C++", SourceFile: "main.cpp", Extensions: []string{".cc", ".cpp"}}
+	if lang.DisplayName() != "C++" || lang.Extension() != ".cc" {
+		t.Errorf("DisplayName(), Extension() = %q, %q, want name and first extension", lang.DisplayName(), lang.Extension())
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		langs   []Language
+		wantErr bool
+	}{
+		{
+			name:  "Valid",
+			langs: []Language{{ID: "ruby", Aliases: []string{"rb"}, SourceFile: "main.rb", Run: []string{"ruby", "main.rb"}}},
+		},
+		{
+			name:    "Empty",
+			langs:   nil,
+			wantErr: true,
+		},
+		{
+			name:    "Missing Run Step",
+			langs:   []Language{{ID: "ruby"}},
+			wantErr: true,
+		},
+		{
+			name:    "Compile Without Source File",
+			langs:   []Language{{ID: "go", Compile: []string{"go", "build"}, Run: []string{"./main"}}},
+			wantErr: true,
+		},
+		{
+			name:    "Source File With Path",
+			langs:   []Language{{ID: "go", SourceFile: "../main.go", Run: []string{"go", "run", "main.go"}}},
+			wantErr: true,
+		},
+		{
+			name:    "Options Without Placeholder",
+			langs:   []Language{{ID: "c", SourceFile: "main.c", Compile: []string{"gcc", "main.c"}, Run: []string{"./a.out"}, Options: Options{Flags: []string{"-Wall"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown Diagnostics Format",
+			langs:   []Language{{ID: "go", Run: []string{"go", "run", "main.go"}, Diagnostics: "gofmt"}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid Memory Limit",
+			langs:   []Language{{ID: "python", Run: []string{"python3"}, Limits: Limits{Memory: "100 MB"}}},
+			wantErr: true,
+		},
+		{
+			name: "Duplicate Alias",
+			langs: []Language{
+				{ID: "c", Run: []string{"./main"}, Aliases: []string{"cc"}},
+				{ID: "cpp", Run: []string{"./main"}, Aliases: []string{"CC"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRegistry(tt.langs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRegistry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "languages.yaml")
+	yamlData := `
+languages:
+  - id: ruby
+    aliases: [rb]
+    source_file: main.rb
+    run: [ruby, main.rb]
+    image: ruby:3
+    limits:
+      memory: 200m
+`
+	if err := os.WriteFile(yamlPath, []byte(yamlData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonPath := filepath.Join(dir, "languages.json")
+	jsonData := `{"languages": [{"id": "ruby", "aliases": ["rb"], "source_file": "main.rb", "run": ["ruby", "main.rb"], "image": "ruby:3", "limits": {"memory": "200m"}}]}`
+	if err := os.WriteFile(jsonPath, []byte(jsonData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			registry, err := LoadFile(path)
+			if err != nil {
+				t.Fatalf("LoadFile() error = %v", err)
+			}
+			lang, ok := registry.Lookup("rb")
+			if !ok {
+				t.Fatal("Lookup(\"rb\") not found")
+			}
+			if lang.Image != "ruby:3" || lang.Limits.Memory != "200m" || lang.SourceFile != "main.rb" {
+				t.Errorf("LoadFile() language = %+v", lang)
+			}
+		})
+	}
+
+	if _, err := LoadFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("LoadFile() expected error for missing file")
+	}
+}
+
+func TestOptionArgs(t *testing.T) {
+	lang, _ := Default().Lookup("c")
+
+	tests := []struct {
+		name         string
+		standard     string
+		optimization string
+		flags        []string
+		want         []string
+		wantErr      bool
+	}{
+		{name: "None"},
+		{
+			name:         "Course Defaults",
+			standard:     "c17",
+			optimization: "O2",
+			flags:        []string{"-Wall", "-lm"},
+			want:         []string{"-std=c17", "-O2", "-Wall", "-lm"},
+		},
+		{name: "Unknown Standard", standard: "c++20", wantErr: true},
+		{name: "Unknown Optimization", optimization: "-O2", wantErr: true},
+		{name: "Unlisted Flag", flags: []string{"-fplugin=evil.so"}, wantErr: true},
+		{name: "Duplicate Flag", flags: []string{"-Wall", "-Wall"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lang.OptionArgs(tt.standard, tt.optimization, tt.flags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OptionArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OptionArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitsWithin(t *testing.T) {
+	max := Limits{CPUs: "1", Memory: "1g", Pids: 50, Timeout: 30 * time.Second, OutputBytes: 4096, OutputLines: 100}
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr bool
+	}{
+		{name: "Empty", limits: Limits{}},
+		{name: "At Max", limits: Limits{CPUs: "1.0", Memory: "1024m", Pids: 50, Timeout: 30 * time.Second}},
+		{name: "CPUs Above", limits: Limits{CPUs: "1.5"}, wantErr: true},
+		{name: "Memory Above", limits: Limits{Memory: "1025m"}, wantErr: true},
+		{name: "Memory In Bytes", limits: Limits{Memory: "1073741825"}, wantErr: true},
+		{name: "Pids Above", limits: Limits{Pids: 51}, wantErr: true},
+		{name: "Timeout Above", limits: Limits{Timeout: time.Minute}, wantErr: true},
+		{name: "Output Bytes Above", limits: Limits{OutputBytes: 4097}, wantErr: true},
+		{name: "Output Lines Above", limits: Limits{OutputLines: 101}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.limits.Within(max); (err != nil) != tt.wantErr {
+				t.Errorf("Within() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimitsJSON(t *testing.T) {
+	var limits Limits
+	if err := json.Unmarshal([]byte(`{"memory":"256m","timeout_ms":1500}`), &limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits != (Limits{Memory: "256m", Timeout: 1500 * time.Millisecond}) {
+		t.Errorf("json.Unmarshal() = %+v", limits)
+	}
+
+	data, err := json.Marshal(limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"memory":"256m","timeout_ms":1500}` {
+		t.Errorf("json.Marshal() = %s", data)
+	}
+}
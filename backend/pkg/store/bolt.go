@@ -0,0 +1,105 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// snippetsBucket holds every snippet as JSON keyed by id
+var snippetsBucket = []byte("snippets")
+
+// BoltStore keeps snippets in a single bbolt database file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the database file at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening snippet database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(snippetsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating snippet bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Save(snippet Snippet) error {
+	data, err := json.Marshal(snippet)
+	if err != nil {
+		return fmt.Errorf("error encoding snippet: %w", err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(snippetsBucket).Put([]byte(snippet.ID), data)
+	})
+}
+
+func (b *BoltStore) Get(id string) (Snippet, error) {
+	var snippet Snippet
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(snippetsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, &snippet); err != nil {
+			return fmt.Errorf("error decoding snippet: %w", err)
+		}
+		if snippet.Expired(time.Now()) {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return snippet, err
+}
+
+func (b *BoltStore) DeleteExpired(now time.Time) (int, error) {
+	var expired [][]byte
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(snippetsBucket)
+		err := bucket.ForEach(func(key, data []byte) error {
+			var snippet Snippet
+			if err := json.Unmarshal(data, &snippet); err != nil {
+				// One corrupt record must not keep the rest from expiring
+				log.Printf("Snippet sweep skipped %s: error decoding snippet: %v", key, err)
+				return nil
+			}
+			if snippet.Expired(now) {
+				// Keys are only valid for the transaction, and deleting
+				// while iterating would skip entries
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps snippets in memory. Everything is lost on restart, so
+// it is meant for tests and local development.
+type MemoryStore struct {
+	snippets map[string]Snippet
+	mu       sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		snippets: make(map[string]Snippet),
+	}
+}
+
+func (m *MemoryStore) Save(snippet Snippet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.snippets[snippet.ID] = snippet
+	return nil
+}
+
+func (m *MemoryStore) Get(id string) (Snippet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snippet, ok := m.snippets[id]
+	if !ok || snippet.Expired(time.Now()) {
+		return Snippet{}, ErrNotFound
+	}
+	return snippet, nil
+}
+
+func (m *MemoryStore) DeleteExpired(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for id, snippet := range m.snippets {
+		if snippet.Expired(now) {
+			delete(m.snippets, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package store persists saved code snippets behind a SnippetStore interface.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+)
+
+// ErrNotFound is returned when no snippet exists with the requested id
+var ErrNotFound = errors.New("snippet not found")
+
+// maxHistory bounds how many revisions History walks back
+const maxHistory = 1000
+
+// Snippet is a piece of code saved for sharing. Saving with a parent
+// creates the next revision of the parent; forking starts a new lineage
+// that only remembers where it was forked from. Files and Entrypoint hold
+// the rest of a multi-file project, as in executor.ExecRequest.
+type Snippet struct {
+	ID         string          `json:"id"`
+	Language   string          `json:"language"`
+	Code       string          `json:"code"`
+	Files      []executor.File `json:"files,omitempty"`
+	Entrypoint string          `json:"entrypoint,omitempty"`
+	ParentID   string          `json:"parent_id,omitempty"`
+	ForkedFrom string          `json:"forked_from,omitempty"`
+	Revision   int             `json:"revision"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ExpiresAt  *time.Time      `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the snippet has expired at the given time.
+// Snippets without an expiry never expire.
+func (s Snippet) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+// SnippetStore defines methods that must be implemented by any snippet storage backend
+type SnippetStore interface {
+	// Save stores the snippet under its id, replacing any existing one
+	Save(snippet Snippet) error
+
+	// Get returns the snippet with the given id or ErrNotFound.
+	// Expired snippets are reported as not found.
+	Get(id string) (Snippet, error)
+
+	// DeleteExpired removes every snippet expired at now and reports
+	// how many were removed
+	DeleteExpired(now time.Time) (int, error)
+
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// NextRevision returns next as a snippet that continues parent's history
+// The caller sets the expiry of the new revision.
+func NextRevision(parent Snippet, next Snippet) Snippet {
+	next.ParentID = parent.ID
+	next.ForkedFrom = ""
+	next.Revision = parent.Revision + 1
+	return next
+}
+
+// Fork returns a copy of source that starts a new lineage.
+// The caller sets the expiry of the fork.
+func Fork(source Snippet, id string, createdAt time.Time) Snippet {
+	return Snippet{
+		ID:         id,
+		Language:   source.Language,
+		Code:       source.Code,
+		Files:      append([]executor.File(nil), source.Files...),
+		Entrypoint: source.Entrypoint,
+		ForkedFrom: source.ID,
+		Revision:   1,
+		CreatedAt:  createdAt,
+	}
+}
+
+// History returns the revisions leading to the snippet with the given id,
+// oldest first and ending with the snippet itself
+func History(s SnippetStore, id string) ([]Snippet, error) {
+	var revisions []Snippet
+	for next := id; next != "" && len(revisions) < maxHistory; {
+		snippet, err := s.Get(next)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) && len(revisions) > 0 {
+				// An earlier revision is gone; report what is left
+				break
+			}
+			return nil, err
+		}
+		revisions = append(revisions, snippet)
+		next = snippet.ParentID
+	}
+
+	for i, j := 0, len(revisions)-1; i < j; i, j = i+1, j-1 {
+		revisions[i], revisions[j] = revisions[j], revisions[i]
+	}
+	return revisions, nil
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiakavousi/codeplayground/pkg/executor"
+	bolt "go.etcd.io/bbolt"
+)
+
+// testStores returns a fresh instance of every store implementation
+func testStores(t *testing.T) map[string]SnippetStore {
+	t.Helper()
+
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "snippets.db"))
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+
+	return map[string]SnippetStore{
+		"Memory": NewMemoryStore(),
+		"Bolt":   bolt,
+	}
+}
+
+func TestSnippetStore(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			want := Snippet{
+				ID:        "abc",
+				Language:  "python",
+				Code:      "print('hello')",
+				Files:     []executor.File{{Path: "util/helpers.py", Content: "x = 1"}},
+				CreatedAt: time.Now().UTC().Truncate(time.Second),
+			}
+			if err := s.Save(want); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+
+			got, err := s.Get("abc")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Get() = %+v, want %+v", got, want)
+			}
+
+			if _, err := s.Get("missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestBoltStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snippets.db")
+
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	if err := s.Save(Snippet{ID: "abc", Language: "c", Code: "int main() {}"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	s.Close()
+
+	s, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore() reopen error = %v", err)
+	}
+	defer s.Close()
+
+	got, err := s.Get("abc")
+	if err != nil || got.Code != "int main() {}" {
+		t.Errorf("Get() after reopen = %+v, %v", got, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Now()
+
+	root := Snippet{ID: "a", Language: "c", Code: "v1", Revision: 1, CreatedAt: now}
+	second := NextRevision(root, Snippet{ID: "b", Language: "c", Code: "v2", CreatedAt: now})
+	third := NextRevision(second, Snippet{ID: "c", Language: "c", Code: "v3", CreatedAt: now})
+	fork := Fork(second, "d", now)
+	for _, snippet := range []Snippet{root, second, third, fork} {
+		s.Save(snippet)
+	}
+
+	tests := []struct {
+		id   string
+		want []string
+	}{
+		{id: "a", want: []string{"a"}},
+		{id: "c", want: []string{"a", "b", "c"}},
+		{id: "d", want: []string{"d"}},
+	}
+
+	for _, tt := range tests {
+		revisions, err := History(s, tt.id)
+		if err != nil {
+			t.Fatalf("History(%q) error = %v", tt.id, err)
+		}
+		var got []string
+		for _, r := range revisions {
+			got = append(got, r.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("History(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+
+	if third.Revision != 3 || fork.Revision != 1 || fork.ForkedFrom != "b" || fork.Code != "v2" {
+		t.Errorf("unexpected lineage: third = %+v, fork = %+v", third, fork)
+	}
+
+	if _, err := History(s, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("History() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			live, expired1, expired2 := now.Add(time.Hour), now.Add(-time.Minute), now.Add(-time.Hour)
+			s.Save(Snippet{ID: "forever", Code: "a"})
+			s.Save(Snippet{ID: "live", Code: "b", ExpiresAt: &live})
+			s.Save(Snippet{ID: "expired1", Code: "c", ExpiresAt: &expired1})
+			s.Save(Snippet{ID: "expired2", Code: "d", ExpiresAt: &expired2})
+
+			if _, err := s.Get("expired1"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() expired snippet error = %v, want %v", err, ErrNotFound)
+			}
+
+			deleted, err := s.DeleteExpired(now)
+			if err != nil {
+				t.Fatalf("DeleteExpired() error = %v", err)
+			}
+			if deleted != 2 {
+				t.Errorf("DeleteExpired() = %d, want 2", deleted)
+			}
+			for _, id := range []string{"forever", "live"} {
+				if _, err := s.Get(id); err != nil {
+					t.Errorf("Get(%q) after sweep error = %v", id, err)
+				}
+			}
+
+			// Once the sweep is past "live" it is purged too
+			deleted, _ = s.DeleteExpired(now.Add(2 * time.Hour))
+			if deleted != 1 {
+				t.Errorf("DeleteExpired() later = %d, want 1", deleted)
+			}
+		})
+	}
+}
+
+func TestBoltDeleteExpiredSkipsUndecodable(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "snippets.db"))
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	s.Save(Snippet{ID: "a", Code: "a", ExpiresAt: &expired})
+	s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(snippetsBucket).Put([]byte("b"), []byte("{"))
+	})
+	s.Save(Snippet{ID: "c", Code: "c", ExpiresAt: &expired})
+
+	deleted, err := s.DeleteExpired(now)
+	if err != nil {
+		t.Fatalf("DeleteExpired() error = %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeleteExpired() = %d, want both expired snippets around the corrupt one", deleted)
+	}
+}
+
+func TestSnippetWithoutExpiryJSON(t *testing.T) {
+	data, err := json.Marshal(Snippet{ID: "abc", Code: "a"})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if strings.Contains(string(data), "expires_at") {
+		t.Errorf("json.Marshal() = %s, want expires_at omitted", data)
+	}
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Sweep deletes expired snippets from s every interval until ctx is done
+func Sweep(ctx context.Context, s SnippetStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			deleted, err := s.DeleteExpired(now)
+			if err != nil {
+				log.Printf("Snippet sweep error: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d expired snippets", deleted)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}